@@ -14,12 +14,11 @@ import (
 
 func main() {
 	conf := &config.Config{
-		RTMPPort:      1935,
-		HTTPRelayPort: 9090,
+		RTMPPort: 1935,
 	}
 
 	rtmpServer := rtmp.NewRTMPServer()
-	relay := service.NewRelay(rtmpServer)
+	relay := service.NewRelay(rtmpServer, nil, nil, nil, nil)
 
 	err := rtmpServer.Start(conf, nil)
 	if err != nil {
@@ -29,6 +28,7 @@ func main() {
 	if err != nil {
 		panic(fmt.Sprintf("Failed starting RTMP relay %s", err))
 	}
+	logger.Infow("RTMP relay started", "socket", conf.HTTPRelaySocket)
 
 	killChan := make(chan os.Signal, 1)
 	signal.Notify(killChan, syscall.SIGINT)
@@ -36,4 +36,5 @@ func main() {
 	sig := <-killChan
 	logger.Infow("exit requested, shutting down", "signal", sig)
 	rtmpServer.Stop()
+	relay.Stop()
 }
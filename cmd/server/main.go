@@ -8,17 +8,23 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"runtime"
 	"syscall"
 	"time"
 
 	"github.com/urfave/cli/v2"
 	"google.golang.org/protobuf/encoding/protojson"
 
+	"github.com/livekit/ingress/pkg/benchmark"
 	"github.com/livekit/ingress/pkg/config"
 	"github.com/livekit/ingress/pkg/errors"
 	"github.com/livekit/ingress/pkg/params"
 	"github.com/livekit/ingress/pkg/rtmp"
 	"github.com/livekit/ingress/pkg/service"
+	"github.com/livekit/ingress/pkg/srt"
+	"github.com/livekit/ingress/pkg/standalone"
+	"github.com/livekit/ingress/pkg/types"
+	"github.com/livekit/ingress/pkg/udp"
 	"github.com/livekit/ingress/pkg/whip"
 	"github.com/livekit/ingress/version"
 	"github.com/livekit/protocol/livekit"
@@ -59,6 +65,54 @@ func main() {
 				Action: runHandler,
 				Hidden: true,
 			},
+			{
+				Name:        "benchmark",
+				Usage:       "measure the CPU and memory used by each stream, and recommend cost values",
+				Description: "transcodes a generated clip with each video preset at increasing concurrency, without joining any room",
+				Flags: []cli.Flag{
+					&cli.StringSliceFlag{
+						Name:  "preset",
+						Usage: "video encoding preset to benchmark, all of them by default",
+					},
+					&cli.IntFlag{
+						Name:  "max-streams",
+						Usage: "highest number of concurrent streams",
+						Value: runtime.NumCPU(),
+					},
+					&cli.DurationFlag{
+						Name:  "warmup",
+						Usage: "time given to the streams to start before measuring them",
+						Value: 10 * time.Second,
+					},
+					&cli.DurationFlag{
+						Name:  "duration",
+						Usage: "measurement time of each concurrency level",
+						Value: 30 * time.Second,
+					},
+					&cli.BoolFlag{
+						Name:  "verbose",
+						Usage: "show the output of the stream processes",
+					},
+				},
+				Action: runBenchmark,
+			},
+			{
+				Name:        benchmark.StreamCommand,
+				Description: "runs a benchmark stream in a new process",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name: "config-body",
+					},
+					&cli.StringFlag{
+						Name: "preset",
+					},
+					&cli.StringFlag{
+						Name: "clip",
+					},
+				},
+				Action: runBenchmarkStream,
+				Hidden: true,
+			},
 		},
 		Flags: []cli.Flag{
 			&cli.StringFlag{
@@ -86,16 +140,18 @@ func runService(c *cli.Context) error {
 		return err
 	}
 
-	rc, err := redis.GetRedisClient(conf.Redis)
+	bus, err := getMessageBus(conf)
 	if err != nil {
 		return err
 	}
 
-	bus := psrpc.NewRedisMessageBus(rc)
-	psrpcClient, err := rpc.NewIOInfoClient(conf.NodeID, bus)
+	psrpcClient, err := getIOInfoClient(conf, bus)
 	if err != nil {
 		return err
 	}
+	if c, ok := psrpcClient.(*standalone.IOInfoClient); ok {
+		defer c.Stop()
+	}
 
 	stopChan := make(chan os.Signal, 1)
 	signal.Notify(stopChan, syscall.SIGTERM, syscall.SIGQUIT)
@@ -105,7 +161,9 @@ func runService(c *cli.Context) error {
 
 	var rtmpsrv *rtmp.RTMPServer
 	var whipsrv *whip.WHIPServer
-	if conf.RTMPPort > 0 {
+	var srtsrv *srt.SRTServer
+	var udpsrv *udp.UDPServer
+	if conf.RTMPPort > 0 || conf.RTMPSPort > 0 {
 		// Run RTMP server
 		rtmpsrv = rtmp.NewRTMPServer()
 	}
@@ -117,8 +175,26 @@ func runService(c *cli.Context) error {
 
 		whipsrv = whip.NewWHIPServer(psrpcWHIPClient)
 	}
+	if conf.SRTPort > 0 {
+		// Run SRT server
+		srtsrv = srt.NewSRTServer()
+	}
+	if len(conf.UDPInputs) > 0 {
+		// Run UDP listeners
+		udpsrv = udp.NewUDPServer()
+	}
 
-	svc := service.NewService(conf, psrpcClient, bus, whipsrv)
+	svc := service.NewService(conf, psrpcClient, bus, rtmpsrv, whipsrv)
+
+	relay := service.NewRelay(rtmpsrv, whipsrv, srtsrv, udpsrv, svc)
+
+	// Picks the relay socket the handler processes are given, before any ingress can be started
+	err = relay.Start(conf)
+	if err != nil {
+		return err
+	}
+	// Removes the relay socket and directory on every exit, graceful or not, including if the service fails to start
+	defer relay.Stop()
 
 	_, err = rpc.NewIngressInternalServer(conf.NodeID, svc, bus)
 	if err != nil {
@@ -130,8 +206,6 @@ func runService(c *cli.Context) error {
 		return err
 	}
 
-	relay := service.NewRelay(rtmpsrv, whipsrv)
-
 	if rtmpsrv != nil {
 		err = rtmpsrv.Start(conf, svc.HandleRTMPPublishRequest)
 		if err != nil {
@@ -139,15 +213,22 @@ func runService(c *cli.Context) error {
 		}
 	}
 	if whipsrv != nil {
-		err = whipsrv.Start(conf, svc.HandleWHIPPublishRequest, svc)
+		err = whipsrv.Start(conf, svc.HandleWHIPPublishRequest, svc.HandleWebSocketPublishRequest, svc)
 		if err != nil {
 			return err
 		}
 	}
-
-	err = relay.Start(conf)
-	if err != nil {
-		return err
+	if srtsrv != nil {
+		err = srtsrv.Start(conf, svc.HandleSRTPublishRequest)
+		if err != nil {
+			return err
+		}
+	}
+	if udpsrv != nil {
+		err = udpsrv.Start(conf, svc.HandleUDPPublishRequest)
+		if err != nil {
+			return err
+		}
 	}
 
 	go func() {
@@ -159,13 +240,18 @@ func runService(c *cli.Context) error {
 		case sig := <-killChan:
 			logger.Infow("exit requested, stopping all ingress and shutting down", "signal", sig)
 			svc.Stop(true)
-			relay.Stop()
 			if rtmpsrv != nil {
 				rtmpsrv.Stop()
 			}
 			if whipsrv != nil {
 				whipsrv.Stop()
 			}
+			if srtsrv != nil {
+				srtsrv.Stop()
+			}
+			if udpsrv != nil {
+				udpsrv.Stop()
+			}
 
 		}
 	}()
@@ -181,6 +267,8 @@ func setupHealthHandlers(conf *config.Config, svc *service.Service) error {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", svc.HealthHandler)
 	mux.HandleFunc("/availability", svc.AvailabilityHandler)
+	mux.HandleFunc("/pull/", svc.PullHandler)
+	mux.HandleFunc("/admin/", svc.AdminHandler)
 
 	go func() {
 		_ = http.ListenAndServe(fmt.Sprintf(":%d", conf.HealthPort), mux)
@@ -192,7 +280,7 @@ func setupHealthHandlers(conf *config.Config, svc *service.Service) error {
 func runHandler(c *cli.Context) error {
 	conf, err := getConfig(c)
 	if err != nil {
-		return err
+		return handlerSetupError(err)
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
@@ -202,10 +290,10 @@ func runHandler(c *cli.Context) error {
 	defer span.End()
 	logger.Debugw("handler launched")
 
-	rc, err := redis.GetRedisClient(conf.Redis)
+	bus, err := getMessageBus(conf)
 	if err != nil {
 		span.RecordError(err)
-		return err
+		return handlerSetupError(err)
 	}
 
 	info := &livekit.IngressInfo{}
@@ -213,7 +301,7 @@ func runHandler(c *cli.Context) error {
 	err = protojson.Unmarshal([]byte(infoString), info)
 	if err != nil {
 		span.RecordError(err)
-		return err
+		return handlerSetupError(err)
 	}
 
 	extraParams := c.String("extra-params")
@@ -223,22 +311,33 @@ func runHandler(c *cli.Context) error {
 		whipParams := params.WhipExtraParams{}
 		err := json.Unmarshal([]byte(extraParams), &whipParams)
 		if err != nil {
-			return err
+			return handlerSetupError(err)
 		}
 		ep = &whipParams
+	case types.RTSPInput, types.URLInput:
+		pullParams := params.PullExtraParams{}
+		if extraParams != "" {
+			err := json.Unmarshal([]byte(extraParams), &pullParams)
+			if err != nil {
+				return handlerSetupError(err)
+			}
+		}
+		ep = &pullParams
 	}
 
 	token := c.String("token")
 
 	var handler interface {
 		Kill()
-		HandleIngress(ctx context.Context, info *livekit.IngressInfo, wsUrl, token string, extraParams any)
+		HandleIngress(ctx context.Context, info *livekit.IngressInfo, wsUrl, token string, extraParams any) error
 	}
 
-	bus := psrpc.NewRedisMessageBus(rc)
-	rpcClient, err := rpc.NewIOInfoClient(conf.NodeID, bus)
+	rpcClient, err := getIOInfoClient(conf, bus)
 	if err != nil {
-		return err
+		return handlerSetupError(err)
+	}
+	if c, ok := rpcClient.(*standalone.IOInfoClient); ok {
+		defer c.Stop()
 	}
 	handler = service.NewHandler(conf, rpcClient)
 
@@ -262,10 +361,108 @@ func runHandler(c *cli.Context) error {
 		wsUrl = c.String("ws-url")
 	}
 
-	handler.HandleIngress(ctx, info, wsUrl, token, ep)
+	if err = handler.HandleIngress(ctx, info, wsUrl, token, ep); err != nil {
+		// Tells the service the ingress failed, rather than the handler
+		return cli.Exit(err.Error(), service.HandlerExitCodePipelineError)
+	}
+
 	return nil
 }
 
+// handlerSetupError tells the service the handler could not be set up, so that it is not restarted or counted as crashed
+func handlerSetupError(err error) error {
+	return cli.Exit(err.Error(), service.HandlerExitCodeSetupError)
+}
+
+func runBenchmark(c *cli.Context) error {
+	conf, err := getBenchmarkConfig(c)
+	if err != nil {
+		return err
+	}
+
+	presets := params.VideoPresets
+	if names := c.StringSlice("preset"); len(names) > 0 {
+		presets = nil
+		for _, name := range names {
+			preset, err := getVideoPreset(name)
+			if err != nil {
+				return err
+			}
+			presets = append(presets, preset)
+		}
+	}
+
+	opts := &benchmark.Options{
+		Presets:    presets,
+		MaxStreams: c.Int("max-streams"),
+		Warmup:     c.Duration("warmup"),
+		Duration:   c.Duration("duration"),
+		Verbose:    c.Bool("verbose"),
+	}
+	if opts.MaxStreams <= 0 {
+		return fmt.Errorf("max-streams must be positive")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	results, err := benchmark.Run(ctx, conf, opts)
+	if len(results) > 0 {
+		// Including the levels measured before an interruption
+		benchmark.PrintResults(os.Stdout, results)
+	}
+
+	return err
+}
+
+func runBenchmarkStream(c *cli.Context) error {
+	conf, err := getBenchmarkConfig(c)
+	if err != nil {
+		return err
+	}
+
+	preset, err := getVideoPreset(c.String("preset"))
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT)
+	defer stop()
+
+	return benchmark.RunStream(ctx, conf, preset, c.String("clip"))
+}
+
+func getVideoPreset(name string) (livekit.IngressVideoEncodingPreset, error) {
+	preset, ok := livekit.IngressVideoEncodingPreset_value[name]
+	if !ok {
+		return 0, fmt.Errorf("unknown video preset %s", name)
+	}
+
+	return livekit.IngressVideoEncodingPreset(preset), nil
+}
+
+func getMessageBus(conf *config.Config) (psrpc.MessageBus, error) {
+	if conf.Standalone != nil {
+		// Only serves the RPCs within a process. The handlers cannot be reached from the service
+		return psrpc.NewLocalMessageBus(), nil
+	}
+
+	rc, err := redis.GetRedisClient(conf.Redis)
+	if err != nil {
+		return nil, err
+	}
+
+	return psrpc.NewRedisMessageBus(rc), nil
+}
+
+func getIOInfoClient(conf *config.Config, bus psrpc.MessageBus) (rpc.IOInfoClient, error) {
+	if conf.Standalone != nil {
+		return standalone.NewIOInfoClient(conf), nil
+	}
+
+	return rpc.NewIOInfoClient(conf.NodeID, bus)
+}
+
 func setupHandlerRPCHandlers(conf *config.Config, handler *service.Handler, bus psrpc.MessageBus, info *livekit.IngressInfo, ep any) error {
 	rpcServer, err := rpc.NewIngressHandlerServer(conf.NodeID, handler, bus)
 	if err != nil {
@@ -291,3 +488,17 @@ func getConfig(c *cli.Context) (*config.Config, error) {
 
 	return config.NewConfig(configBody)
 }
+
+// getBenchmarkConfig returns the config of the service if one is given. The benchmark runs offline, without redis
+func getBenchmarkConfig(c *cli.Context) (*config.Config, error) {
+	configBody := c.String("config-body")
+	if configBody == "" && c.String("config") != "" {
+		content, err := ioutil.ReadFile(c.String("config"))
+		if err != nil {
+			return nil, err
+		}
+		configBody = string(content)
+	}
+
+	return config.NewBenchmarkConfig(configBody)
+}
@@ -19,7 +19,7 @@ func main() {
 	}
 
 	whipServer := whip.NewWHIPServer()
-	relay := service.NewRelay(nil, whipServer)
+	relay := service.NewRelay(nil, whipServer, nil)
 
 	err := whipServer.Start(conf, func(streamKey, resourceId, sdpOffer string) error {
 		logger.Infow("new whip client", "streamKey", streamKey, "resourceId", resourceId)
@@ -0,0 +1,300 @@
+package benchmark
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"syscall"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/livekit/ingress/pkg/config"
+	"github.com/livekit/ingress/pkg/stats"
+	"github.com/livekit/protocol/livekit"
+	"github.com/livekit/protocol/logger"
+)
+
+const (
+	StreamCommand = "run-benchmark-stream"
+
+	sampleInterval = time.Second
+	stopTimeout    = 10 * time.Second
+
+	// Share of the CPUs of the host above which the streams may not be transcoded in real time anymore
+	saturationThreshold = 0.9
+)
+
+type Options struct {
+	Presets []livekit.IngressVideoEncodingPreset
+	// The number of concurrent streams is doubled from 1 up to MaxStreams, until the host is saturated
+	MaxStreams int
+	// Time given to the streams to start before measuring them
+	Warmup   time.Duration
+	Duration time.Duration
+	// Passes the output of the stream processes through
+	Verbose bool
+}
+
+// LevelResult is the resource usage of the streams of a preset at a concurrency level
+type LevelResult struct {
+	Streams int
+	// CPUs used by a stream, on average
+	CPU float64
+	// Highest resident memory of a stream
+	Memory uint64
+	// CPUs used by all the streams
+	TotalCPU  float64
+	Saturated bool
+}
+
+type PresetResult struct {
+	Preset livekit.IngressVideoEncodingPreset
+	Levels []*LevelResult
+	Err    error
+}
+
+// RecommendedCPUCost returns the highest CPU usage of a stream, at the concurrency levels the host could sustain
+func (r *PresetResult) RecommendedCPUCost() float64 {
+	var cost float64
+	for i, l := range r.Levels {
+		// The first level is kept even if saturated, there is nothing better to go by
+		if l.Saturated && i > 0 {
+			continue
+		}
+		if l.CPU > cost {
+			cost = l.CPU
+		}
+	}
+
+	return math.Ceil(cost*100) / 100
+}
+
+// RecommendedMemoryCost returns the highest memory usage of a stream, in MB
+func (r *PresetResult) RecommendedMemoryCost() uint64 {
+	var cost uint64
+	for _, l := range r.Levels {
+		if l.Memory > cost {
+			cost = l.Memory
+		}
+	}
+
+	return (cost + 1<<20 - 1) >> 20
+}
+
+type stream struct {
+	cmd  *exec.Cmd
+	done chan struct{}
+	err  error
+
+	cpuTime time.Duration
+	memory  uint64
+}
+
+type benchmark struct {
+	conf       []byte
+	opts       *Options
+	clip       string
+	executable string
+}
+
+// Run transcodes a generated clip with each preset, at increasing concurrency, and measures the CPU and memory used by
+// each stream
+func Run(ctx context.Context, conf *config.Config, opts *Options) ([]*PresetResult, error) {
+	confString, err := yaml.Marshal(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err := os.MkdirTemp("", "ingress-benchmark")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	b := &benchmark{
+		conf:       confString,
+		opts:       opts,
+		clip:       filepath.Join(dir, "clip.mkv"),
+		executable: executable,
+	}
+
+	logger.Infow("generating benchmark clip")
+	if err = generateClip(ctx, b.clip); err != nil {
+		return nil, err
+	}
+
+	results := make([]*PresetResult, 0, len(opts.Presets))
+	for _, preset := range opts.Presets {
+		r := &PresetResult{Preset: preset}
+		results = append(results, r)
+
+		for _, streams := range concurrencyLevels(opts.MaxStreams) {
+			l, err := b.runLevel(ctx, preset, streams)
+			if ctx.Err() != nil {
+				return results, ctx.Err()
+			}
+			if err != nil {
+				logger.Warnw("benchmark failed", err, "preset", preset.String(), "streams", streams)
+				r.Err = err
+				break
+			}
+
+			r.Levels = append(r.Levels, l)
+			if l.Saturated {
+				break
+			}
+		}
+	}
+
+	return results, nil
+}
+
+// concurrencyLevels returns the numbers of concurrent streams to measure, doubling up to max
+func concurrencyLevels(max int) []int {
+	var levels []int
+	for streams := 1; streams < max; streams *= 2 {
+		levels = append(levels, streams)
+	}
+
+	return append(levels, max)
+}
+
+func (b *benchmark) runLevel(ctx context.Context, preset livekit.IngressVideoEncodingPreset, streams int) (*LevelResult, error) {
+	logger.Infow("benchmarking preset", "preset", preset.String(), "streams", streams)
+
+	ss := make([]*stream, 0, streams)
+	defer func() {
+		for _, s := range ss {
+			s.stop()
+		}
+	}()
+
+	for i := 0; i < streams; i++ {
+		s, err := b.startStream(preset)
+		if err != nil {
+			return nil, err
+		}
+		ss = append(ss, s)
+	}
+
+	if err := watch(ctx, ss, b.opts.Warmup); err != nil {
+		return nil, err
+	}
+
+	startCPU := make([]time.Duration, len(ss))
+	for i, s := range ss {
+		cpuTime, err := stats.ReadProcessCPUTime(s.cmd.Process.Pid)
+		if err != nil {
+			return nil, err
+		}
+		startCPU[i] = cpuTime
+	}
+	start := time.Now()
+
+	if err := watch(ctx, ss, b.opts.Duration); err != nil {
+		return nil, err
+	}
+
+	for i, s := range ss {
+		cpuTime, err := stats.ReadProcessCPUTime(s.cmd.Process.Pid)
+		if err != nil {
+			return nil, err
+		}
+		s.cpuTime = cpuTime - startCPU[i]
+	}
+
+	return newLevelResult(ss, time.Since(start), runtime.NumCPU()), nil
+}
+
+func newLevelResult(ss []*stream, elapsed time.Duration, numCPU int) *LevelResult {
+	l := &LevelResult{
+		Streams: len(ss),
+	}
+	for _, s := range ss {
+		l.TotalCPU += s.cpuTime.Seconds() / elapsed.Seconds()
+		if s.memory > l.Memory {
+			l.Memory = s.memory
+		}
+	}
+	l.CPU = l.TotalCPU / float64(len(ss))
+	l.Saturated = l.TotalCPU >= saturationThreshold*float64(numCPU)
+
+	return l
+}
+
+func (b *benchmark) startStream(preset livekit.IngressVideoEncodingPreset) (*stream, error) {
+	cmd := exec.Command(b.executable,
+		StreamCommand,
+		"--config-body", string(b.conf),
+		"--preset", preset.String(),
+		"--clip", b.clip,
+	)
+	if b.opts.Verbose {
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	s := &stream{
+		cmd:  cmd,
+		done: make(chan struct{}),
+	}
+	go func() {
+		s.err = cmd.Wait()
+		close(s.done)
+	}()
+
+	return s, nil
+}
+
+func (s *stream) stop() {
+	_ = s.cmd.Process.Signal(syscall.SIGINT)
+
+	select {
+	case <-s.done:
+	case <-time.After(stopTimeout):
+		_ = s.cmd.Process.Kill()
+		<-s.done
+	}
+}
+
+// watch waits for the given duration, sampling the memory of the streams. Returns an error if one of them exits
+func watch(ctx context.Context, ss []*stream, d time.Duration) error {
+	ticker := time.NewTicker(sampleInterval)
+	defer ticker.Stop()
+	deadline := time.After(d)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-deadline:
+			return nil
+		case <-ticker.C:
+			for _, s := range ss {
+				select {
+				case <-s.done:
+					return fmt.Errorf("stream exited: %v", s.err)
+				default:
+				}
+
+				if memory, err := stats.ReadProcessMemory(s.cmd.Process.Pid); err == nil && memory > s.memory {
+					s.memory = memory
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package benchmark
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/protocol/livekit"
+)
+
+func TestConcurrencyLevels(t *testing.T) {
+	require.Equal(t, []int{1}, concurrencyLevels(1))
+	require.Equal(t, []int{1, 2, 4, 8}, concurrencyLevels(8))
+	require.Equal(t, []int{1, 2, 4, 8, 12}, concurrencyLevels(12))
+}
+
+func TestLevelResult(t *testing.T) {
+	ss := []*stream{
+		{cpuTime: 12 * time.Second, memory: 200 << 20},
+		{cpuTime: 18 * time.Second, memory: 250 << 20},
+	}
+
+	l := newLevelResult(ss, 20*time.Second, 4)
+	require.Equal(t, 2, l.Streams)
+	require.InDelta(t, 0.75, l.CPU, 1e-9)
+	require.InDelta(t, 1.5, l.TotalCPU, 1e-9)
+	require.Equal(t, uint64(250<<20), l.Memory)
+	require.False(t, l.Saturated)
+
+	l = newLevelResult(ss, 20*time.Second, 1)
+	require.True(t, l.Saturated)
+}
+
+func TestRecommendedCosts(t *testing.T) {
+	r := &PresetResult{
+		Preset: livekit.IngressVideoEncodingPreset_H264_720P_30FPS_3_LAYERS,
+		Levels: []*LevelResult{
+			{Streams: 1, CPU: 0.612, Memory: 210 << 20, TotalCPU: 0.612},
+			{Streams: 2, CPU: 0.655, Memory: 215<<20 + 1, TotalCPU: 1.31},
+			{Streams: 4, CPU: 0.9, Memory: 212 << 20, TotalCPU: 3.6, Saturated: true},
+		},
+	}
+
+	// The saturated level is ignored
+	require.Equal(t, 0.66, r.RecommendedCPUCost())
+	require.Equal(t, uint64(216), r.RecommendedMemoryCost())
+
+	var b bytes.Buffer
+	PrintResults(&b, []*PresetResult{r})
+	require.Contains(t, b.String(), "  rtmp_cpu_cost: 0.66\n")
+	require.Contains(t, b.String(), "  url_memory_cost: 216\n")
+}
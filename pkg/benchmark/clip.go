@@ -0,0 +1,51 @@
+package benchmark
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/tinyzimmer/go-gst/gst"
+)
+
+const (
+	busPollInterval = 100 * time.Millisecond
+
+	// The streams loop over a 10s clip, encoded like the 1080p stream of a typical publisher
+	clipPipeline = "videotestsrc num-buffers=300 pattern=smpte horizontal-speed=4 ! video/x-raw,width=1920,height=1080,framerate=30/1 ! " +
+		"x264enc bitrate=6000 key-int-max=60 bframes=0 speed-preset=veryfast ! h264parse ! queue ! matroskamux name=mux ! filesink location=%s " +
+		"audiotestsrc num-buffers=500 samplesperbuffer=960 ! audio/x-raw,rate=48000,channels=2 ! opusenc ! queue ! mux."
+)
+
+// generateClip encodes a moving test pattern and a tone to a Matroska file, the media decoded by the streams
+func generateClip(ctx context.Context, path string) error {
+	gst.Init(nil)
+
+	pipeline, err := gst.NewPipelineFromString(fmt.Sprintf(clipPipeline, path))
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = pipeline.BlockSetState(gst.StateNull)
+	}()
+	if err = pipeline.SetState(gst.StatePlaying); err != nil {
+		return err
+	}
+
+	bus := pipeline.GetPipelineBus()
+	for {
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+
+		msg := bus.TimedPopFiltered(busPollInterval, gst.MessageError|gst.MessageEOS)
+		if msg == nil {
+			continue
+		}
+		if msg.Type() == gst.MessageError {
+			return msg.ParseError()
+		}
+		return nil
+	}
+}
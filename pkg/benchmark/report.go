@@ -0,0 +1,64 @@
+package benchmark
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+)
+
+// transcoded input types, their costs are set to the ones of the most expensive preset
+var costKeys = []string{"rtmp", "whip", "srt", "udp", "websocket", "rtsp", "url"}
+
+// PrintResults writes the measurements of each preset, followed by the recommended cost config
+func PrintResults(w io.Writer, results []*PresetResult) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PRESET\tSTREAMS\tCPU/STREAM\tMEMORY/STREAM\tTOTAL CPU\t")
+	for _, r := range results {
+		for _, l := range r.Levels {
+			saturated := ""
+			if l.Saturated {
+				saturated = " (saturated)"
+			}
+			fmt.Fprintf(tw, "%s\t%d\t%.2f\t%d MB\t%.2f%s\t\n", r.Preset, l.Streams, l.CPU, l.Memory>>20, l.TotalCPU, saturated)
+		}
+		if r.Err != nil {
+			fmt.Fprintf(tw, "%s\tfailed: %v\t\t\t\t\n", r.Preset, r.Err)
+		}
+	}
+	_ = tw.Flush()
+
+	var cpuCost float64
+	var memoryCost uint64
+	fmt.Fprintln(w)
+	tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PRESET\tCPU COST\tMEMORY COST\t")
+	for _, r := range results {
+		if len(r.Levels) == 0 {
+			continue
+		}
+		fmt.Fprintf(tw, "%s\t%.2f\t%d MB\t\n", r.Preset, r.RecommendedCPUCost(), r.RecommendedMemoryCost())
+
+		if c := r.RecommendedCPUCost(); c > cpuCost {
+			cpuCost = c
+		}
+		if c := r.RecommendedMemoryCost(); c > memoryCost {
+			memoryCost = c
+		}
+	}
+	_ = tw.Flush()
+
+	if cpuCost == 0 {
+		return
+	}
+
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "# costs of the transcoded ingresses, covering the most expensive preset")
+	fmt.Fprintln(w, "cpu_cost:")
+	for _, k := range costKeys {
+		fmt.Fprintf(w, "  %s_cpu_cost: %.2f\n", k, cpuCost)
+	}
+	fmt.Fprintln(w, "memory_cost:")
+	for _, k := range costKeys {
+		fmt.Fprintf(w, "  %s_memory_cost: %d\n", k, memoryCost)
+	}
+}
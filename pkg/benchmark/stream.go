@@ -0,0 +1,56 @@
+package benchmark
+
+import (
+	"context"
+
+	"github.com/livekit/ingress/pkg/config"
+	"github.com/livekit/ingress/pkg/errors"
+	"github.com/livekit/ingress/pkg/lksdk_output"
+	"github.com/livekit/ingress/pkg/media"
+	"github.com/livekit/ingress/pkg/params"
+	"github.com/livekit/ingress/pkg/types"
+	"github.com/livekit/protocol/livekit"
+	"github.com/livekit/protocol/utils"
+)
+
+// RunStream transcodes the looping clip with the video preset until the context is done. Like a handler, each stream
+// runs in its own process. The tracks are not published
+func RunStream(ctx context.Context, conf *config.Config, preset livekit.IngressVideoEncodingPreset, clip string) error {
+	info := &livekit.IngressInfo{
+		IngressId:           utils.NewGuid(utils.IngressPrefix),
+		Name:                "benchmark",
+		StreamKey:           "benchmark",
+		InputType:           types.URLInput,
+		Url:                 "file://" + clip,
+		RoomName:            "benchmark",
+		ParticipantIdentity: "benchmark",
+		Video: &livekit.IngressVideoOptions{
+			EncodingOptions: &livekit.IngressVideoOptions_Preset{Preset: preset},
+		},
+	}
+
+	// No room is joined, the token is not used
+	p, err := params.GetParams(ctx, conf, info, "", "benchmark", &params.PullExtraParams{Loop: true})
+	if err != nil {
+		return err
+	}
+
+	pipeline, err := media.NewWithRoomOutput(ctx, conf, p, func(context.Context, *params.Params) (lksdk_output.RoomOutput, error) {
+		return lksdk_output.NewDiscardOutput(), nil
+	})
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		<-ctx.Done()
+		pipeline.SendEOS(context.Background())
+	}()
+
+	res := pipeline.Run(context.Background())
+	if res.State.Status == livekit.IngressState_ENDPOINT_ERROR {
+		return errors.New(res.State.Error)
+	}
+
+	return nil
+}
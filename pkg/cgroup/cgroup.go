@@ -0,0 +1,259 @@
+package cgroup
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	mountPoint = "/sys/fs/cgroup"
+	// microseconds
+	cpuPeriod = 100000
+
+	// leaf cgroup the processes of the parent cgroup are moved to
+	serviceGroup = "service"
+)
+
+// Controller creates cgroup v2 groups limiting the resources of the processes placed in them
+type Controller struct {
+	root string
+}
+
+// NewController prepares the root cgroup for its groups. The cgroup of the current process is used if root is not set.
+//
+// A cgroup v2 can only enable controllers for its children if it has no processes of its own, the processes of the root
+// cgroup are moved to a "service" group first.
+func NewController(root string) (*Controller, error) {
+	if root == "" {
+		b, err := os.ReadFile("/proc/self/cgroup")
+		if err != nil {
+			return nil, err
+		}
+		current, err := parseProcCGroup(b)
+		if err != nil {
+			return nil, err
+		}
+		root = filepath.Join(mountPoint, current)
+	}
+
+	if _, err := os.Stat(filepath.Join(root, "cgroup.controllers")); err != nil {
+		return nil, fmt.Errorf("%s is not a cgroup v2 directory: %w", root, err)
+	}
+
+	c := &Controller{root: root}
+	if err := c.moveProcesses(filepath.Join(root, serviceGroup)); err != nil {
+		return nil, err
+	}
+	if err := writeFile(filepath.Join(root, "cgroup.subtree_control"), "+cpu +memory"); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (c *Controller) moveProcesses(dst string) error {
+	b, err := os.ReadFile(filepath.Join(c.root, "cgroup.procs"))
+	if err != nil {
+		return err
+	}
+	if len(bytes.TrimSpace(b)) == 0 {
+		return nil
+	}
+
+	if err = os.MkdirAll(dst, 0755); err != nil {
+		return err
+	}
+
+	for _, pid := range strings.Fields(string(b)) {
+		// Processes may have exited in the meantime
+		if err = writeFile(filepath.Join(dst, "cgroup.procs"), pid); err != nil && !errors.Is(err, syscall.ESRCH) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// NewGroup creates a group limited to the given number of CPUs and amount of memory. Limits are not set if 0
+func (c *Controller) NewGroup(name string, cpus float64, memoryLimit uint64) (*Group, error) {
+	g := &Group{
+		path:        filepath.Join(c.root, name),
+		cpus:        cpus,
+		memoryLimit: memoryLimit,
+	}
+
+	if err := os.MkdirAll(g.path, 0755); err != nil {
+		return nil, err
+	}
+	if cpus > 0 {
+		if err := writeFile(filepath.Join(g.path, "cpu.max"), formatCPUMax(cpus)); err != nil {
+			_ = g.Remove()
+			return nil, err
+		}
+	}
+	if memoryLimit > 0 {
+		if err := writeFile(filepath.Join(g.path, "memory.max"), strconv.FormatUint(memoryLimit, 10)); err != nil {
+			_ = g.Remove()
+			return nil, err
+		}
+	}
+
+	return g, nil
+}
+
+// Group is a cgroup created by the controller
+type Group struct {
+	path        string
+	cpus        float64
+	memoryLimit uint64
+}
+
+// Usage is the resource consumption of the processes of a group
+type Usage struct {
+	// since the group was created
+	CPUTime time.Duration
+	Memory  uint64
+	// processes killed because the group reached its memory limit
+	OOMKills uint64
+}
+
+// CPULimit returns the number of CPUs the group is limited to, 0 if unlimited
+func (g *Group) CPULimit() float64 {
+	return g.cpus
+}
+
+// MemoryLimit returns the amount of memory the group is limited to, 0 if unlimited
+func (g *Group) MemoryLimit() uint64 {
+	return g.memoryLimit
+}
+
+// Open opens the directory of the group, for a process to be started directly in the group with SysProcAttr.CgroupFD.
+// The process is then limited from its first instruction, and its children are placed in the group as well
+func (g *Group) Open() (*os.File, error) {
+	return os.Open(g.path)
+}
+
+func (g *Group) Usage() (*Usage, error) {
+	u := &Usage{}
+
+	b, err := os.ReadFile(filepath.Join(g.path, "cpu.stat"))
+	if err != nil {
+		return nil, err
+	}
+	u.CPUTime = time.Duration(parseKeyedFile(b)["usage_usec"]) * time.Microsecond
+
+	if u.Memory, err = readUint(filepath.Join(g.path, "memory.current")); err != nil {
+		return nil, err
+	}
+
+	b, err = os.ReadFile(filepath.Join(g.path, "memory.events"))
+	if err != nil {
+		return nil, err
+	}
+	u.OOMKills = parseKeyedFile(b)["oom_kill"]
+
+	return u, nil
+}
+
+// Remove deletes the group. Its processes must have exited
+func (g *Group) Remove() error {
+	return os.Remove(g.path)
+}
+
+// MemoryHeadroom returns the memory the current process and its children can still use before reaching the limit of its
+// cgroup, or of one of its ancestors. Returns math.MaxUint64 if none is limited
+func MemoryHeadroom() (uint64, error) {
+	b, err := os.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return 0, err
+	}
+	current, err := parseProcCGroup(b)
+	if err != nil {
+		return 0, err
+	}
+
+	return memoryHeadroom(mountPoint, current), nil
+}
+
+func memoryHeadroom(mount string, p string) uint64 {
+	headroom := uint64(math.MaxUint64)
+	for {
+		dir := filepath.Join(mount, p)
+
+		// The root cgroup has no limit files, "max" if not limited
+		limit, err := readUint(filepath.Join(dir, "memory.max"))
+		if err == nil {
+			usage, err := readUint(filepath.Join(dir, "memory.current"))
+			if err == nil {
+				if usage > limit {
+					usage = limit
+				}
+				if limit-usage < headroom {
+					headroom = limit - usage
+				}
+			}
+		}
+
+		if p == "/" || p == "." || p == "" {
+			return headroom
+		}
+		p = filepath.Dir(p)
+	}
+}
+
+func readUint(name string) (uint64, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.ParseUint(string(bytes.TrimSpace(b)), 10, 64)
+}
+
+// parseProcCGroup returns the cgroup v2 path found in the content of /proc/<pid>/cgroup
+func parseProcCGroup(b []byte) (string, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for scanner.Scan() {
+		if p, ok := strings.CutPrefix(scanner.Text(), "0::"); ok {
+			return p, nil
+		}
+	}
+
+	return "", fmt.Errorf("no cgroup v2 hierarchy")
+}
+
+// parseKeyedFile parses the "key value" lines of files like cpu.stat and memory.events
+func parseKeyedFile(b []byte) map[string]uint64 {
+	values := make(map[string]uint64)
+
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		if v, err := strconv.ParseUint(fields[1], 10, 64); err == nil {
+			values[fields[0]] = v
+		}
+	}
+
+	return values
+}
+
+func formatCPUMax(cpus float64) string {
+	quota := int64(math.Ceil(cpus * cpuPeriod))
+	return fmt.Sprintf("%d %d", quota, cpuPeriod)
+}
+
+func writeFile(name string, value string) error {
+	return os.WriteFile(name, []byte(value), 0644)
+}
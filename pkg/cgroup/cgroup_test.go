@@ -0,0 +1,94 @@
+package cgroup
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseProcCGroup(t *testing.T) {
+	p, err := parseProcCGroup([]byte("0::/system.slice/ingress.service\n"))
+	require.NoError(t, err)
+	require.Equal(t, "/system.slice/ingress.service", p)
+
+	// Hybrid hierarchy
+	p, err = parseProcCGroup([]byte("12:memory:/docker/abc\n1:name=systemd:/docker/abc\n0::/docker/abc\n"))
+	require.NoError(t, err)
+	require.Equal(t, "/docker/abc", p)
+
+	_, err = parseProcCGroup([]byte("12:memory:/docker/abc\n"))
+	require.Error(t, err)
+}
+
+func TestFormatCPUMax(t *testing.T) {
+	require.Equal(t, "200000 100000", formatCPUMax(2))
+	require.Equal(t, "40000 100000", formatCPUMax(0.4))
+	require.Equal(t, "5000 100000", formatCPUMax(0.05))
+}
+
+func TestGroup(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(root, "cgroup.controllers"), []byte("cpu memory"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "cgroup.procs"), []byte("42\n43\n"), 0644))
+
+	c, err := NewController(root)
+	require.NoError(t, err)
+
+	b, err := os.ReadFile(filepath.Join(root, "cgroup.subtree_control"))
+	require.NoError(t, err)
+	require.Equal(t, "+cpu +memory", string(b))
+	require.DirExists(t, filepath.Join(root, serviceGroup))
+
+	g, err := c.NewGroup("handler_IN_test", 1.5, 512<<20)
+	require.NoError(t, err)
+	require.Equal(t, 1.5, g.CPULimit())
+	require.Equal(t, uint64(512<<20), g.MemoryLimit())
+
+	b, err = os.ReadFile(filepath.Join(root, "handler_IN_test", "cpu.max"))
+	require.NoError(t, err)
+	require.Equal(t, "150000 100000", string(b))
+	b, err = os.ReadFile(filepath.Join(root, "handler_IN_test", "memory.max"))
+	require.NoError(t, err)
+	require.Equal(t, "536870912", string(b))
+
+	require.NoError(t, os.WriteFile(filepath.Join(g.path, "cpu.stat"), []byte("usage_usec 2500000\nuser_usec 2000000\nsystem_usec 500000\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(g.path, "memory.current"), []byte("104857600\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(g.path, "memory.events"), []byte("low 0\nhigh 0\nmax 3\noom 1\noom_kill 1\n"), 0644))
+
+	u, err := g.Usage()
+	require.NoError(t, err)
+	require.Equal(t, 2500*time.Millisecond, u.CPUTime)
+	require.Equal(t, uint64(104857600), u.Memory)
+	require.Equal(t, uint64(1), u.OOMKills)
+
+	dir, err := g.Open()
+	require.NoError(t, err)
+	defer dir.Close()
+	fi, err := dir.Stat()
+	require.NoError(t, err)
+	require.True(t, fi.IsDir())
+}
+
+func TestMemoryHeadroom(t *testing.T) {
+	mount := t.TempDir()
+	write := func(p string, name string, value string) {
+		require.NoError(t, os.MkdirAll(filepath.Join(mount, p), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(mount, p, name), []byte(value+"\n"), 0644))
+	}
+
+	write("/docker/abc/service", "memory.max", "max")
+	write("/docker/abc/service", "memory.current", "104857600")
+	write("/docker/abc", "memory.max", "2147483648")
+	write("/docker/abc", "memory.current", "1073741824")
+	write("/docker", "memory.max", "max")
+	write("/docker", "memory.current", "4294967296")
+
+	require.Equal(t, uint64(1<<30), memoryHeadroom(mount, "/docker/abc/service"))
+
+	// Unlimited
+	require.Equal(t, uint64(math.MaxUint64), memoryHeadroom(mount, "/docker"))
+}
@@ -1,6 +1,7 @@
 package config
 
 import (
+	"net"
 	"os"
 
 	"github.com/sirupsen/logrus"
@@ -16,9 +17,39 @@ import (
 )
 
 const (
-	DefaultRTMPPort      int = 1935
-	DefaultWHIPPort          = 8080
-	DefaultHTTPRelayPort     = 9090
+	DefaultRTMPPort int = 1935
+	DefaultWHIPPort     = 8080
+)
+
+const (
+	DefaultSlateTimeoutMs = 2000
+	DefaultSlateText      = "The stream will resume shortly"
+)
+
+const (
+	DefaultRecordingSegmentDurationMs = 60000
+)
+
+const (
+	DefaultMinMeasuredCPUSamples = 60
+)
+
+const (
+	// Share of the cpu cost added to the CPU quota of the handlers when the measured cost is used, so that the measured
+	// CPU is not capped by the quota
+	DefaultMeasuredCostCPUHeadroom = 0.5
+)
+
+const (
+	// MB, the raw video queued by the transcoding pipeline dominates
+	DefaultTranscodingMemoryCost       = 300
+	DefaultBypassTranscodingMemoryCost = 50
+)
+
+const (
+	DefaultMaxHandlerRestarts        = 3
+	DefaultHandlerCrashLimit         = 3
+	DefaultHandlerCrashWindowSeconds = 600
 )
 
 var (
@@ -26,18 +57,24 @@ var (
 )
 
 type Config struct {
-	Redis     *redis.RedisConfig `yaml:"redis"`      // required
+	Redis     *redis.RedisConfig `yaml:"redis"`      // required, unless standalone
 	ApiKey    string             `yaml:"api_key"`    // required (env LIVEKIT_API_KEY)
 	ApiSecret string             `yaml:"api_secret"` // required (env LIVEKIT_API_SECRET)
 	WsUrl     string             `yaml:"ws_url"`     // required (env LIVEKIT_WS_URL)
 
-	HealthPort     int           `yaml:"health_port"`
-	PrometheusPort int           `yaml:"prometheus_port"`
-	RTMPPort       int           `yaml:"rtmp_port"` // -1 to disable RTMP
-	WHIPPort       int           `yaml:"whip_port"` // -1 to disable WHIP
-	HTTPRelayPort  int           `yaml:"http_relay_port"`
-	Logging        logger.Config `yaml:"logging"`
-	Development    bool          `yaml:"development"`
+	HealthPort      int           `yaml:"health_port"`
+	PrometheusPort  int           `yaml:"prometheus_port"`
+	RTMPPort        int           `yaml:"rtmp_port"`  // -1 to disable RTMP
+	RTMPSPort       int           `yaml:"rtmps_port"` // 0 to disable RTMPS
+	RTMPSCertFile   string        `yaml:"rtmps_cert_file"`
+	RTMPSKeyFile    string        `yaml:"rtmps_key_file"`
+	RTMPBackup      bool          `yaml:"rtmp_backup"`       // accept a failover publisher on <stream key>_backup
+	WHIPPort        int           `yaml:"whip_port"`         // -1 to disable WHIP
+	SRTPort         int           `yaml:"srt_port"`          // 0 to disable SRT. Standalone mode only
+	HTTPRelayPort   int           `yaml:"http_relay_port"`   // deprecated, the relay is served on HTTPRelaySocket
+	HTTPRelaySocket string        `yaml:"http_relay_socket"` // Unix domain socket relaying media to the handler processes, in a temp dir if not set
+	Logging         logger.Config `yaml:"logging"`
+	Development     bool          `yaml:"development"`
 
 	// Used for WHIP transport
 	RTCConfig rtcconfig.RTCConfig `yaml:"rtc_config"`
@@ -45,6 +82,33 @@ type Config struct {
 	// CPU costs for various ingress types
 	CPUCost CPUCostConfig `yaml:"cpu_cost"`
 
+	// Memory costs for various ingress types
+	MemoryCost MemoryCostConfig `yaml:"memory_cost"`
+
+	// Media published in place of the input when it stalls
+	Slate SlateConfig `yaml:"slate"`
+
+	// Local archive of the ingested media
+	Recording RecordingConfig `yaml:"recording"`
+
+	// Time to wait for a disconnected publisher to reconnect before ending the ingress. 0 to end it right away
+	ReconnectGracePeriodMs int `yaml:"reconnect_grace_period_ms"`
+
+	// Directory URL ingresses can pull local files from. Local files are rejected if not set. URL ingresses are standalone mode only
+	URLFileDirectory string `yaml:"url_file_directory"`
+
+	// Resources of the handler processes enforced with cgroups
+	CGroups CGroupsConfig `yaml:"cgroups"`
+
+	// Restart of the crashed handler processes
+	Supervision SupervisionConfig `yaml:"supervision"`
+
+	// MPEG-TS over UDP listeners, each feeding a single ingress. Standalone mode only
+	UDPInputs []UDPInputConfig `yaml:"udp_inputs"`
+
+	// Run without Redis and a LiveKit control plane
+	Standalone *StandaloneConfig `yaml:"standalone"`
+
 	// internal
 	ServiceName string `yaml:"-"`
 	NodeID      string `yaml:"-"`
@@ -56,13 +120,121 @@ type WhipConfig struct {
 	EnableLoopbackCandidate bool     `yaml:"enable_loopback_candidate"`
 }
 
+type SlateConfig struct {
+	Enabled   bool   `yaml:"enabled"`
+	TimeoutMs int    `yaml:"timeout_ms"` // time without input media on a track before switching it to the slate
+	Image     string `yaml:"image"`      // path to a PNG image. A generated card is used if not set
+	Text      string `yaml:"text"`       // text of the generated card
+}
+
+// MemoryCostConfig gives the memory used by the handler of each ingress type, in MB
+type MemoryCostConfig struct {
+	RTMPMemoryCost                  uint64 `yaml:"rtmp_memory_cost"`
+	RTMPBypassTranscodingMemoryCost uint64 `yaml:"rtmp_bypass_transcoding_memory_cost"`
+	WHIPMemoryCost                  uint64 `yaml:"whip_memory_cost"`
+	WHIPBypassTranscodingMemoryCost uint64 `yaml:"whip_bypass_transcoding_memory_cost"`
+	SRTMemoryCost                   uint64 `yaml:"srt_memory_cost"`
+	UDPMemoryCost                   uint64 `yaml:"udp_memory_cost"`
+	WebSocketMemoryCost             uint64 `yaml:"websocket_memory_cost"`
+	RTSPMemoryCost                  uint64 `yaml:"rtsp_memory_cost"`
+	URLMemoryCost                   uint64 `yaml:"url_memory_cost"`
+}
+
+type CGroupsConfig struct {
+	Enabled       bool    `yaml:"enabled"`         // limit each handler to the cpu cost of its ingress type, plus the headroom
+	Root          string  `yaml:"root"`            // cgroup v2 directory the handler cgroups are created in. The cgroup of the service if not set
+	MemoryLimitMB uint64  `yaml:"memory_limit_mb"` // memory ceiling of each handler. Not limited if not set
+	CPUHeadroom   float64 `yaml:"cpu_headroom"`    // share of the cpu cost added to the CPU quota of each handler
+}
+
+type SupervisionConfig struct {
+	MaxRestarts        int `yaml:"max_restarts"`         // restarts of a crashed handler while its publisher is connected. -1 to never restart
+	CrashLimit         int `yaml:"crash_limit"`          // handler crashes within the crash window marking the node unhealthy
+	CrashWindowSeconds int `yaml:"crash_window_seconds"` // period over which the crashes are counted
+}
+
+type UDPInputConfig struct {
+	StreamKey      string   `yaml:"stream_key"`      // stream key of the ingress fed by the listener
+	Port           int      `yaml:"port"`            // UDP port to listen on
+	BindAddress    string   `yaml:"bind_address"`    // local address to listen on, or interface address to join the multicast group on
+	MulticastGroup string   `yaml:"multicast_group"` // IPv4 multicast group to join. Unicast if not set
+	SourceAddress  string   `yaml:"source_address"`  // only accept packets from this address. Source specific multicast join if the group is set
+	ProgramNumber  int      `yaml:"program_number"`  // MPEG-TS program to keep. All programs are kept if not set
+	PIDs           []uint16 `yaml:"pids"`            // elementary stream PIDs to keep. All the streams of the kept programs if not set
+}
+
+type StandaloneConfig struct {
+	IngressesFile string   `yaml:"ingresses_file"` // YAML or JSON file with the ingresses definitions
+	WebhookUrls   []string `yaml:"webhook_urls"`   // receive ingress_started and ingress_ended events
+}
+
+type RecordingConfig struct {
+	Enabled           bool   `yaml:"enabled"`
+	Directory         string `yaml:"directory"`           // recordings are written to <directory>/<ingress ID>
+	SegmentDurationMs int    `yaml:"segment_duration_ms"` // duration of each file
+	RetentionMinutes  int    `yaml:"retention_minutes"`   // files older than this are deleted. 0 to keep all files
+}
+
 type CPUCostConfig struct {
 	RTMPCpuCost                  float64 `yaml:"rtmp_cpu_cost"`
+	RTMPBypassTranscodingCpuCost float64 `yaml:"rtmp_bypass_transcoding_cpu_cost"`
+	RTMPBackupCpuCost            float64 `yaml:"rtmp_backup_cpu_cost"` // decoding the backup publisher, charged when it joins while the other publisher is connected
 	WHIPCpuCost                  float64 `yaml:"whip_cpu_cost"`
 	WHIPBypassTranscodingCpuCost float64 `yaml:"whip_bypass_transcoding_cpu_cost"`
+	SRTCpuCost                   float64 `yaml:"srt_cpu_cost"`
+	UDPCpuCost                   float64 `yaml:"udp_cpu_cost"`
+	WebSocketCpuCost             float64 `yaml:"websocket_cpu_cost"`
+	RTSPCpuCost                  float64 `yaml:"rtsp_cpu_cost"`
+	URLCpuCost                   float64 `yaml:"url_cpu_cost"`
+
+	UseMeasuredCost    bool `yaml:"use_measured_cost"`    // admit ingresses with the 95th percentile of the CPU measured for their type, preset and transcoding mode
+	MinMeasuredSamples int  `yaml:"min_measured_samples"` // 5 second samples needed before the measured cost is used
+}
+
+func (c *MemoryCostConfig) setDefaults() {
+	for _, cost := range []*uint64{
+		&c.RTMPMemoryCost,
+		&c.WHIPMemoryCost,
+		&c.SRTMemoryCost,
+		&c.UDPMemoryCost,
+		&c.WebSocketMemoryCost,
+		&c.RTSPMemoryCost,
+		&c.URLMemoryCost,
+	} {
+		if *cost == 0 {
+			*cost = DefaultTranscodingMemoryCost
+		}
+	}
+
+	for _, cost := range []*uint64{
+		&c.RTMPBypassTranscodingMemoryCost,
+		&c.WHIPBypassTranscodingMemoryCost,
+	} {
+		if *cost == 0 {
+			*cost = DefaultBypassTranscodingMemoryCost
+		}
+	}
 }
 
 func NewConfig(confString string) (*Config, error) {
+	conf, err := parseConfig(confString)
+	if err != nil {
+		return nil, err
+	}
+
+	if conf.Redis == nil && conf.Standalone == nil {
+		return nil, psrpc.NewErrorf(psrpc.InvalidArgument, "redis configuration is required")
+	}
+
+	return conf, nil
+}
+
+// NewBenchmarkConfig parses the config of the benchmark command. The benchmark runs offline, it does not need redis
+func NewBenchmarkConfig(confString string) (*Config, error) {
+	return parseConfig(confString)
+}
+
+func parseConfig(confString string) (*Config, error) {
 	conf := &Config{
 		ApiKey:      os.Getenv("LIVEKIT_API_KEY"),
 		ApiSecret:   os.Getenv("LIVEKIT_API_SECRET"),
@@ -76,15 +248,10 @@ func NewConfig(confString string) (*Config, error) {
 		}
 	}
 
-	err := conf.Init()
-	if err != nil {
+	if err := conf.Init(); err != nil {
 		return nil, err
 	}
 
-	if conf.Redis == nil {
-		return nil, psrpc.NewErrorf(psrpc.InvalidArgument, "redis configuration is required")
-	}
-
 	return conf, nil
 }
 
@@ -92,13 +259,65 @@ func (conf *Config) Init() error {
 	if conf.RTMPPort == 0 {
 		conf.RTMPPort = DefaultRTMPPort
 	}
-	if conf.HTTPRelayPort == 0 {
-		conf.HTTPRelayPort = DefaultHTTPRelayPort
-	}
 	if conf.WHIPPort == 0 {
 		conf.WHIPPort = DefaultWHIPPort
 	}
 
+	if conf.Slate.TimeoutMs <= 0 {
+		conf.Slate.TimeoutMs = DefaultSlateTimeoutMs
+	}
+	if conf.Slate.Text == "" {
+		conf.Slate.Text = DefaultSlateText
+	}
+
+	if conf.Recording.SegmentDurationMs <= 0 {
+		conf.Recording.SegmentDurationMs = DefaultRecordingSegmentDurationMs
+	}
+	if conf.Recording.Enabled && conf.Recording.Directory == "" {
+		return psrpc.NewErrorf(psrpc.InvalidArgument, "recording requires a directory")
+	}
+
+	if conf.CPUCost.MinMeasuredSamples <= 0 {
+		conf.CPUCost.MinMeasuredSamples = DefaultMinMeasuredCPUSamples
+	}
+
+	conf.MemoryCost.setDefaults()
+
+	if conf.CGroups.CPUHeadroom < 0 {
+		return psrpc.NewErrorf(psrpc.InvalidArgument, "cgroups cpu headroom cannot be negative")
+	}
+	if conf.CGroups.Enabled && conf.CPUCost.UseMeasuredCost && conf.CGroups.CPUHeadroom == 0 {
+		conf.CGroups.CPUHeadroom = DefaultMeasuredCostCPUHeadroom
+	}
+
+	if conf.Supervision.MaxRestarts == 0 {
+		conf.Supervision.MaxRestarts = DefaultMaxHandlerRestarts
+	}
+	if conf.Supervision.CrashLimit <= 0 {
+		conf.Supervision.CrashLimit = DefaultHandlerCrashLimit
+	}
+	if conf.Supervision.CrashWindowSeconds <= 0 {
+		conf.Supervision.CrashWindowSeconds = DefaultHandlerCrashWindowSeconds
+	}
+
+	if conf.RTMPSPort > 0 && (conf.RTMPSCertFile == "" || conf.RTMPSKeyFile == "") {
+		return psrpc.NewErrorf(psrpc.InvalidArgument, "rtmps requires a certificate and a key file")
+	}
+
+	if conf.Standalone != nil && conf.Standalone.IngressesFile == "" {
+		return psrpc.NewErrorf(psrpc.InvalidArgument, "standalone mode requires an ingresses file")
+	}
+
+	if conf.Standalone == nil && (conf.SRTPort > 0 || len(conf.UDPInputs) > 0) {
+		return psrpc.NewErrorf(psrpc.InvalidArgument, "srt and udp inputs are only supported in standalone mode")
+	}
+
+	for _, u := range conf.UDPInputs {
+		if err := u.Validate(); err != nil {
+			return err
+		}
+	}
+
 	err := conf.InitWhipConf()
 	if err != nil {
 		return err
@@ -111,6 +330,29 @@ func (conf *Config) Init() error {
 	return nil
 }
 
+func (u *UDPInputConfig) Validate() error {
+	if u.StreamKey == "" {
+		return psrpc.NewErrorf(psrpc.InvalidArgument, "udp input requires a stream key")
+	}
+	if u.Port <= 0 {
+		return psrpc.NewErrorf(psrpc.InvalidArgument, "udp input %s requires a port", u.StreamKey)
+	}
+	if u.BindAddress != "" && net.ParseIP(u.BindAddress) == nil {
+		return psrpc.NewErrorf(psrpc.InvalidArgument, "invalid udp input %s bind address", u.StreamKey)
+	}
+	if u.MulticastGroup != "" {
+		group := net.ParseIP(u.MulticastGroup)
+		if group == nil || group.To4() == nil || !group.IsMulticast() {
+			return psrpc.NewErrorf(psrpc.InvalidArgument, "invalid udp input %s multicast group", u.StreamKey)
+		}
+	}
+	if u.SourceAddress != "" && net.ParseIP(u.SourceAddress) == nil {
+		return psrpc.NewErrorf(psrpc.InvalidArgument, "invalid udp input %s source address", u.StreamKey)
+	}
+
+	return nil
+}
+
 func (c *Config) InitWhipConf() error {
 	if c.WHIPPort <= 0 {
 		return nil
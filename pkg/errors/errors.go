@@ -16,13 +16,24 @@ var (
 	ErrSourceNotReady          = psrpc.NewErrorf(psrpc.FailedPrecondition, "source encoder not ready")
 	ErrUnsupportedDecodeFormat = psrpc.NewErrorf(psrpc.NotAcceptable, "unsupported mime type for the source media")
 	ErrUnsupportedEncodeFormat = psrpc.NewErrorf(psrpc.InvalidArgument, "unsupported mime type for encoder")
+	ErrRecordingUnsupported    = psrpc.NewErrorf(psrpc.InvalidArgument, "unsupported mime type for recording")
 	ErrDuplicateTrack          = psrpc.NewErrorf(psrpc.NotAcceptable, "more than 1 track with given media kind")
 	ErrUnableToAddPad          = psrpc.NewErrorf(psrpc.Internal, "could not add pads to bin")
 	ErrIngressNotFound         = psrpc.NewErrorf(psrpc.NotFound, "ingress not found")
 	ErrServerCapacityExceeded  = psrpc.NewErrorf(psrpc.ResourceExhausted, "server capacity exceeded")
 	ErrServerShuttingDown      = psrpc.NewErrorf(psrpc.Unavailable, "server shutting down")
 	ErrMissingStreamKey        = psrpc.NewErrorf(psrpc.InvalidArgument, "missing stream key")
+	ErrStreamKeyInUse          = psrpc.NewErrorf(psrpc.AlreadyExists, "a publisher is already connected with this stream key")
 	ErrPrerollBufferReset      = psrpc.NewErrorf(psrpc.Internal, "preroll buffer reset")
+	ErrInvalidSDPFragment      = psrpc.NewErrorf(psrpc.InvalidArgument, "invalid SDP fragment")
+	ErrETagMismatch            = psrpc.NewErrorf(psrpc.FailedPrecondition, "resource ETag mismatch")
+	ErrICECredentialsMismatch  = psrpc.NewErrorf(psrpc.Aborted, "ICE credentials do not match the current ICE session")
+	ErrHandlerCrashed          = psrpc.NewErrorf(psrpc.Internal, "ingress handler crashed")
+	ErrHandlerSetupFailed      = psrpc.NewErrorf(psrpc.Internal, "ingress handler could not be set up")
+	ErrInvalidAdminToken       = psrpc.NewErrorf(psrpc.Unauthenticated, "missing or invalid admin token")
+	ErrAdminPermissionDenied   = psrpc.NewErrorf(psrpc.PermissionDenied, "admin token does not grant ingress admin")
+	ErrStandaloneInputType     = psrpc.NewErrorf(psrpc.FailedPrecondition, "input type only supported in standalone mode")
+	ErrRelaySocketDirUnsafe    = psrpc.NewErrorf(psrpc.FailedPrecondition, "relay socket directory must only be accessible by the user running the service")
 )
 
 func New(err string) error {
@@ -45,6 +56,10 @@ func ErrFromGstFlowReturn(ret gst.FlowReturn) psrpc.Error {
 	return psrpc.NewErrorf(psrpc.Internal, "GST Flow Error %d (%s)", ret, ret.String())
 }
 
+func ErrPullFailed(err error) psrpc.Error {
+	return psrpc.NewErrorf(psrpc.Unavailable, "could not pull media from the source: %v", err)
+}
+
 func ErrHttpRelayFailure(statusCode int) psrpc.Error {
 	// Any failure in the relay between the handler and the service is treated as internal
 
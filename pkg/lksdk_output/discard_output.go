@@ -0,0 +1,48 @@
+package lksdk_output
+
+import (
+	"github.com/livekit/protocol/livekit"
+	lksdk "github.com/livekit/server-sdk-go"
+)
+
+// DiscardOutput consumes the samples of the tracks without publishing them. It lets the pipeline run without a room,
+// when benchmarking the transcoding
+type DiscardOutput struct{}
+
+func NewDiscardOutput() *DiscardOutput {
+	return &DiscardOutput{}
+}
+
+func (s *DiscardOutput) AddAudioTrack(output lksdk.SampleProvider, _ string, _ bool, _ bool) error {
+	return s.drain(output)
+}
+
+func (s *DiscardOutput) AddVideoTrack(outputs []VideoSampleProvider, _ []*livekit.VideoLayer, _ string) error {
+	for _, output := range outputs {
+		if err := s.drain(output); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// drain pulls the samples of the provider like a published track would, until the end of the stream
+func (s *DiscardOutput) drain(provider lksdk.SampleProvider) error {
+	if err := provider.OnBind(); err != nil {
+		return err
+	}
+
+	go func() {
+		for {
+			if _, err := provider.NextSample(); err != nil {
+				_ = provider.OnUnbind()
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+func (s *DiscardOutput) Close() {}
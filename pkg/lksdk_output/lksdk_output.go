@@ -20,6 +20,13 @@ type VideoSampleProvider interface {
 	ForceKeyFrame() error
 }
 
+// RoomOutput publishes the tracks encoded by the pipeline
+type RoomOutput interface {
+	AddAudioTrack(output lksdk.SampleProvider, mimeType string, disableDTX bool, stereo bool) error
+	AddVideoTrack(outputs []VideoSampleProvider, layers []*livekit.VideoLayer, mimeType string) error
+	Close()
+}
+
 type LKSDKOutput struct {
 	room *lksdk.Room
 
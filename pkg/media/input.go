@@ -2,14 +2,19 @@ package media
 
 import (
 	"context"
+	"fmt"
 	"strings"
 	"sync"
 
 	"github.com/tinyzimmer/go-gst/gst"
 	"github.com/tinyzimmer/go-gst/gst/app"
+	"go.uber.org/atomic"
 
 	"github.com/livekit/ingress/pkg/errors"
+	"github.com/livekit/ingress/pkg/media/pull"
 	"github.com/livekit/ingress/pkg/media/rtmp"
+	"github.com/livekit/ingress/pkg/media/srt"
+	"github.com/livekit/ingress/pkg/media/websocket"
 	"github.com/livekit/ingress/pkg/media/whip"
 	"github.com/livekit/ingress/pkg/params"
 	"github.com/livekit/ingress/pkg/types"
@@ -18,12 +23,27 @@ import (
 	"github.com/livekit/protocol/logger"
 )
 
+const (
+	passthroughDecodeBinCaps = "video/x-h264;audio/x-raw"
+)
+
 type Source interface {
 	GetSources(ctx context.Context) []*app.Source
 	Start(ctx context.Context) error
 	Close() error
 }
 
+// FailoverSource is implemented by sources providing redundant versions of the same media through several app sources.
+// Only the media of the selected app source is forwarded.
+type FailoverSource interface {
+	OnSourceSelected(f func(src *app.Source))
+}
+
+// ReconnectingSource is implemented by sources waiting for a disconnected publisher to reconnect before ending
+type ReconnectingSource interface {
+	OnReconnecting(f func(reconnecting bool))
+}
+
 type Input struct {
 	lock sync.Mutex
 
@@ -33,6 +53,17 @@ type Input struct {
 	audioOutput *gst.Pad
 	videoOutput *gst.Pad
 
+	// only for failover sources
+	selectors    map[types.StreamKind]*gst.Element
+	selectorPads map[*app.Source]map[types.StreamKind]*gst.Pad
+	selectedSrc  *app.Source
+
+	// media received from the source, before decoding
+	bytesReceived atomic.Uint64
+	parsedStats   map[types.StreamKind]*parsedStats
+	parsers       map[string]bool
+	decodedStats  map[types.StreamKind]*decodedStats
+
 	onOutputReady OutputReadyFunc
 }
 
@@ -46,8 +77,11 @@ func NewInput(ctx context.Context, p *params.Params) (*Input, error) {
 
 	bin := gst.NewBin("input")
 	i := &Input{
-		bin:    bin,
-		source: src,
+		bin:          bin,
+		source:       src,
+		parsedStats:  make(map[types.StreamKind]*parsedStats),
+		parsers:      make(map[string]bool),
+		decodedStats: make(map[types.StreamKind]*decodedStats),
 	}
 
 	appSrcs := src.GetSources(ctx)
@@ -55,23 +89,53 @@ func NewInput(ctx context.Context, p *params.Params) (*Input, error) {
 		return nil, errors.ErrSourceNotReady
 	}
 
+	failoverSrc, failover := src.(FailoverSource)
+	failover = failover && len(appSrcs) > 1
+	if failover {
+		i.selectors = make(map[types.StreamKind]*gst.Element)
+		i.selectorPads = make(map[*app.Source]map[types.StreamKind]*gst.Pad)
+		failoverSrc.OnSourceSelected(i.onSourceSelected)
+	}
+
 	for _, appSrc := range appSrcs {
 		decodeBin, err := gst.NewElement("decodebin3")
 		if err != nil {
 			return nil, err
 		}
 
+		if p.BypassTranscoding {
+			// Stop at the parsed H.264 video, it will be forwarded as is
+			if err = decodeBin.SetProperty("caps", gst.NewCapsFromString(passthroughDecodeBinCaps)); err != nil {
+				return nil, err
+			}
+		}
+
 		if err := bin.AddMany(decodeBin, appSrc.Element); err != nil {
 			return nil, err
 		}
 
-		if _, err = decodeBin.Connect("pad-added", i.onPadAdded); err != nil {
+		if failover {
+			appSrc := appSrc
+			_, err = decodeBin.Connect("pad-added", func(_ *gst.Element, pad *gst.Pad) {
+				i.onFailoverPadAdded(appSrc, pad)
+			})
+		} else {
+			_, err = decodeBin.Connect("pad-added", i.onPadAdded)
+		}
+		if err != nil {
 			return nil, err
 		}
 
 		if err = appSrc.Link(decodeBin); err != nil {
 			return nil, err
 		}
+
+		appSrc.GetStaticPad("src").AddProbe(gst.PadProbeTypeBuffer, func(_ *gst.Pad, info *gst.PadProbeInfo) gst.PadProbeReturn {
+			if buffer := info.GetBuffer(); buffer != nil {
+				i.bytesReceived.Add(uint64(buffer.GetSize()))
+			}
+			return gst.PadProbeOK
+		})
 	}
 
 	return i, nil
@@ -83,6 +147,15 @@ func CreateSource(ctx context.Context, p *params.Params) (Source, error) {
 		return rtmp.NewRTMPRelaySource(ctx, p)
 	case livekit.IngressInput_WHIP_INPUT:
 		return whip.NewWHIPRelaySource(ctx, p)
+	case types.SRTInput, types.UDPInput:
+		// Both relay MPEG-TS from the service
+		return srt.NewSRTRelaySource(ctx, p)
+	case types.RTSPInput:
+		return pull.NewRTSPSource(ctx, p)
+	case types.URLInput:
+		return pull.NewURLSource(ctx, p)
+	case types.WebSocketInput:
+		return websocket.NewWebSocketRelaySource(ctx, p)
 	default:
 		return nil, ingress.ErrInvalidIngressType
 	}
@@ -92,6 +165,13 @@ func (i *Input) OnOutputReady(f OutputReadyFunc) {
 	i.onOutputReady = f
 }
 
+// OnReconnecting registers a callback called when the publisher disconnects and reconnects, if the source supports it
+func (i *Input) OnReconnecting(f func(reconnecting bool)) {
+	if rs, ok := i.source.(ReconnectingSource); ok {
+		rs.OnReconnecting(f)
+	}
+}
+
 func (i *Input) Start(ctx context.Context) error {
 	return i.source.Start(ctx)
 }
@@ -100,7 +180,113 @@ func (i *Input) Close() error {
 	return i.source.Close()
 }
 
+// BytesReceived returns the amount of media received from the source so far
+func (i *Input) BytesReceived() uint64 {
+	return i.bytesReceived.Load()
+}
+
+// TrackStats returns the counters of the media received so far for each kind. With failover sources, the bytes
+// and key frames of all the app sources are included, while frames are only counted for the selected one.
+func (i *Input) TrackStats() []*InputTrackStats {
+	gapsSrc, _ := i.source.(TimestampGapsSource)
+
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	trackStats := make([]*InputTrackStats, 0, len(i.decodedStats))
+	for _, kind := range []types.StreamKind{types.Audio, types.Video} {
+		parsed, decoded := i.parsedStats[kind], i.decodedStats[kind]
+		if parsed == nil && decoded == nil {
+			continue
+		}
+
+		ts := &InputTrackStats{
+			Kind: kind,
+		}
+		if parsed != nil {
+			ts.Bytes = parsed.bytes.Load()
+			ts.KeyFrameInterval = parsed.keyFrameInterval.Load()
+		}
+		if decoded != nil {
+			ts.Frames = decoded.frames.Load()
+			if kind == types.Video {
+				ts.Width, ts.Height = getVideoSize(decoded.pad.GetCurrentCaps())
+			}
+		}
+		if gapsSrc != nil {
+			ts.TimestampGaps = gapsSrc.TimestampGaps(kind)
+		}
+		trackStats = append(trackStats, ts)
+	}
+
+	return trackStats
+}
+
+// trackOutput counts the frames of the pad surfaced for a kind
+func (i *Input) trackOutput(pad *gst.Pad, kind types.StreamKind) {
+	st := &decodedStats{pad: pad}
+	pad.AddProbe(gst.PadProbeTypeBuffer, func(_ *gst.Pad, _ *gst.PadProbeInfo) gst.PadProbeReturn {
+		st.frames.Inc()
+		return gst.PadProbeOK
+	})
+
+	i.lock.Lock()
+	i.decodedStats[kind] = st
+	i.lock.Unlock()
+}
+
+// probeParsers counts the media output by the parsers the decodebins plugged so far. Parsers output whole frames
+// that are not decoded yet.
+func (i *Input) probeParsers() {
+	elements, err := i.bin.GetElementsRecursive()
+	if err != nil {
+		logger.Debugw("could not list input elements", "error", err)
+		return
+	}
+
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	for _, e := range elements {
+		factory := e.GetFactory()
+		if factory == nil {
+			continue
+		}
+
+		klass := factory.GetMetadata("klass")
+		if !strings.Contains(klass, "Parser") {
+			continue
+		}
+
+		var kind types.StreamKind
+		switch {
+		case strings.Contains(klass, "Video"):
+			kind = types.Video
+		case strings.Contains(klass, "Audio"):
+			kind = types.Audio
+		default:
+			continue
+		}
+
+		name := e.GetName()
+		pad := e.GetStaticPad("src")
+		if i.parsers[name] || pad == nil {
+			continue
+		}
+		i.parsers[name] = true
+
+		st, ok := i.parsedStats[kind]
+		if !ok {
+			st = &parsedStats{}
+			i.parsedStats[kind] = st
+		}
+		pad.AddProbe(gst.PadProbeTypeBuffer, st.newProbe())
+	}
+}
+
 func (i *Input) onPadAdded(_ *gst.Element, pad *gst.Pad) {
+	i.probeParsers()
+
 	// surface callback for first audio and video pads, plug in fakesink on the rest
 	i.lock.Lock()
 	newPad := false
@@ -130,6 +316,7 @@ func (i *Input) onPadAdded(_ *gst.Element, pad *gst.Pad) {
 			return
 		}
 		pad = ghostPad.Pad
+		i.trackOutput(pad, kind)
 	} else {
 		sink, err := gst.NewElement("fakesink")
 		if err != nil {
@@ -144,3 +331,102 @@ func (i *Input) onPadAdded(_ *gst.Element, pad *gst.Pad) {
 		i.onOutputReady(pad, kind)
 	}
 }
+
+// onFailoverPadAdded links the pads of all the app sources to an input-selector per media kind. The output of the selectors
+// is surfaced the same way the decodebin pads are for other sources.
+func (i *Input) onFailoverPadAdded(appSrc *app.Source, pad *gst.Pad) {
+	i.probeParsers()
+
+	var kind types.StreamKind
+	switch {
+	case strings.HasPrefix(pad.GetName(), "audio"):
+		kind = types.Audio
+	case strings.HasPrefix(pad.GetName(), "video"):
+		kind = types.Video
+	default:
+		return
+	}
+
+	i.lock.Lock()
+	if i.selectorPads[appSrc][kind] != nil {
+		// Only the first pad of a given kind is used
+		i.lock.Unlock()
+		return
+	}
+
+	selector, ok := i.selectors[kind]
+	if !ok {
+		var err error
+		selector, err = i.addSelector(kind)
+		if err != nil {
+			i.lock.Unlock()
+			logger.Errorw("failed to create input selector", err, "kind", kind)
+			return
+		}
+	}
+
+	sinkPad := selector.GetRequestPad("sink_%u")
+	if linkReturn := pad.Link(sinkPad); linkReturn != gst.PadLinkOK {
+		i.lock.Unlock()
+		logger.Errorw("failed to link input selector", nil, "kind", kind, "linkReturn", linkReturn)
+		return
+	}
+
+	if i.selectorPads[appSrc] == nil {
+		i.selectorPads[appSrc] = make(map[types.StreamKind]*gst.Pad)
+	}
+	i.selectorPads[appSrc][kind] = sinkPad
+	if appSrc == i.selectedSrc {
+		if err := selector.SetProperty("active-pad", sinkPad); err != nil {
+			logger.Errorw("failed to set input selector active pad", err, "kind", kind)
+		}
+	}
+	i.lock.Unlock()
+
+	if ok {
+		// Selector output already surfaced
+		return
+	}
+
+	ghostPad := gst.NewGhostPad(string(kind), selector.GetStaticPad("src"))
+	if !i.bin.AddPad(ghostPad.Pad) {
+		logger.Errorw("failed to add ghost pad", nil)
+		return
+	}
+	i.trackOutput(ghostPad.Pad, kind)
+
+	if i.onOutputReady != nil {
+		i.onOutputReady(ghostPad.Pad, kind)
+	}
+}
+
+func (i *Input) addSelector(kind types.StreamKind) (*gst.Element, error) {
+	selector, err := gst.NewElementWithName("input-selector", fmt.Sprintf("%s_selector", kind))
+	if err != nil {
+		return nil, err
+	}
+	// Drop the media of the inactive inputs instead of blocking them until the active one catches up
+	if err = selector.SetProperty("sync-streams", false); err != nil {
+		return nil, err
+	}
+	if err = i.bin.Add(selector); err != nil {
+		return nil, err
+	}
+	selector.SyncStateWithParent()
+
+	i.selectors[kind] = selector
+
+	return selector, nil
+}
+
+func (i *Input) onSourceSelected(appSrc *app.Source) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	i.selectedSrc = appSrc
+	for kind, sinkPad := range i.selectorPads[appSrc] {
+		if err := i.selectors[kind].SetProperty("active-pad", sinkPad); err != nil {
+			logger.Errorw("failed to set input selector active pad", err, "kind", kind)
+		}
+	}
+}
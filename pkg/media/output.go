@@ -3,13 +3,18 @@ package media
 import (
 	"fmt"
 	"io"
+	"strings"
+	"time"
 
 	"github.com/pion/webrtc/v3/pkg/media"
 	"github.com/pion/webrtc/v3/pkg/media/h264reader"
 	"github.com/tinyzimmer/go-gst/gst"
 	"github.com/tinyzimmer/go-gst/gst/app"
+	"go.uber.org/atomic"
 
 	"github.com/livekit/ingress/pkg/errors"
+	"github.com/livekit/ingress/pkg/recorder"
+	"github.com/livekit/ingress/pkg/types"
 	"github.com/livekit/protocol/livekit"
 	"github.com/livekit/protocol/logger"
 )
@@ -26,8 +31,16 @@ type Output struct {
 	elements []*gst.Element
 	enc      *gst.Element
 	sink     *app.Sink
+	recorder *recorder.TrackRecorder // only set if recording is enabled
 
 	samples chan *media.Sample
+
+	kind           types.StreamKind
+	layer          string // only for video
+	bytesSent      atomic.Uint64
+	samplesDelayed atomic.Uint64
+	latency        *latencyTracker // only when transcoding
+	level          *audioLevel     // only for audio
 }
 
 // FIXME Use generics instead?
@@ -128,11 +141,63 @@ func NewVideoOutput(codec livekit.VideoCodec, layer *livekit.VideoLayer) (*Video
 
 	e.elements = append(e.elements, queue, e.sink.Element)
 
+	e.layer = strings.ToLower(layer.Quality.String())
 	e.bin = gst.NewBin(fmt.Sprintf("video_%s", layer.Quality.String()))
 	if err = e.linkElements(); err != nil {
 		return nil, err
 	}
 
+	e.latency = newLatencyTracker()
+	e.latency.track(e.enc)
+
+	return e, nil
+}
+
+// NewPassthroughVideoOutput forwards parsed H.264 video without transcoding it
+func NewPassthroughVideoOutput() (*VideoOutput, error) {
+	e, err := newVideoOutput(livekit.VideoCodec_H264_BASELINE)
+	if err != nil {
+		return nil, err
+	}
+
+	parse, err := gst.NewElement("h264parse")
+	if err != nil {
+		return nil, err
+	}
+	// Repeat SPS/PPS before every IDR frame so that subscribers can start decoding at any key frame
+	if err = parse.SetProperty("config-interval", -1); err != nil {
+		return nil, err
+	}
+
+	capsFilter, err := gst.NewElement("capsfilter")
+	if err != nil {
+		return nil, err
+	}
+	if err = capsFilter.SetProperty("caps", gst.NewCapsFromString(
+		"video/x-h264,stream-format=byte-stream,alignment=au",
+	)); err != nil {
+		return nil, err
+	}
+
+	queue, err := gst.NewElement("queue")
+	if err != nil {
+		return nil, err
+	}
+	if err = queue.SetProperty("max-size-time", uint64(3e9)); err != nil {
+		return nil, err
+	}
+
+	e.elements = []*gst.Element{
+		parse, capsFilter, queue, e.sink.Element,
+	}
+
+	// Published as the high quality layer
+	e.layer = strings.ToLower(livekit.VideoQuality_HIGH.String())
+	e.bin = gst.NewBin("video_passthrough")
+	if err = e.linkElements(); err != nil {
+		return nil, err
+	}
+
 	return e, nil
 }
 
@@ -210,11 +275,18 @@ func NewAudioOutput(options *livekit.IngressAudioEncodingOptions) (*AudioOutput,
 		return nil, err
 	}
 
+	e.latency = newLatencyTracker()
+	e.latency.track(e.enc)
+
+	// Measured on the raw audio, before encoding
+	e.level = &audioLevel{}
+	capsFilter.GetStaticPad("src").AddProbe(gst.PadProbeTypeBuffer, e.level.newProbe())
+
 	return e, nil
 }
 
 func newVideoOutput(codec livekit.VideoCodec) (*VideoOutput, error) {
-	e, err := newOutput()
+	e, err := newOutput(types.Video)
 	if err != nil {
 		return nil, err
 	}
@@ -233,7 +305,7 @@ func newVideoOutput(codec livekit.VideoCodec) (*VideoOutput, error) {
 }
 
 func newAudioOutput(codec livekit.AudioCodec) (*AudioOutput, error) {
-	e, err := newOutput()
+	e, err := newOutput(types.Audio)
 	if err != nil {
 		return nil, err
 	}
@@ -251,7 +323,7 @@ func newAudioOutput(codec livekit.AudioCodec) (*AudioOutput, error) {
 	return o, nil
 }
 
-func newOutput() (*Output, error) {
+func newOutput(kind types.StreamKind) (*Output, error) {
 	sink, err := app.NewAppSink()
 	if err != nil {
 		return nil, err
@@ -260,6 +332,7 @@ func newOutput() (*Output, error) {
 	e := &Output{
 		sink:    sink,
 		samples: make(chan *media.Sample, 100),
+		kind:    kind,
 	}
 
 	return e, nil
@@ -285,6 +358,11 @@ func (e *Output) Bin() *gst.Bin {
 }
 
 func (e *Output) ForceKeyFrame() error {
+	if e.enc == nil {
+		// Passthrough, key frames are only sent by the publisher
+		return nil
+	}
+
 	keyFrame := gst.NewStructure("GstForceKeyUnit")
 	if err := keyFrame.SetValue("all-headers", true); err != nil {
 		return err
@@ -294,19 +372,48 @@ func (e *Output) ForceKeyFrame() error {
 }
 
 func (e *Output) handleEOS(_ *app.Sink) {
+	if e.recorder != nil {
+		e.recorder.Close()
+	}
 	close(e.samples)
 }
 
-func (e *Output) writeSample(sample *media.Sample) {
+func (e *Output) writeSample(sample *media.Sample, pts time.Duration) {
+	if e.recorder != nil {
+		e.recorder.WriteSample(sample, pts)
+	}
+
+	e.bytesSent.Add(uint64(len(sample.Data)))
+
 	select {
 	case e.samples <- sample:
 		// continue
 	default:
 		logger.Warnw("sample channel full", nil)
+		e.samplesDelayed.Inc()
 		e.samples <- sample
 	}
 }
 
+// Stats returns the counters of the media sent so far
+func (e *Output) Stats() *OutputTrackStats {
+	st := &OutputTrackStats{
+		Kind:           e.kind,
+		Layer:          e.layer,
+		Bytes:          e.bytesSent.Load(),
+		SamplesDelayed: e.samplesDelayed.Load(),
+	}
+	if e.latency != nil {
+		st.EncoderLatency = e.latency.get()
+	}
+	if e.level != nil {
+		st.AudioSumSquares = e.level.sumSquares.Load()
+		st.AudioSamples = e.level.samples.Load()
+	}
+
+	return st
+}
+
 func (e *Output) NextSample() (media.Sample, error) {
 	sample := <-e.samples
 	if sample == nil {
@@ -379,14 +486,14 @@ func (e *VideoOutput) handleSample(sink *app.Sink) gst.FlowReturn {
 		e.writeSample(&media.Sample{
 			Data:     buffer.Bytes(),
 			Duration: duration,
-		})
+		}, buffer.PresentationTimestamp())
 
 	case livekit.VideoCodec_VP8:
 		// untested
 		e.writeSample(&media.Sample{
 			Data:     buffer.Bytes(),
 			Duration: duration,
-		})
+		}, buffer.PresentationTimestamp())
 	}
 
 	return gst.FlowOK
@@ -412,7 +519,7 @@ func (e *AudioOutput) handleSample(sink *app.Sink) gst.FlowReturn {
 		e.writeSample(&media.Sample{
 			Data:     buffer.Bytes(),
 			Duration: duration,
-		})
+		}, buffer.PresentationTimestamp())
 	}
 
 	return gst.FlowOK
@@ -2,11 +2,13 @@ package media
 
 import (
 	"context"
+	"math"
 	"time"
 
 	"github.com/frostbyte73/core"
 	"github.com/tinyzimmer/go-glib/glib"
 	"github.com/tinyzimmer/go-gst/gst"
+	"go.uber.org/atomic"
 
 	"github.com/livekit/ingress/pkg/config"
 	"github.com/livekit/ingress/pkg/params"
@@ -18,7 +20,8 @@ import (
 )
 
 const (
-	creationTimeout = 10 * time.Second
+	creationTimeout    = 10 * time.Second
+	frameRateTolerance = 2
 )
 
 type Pipeline struct {
@@ -29,12 +32,20 @@ type Pipeline struct {
 	loop     *glib.MainLoop
 	sink     *WebRTCSink
 	input    *Input
+	slate    *Slate
 
 	onStatusUpdate func(context.Context, *livekit.IngressInfo)
+	reconnecting   atomic.Bool
 	closed         core.Fuse
 }
 
 func New(ctx context.Context, conf *config.Config, params *params.Params) (*Pipeline, error) {
+	return NewWithRoomOutput(ctx, conf, params, newLKSDKOutput)
+}
+
+// NewWithRoomOutput creates a pipeline publishing its tracks to the output created by newOutput, rather than to the room
+// of the ingress
+func NewWithRoomOutput(ctx context.Context, conf *config.Config, params *params.Params, newOutput RoomOutputFunc) (*Pipeline, error) {
 	ctx, span := tracer.Start(ctx, "Pipeline.New")
 	defer span.End()
 
@@ -58,7 +69,7 @@ func New(ctx context.Context, conf *config.Config, params *params.Params) (*Pipe
 		return nil, err
 	}
 
-	sink, err := NewWebRTCSink(ctx, params)
+	sink, err := NewWebRTCSink(ctx, params, newOutput)
 	if err != nil {
 		return nil, err
 	}
@@ -71,7 +82,16 @@ func New(ctx context.Context, conf *config.Config, params *params.Params) (*Pipe
 		closed:   core.NewFuse(),
 	}
 
+	if conf.Slate.Enabled {
+		if params.BypassTranscoding {
+			logger.Infow("slate not supported when transcoding is bypassed")
+		} else {
+			p.slate = NewSlate(&conf.Slate, params.VideoEncodingOptions)
+		}
+	}
+
 	input.OnOutputReady(p.onOutputReady)
+	input.OnReconnecting(p.onReconnecting)
 
 	return p, nil
 }
@@ -93,7 +113,9 @@ func (p *Pipeline) onOutputReady(pad *gst.Pad, kind types.StreamKind) {
 		}
 	}()
 
-	bin, err := p.sink.AddTrack(kind)
+	p.setInputState(pad, kind)
+
+	bin, err := p.sink.AddTrack(kind, pad.GetCurrentCaps())
 	if err != nil {
 		return
 	}
@@ -103,9 +125,18 @@ func (p *Pipeline) onOutputReady(pad *gst.Pad, kind types.StreamKind) {
 		return
 	}
 
+	sinkPad := bin.GetStaticPad("sink")
+	if p.slate != nil {
+		sinkPad, err = p.slate.AddTrack(p.pipeline, kind, sinkPad)
+		if err != nil {
+			logger.Errorw("could not add slate", err)
+			return
+		}
+	}
+
 	pad.AddProbe(gst.PadProbeTypeBlockDownstream, func(pad *gst.Pad, info *gst.PadProbeInfo) gst.PadProbeReturn {
 		// link
-		if linkReturn := pad.Link(bin.GetStaticPad("sink")); linkReturn != gst.PadLinkOK {
+		if linkReturn := pad.Link(sinkPad); linkReturn != gst.PadLinkOK {
 			logger.Errorw("failed to link output bin", err)
 		}
 
@@ -116,8 +147,142 @@ func (p *Pipeline) onOutputReady(pad *gst.Pad, kind types.StreamKind) {
 	})
 }
 
+// setInputState describes the media received from the publisher in the ingress state
+func (p *Pipeline) setInputState(pad *gst.Pad, kind types.StreamKind) {
+	// The output pad carries the decoded media, its stream the original one
+	caps := pad.GetCurrentCaps()
+	streamCaps := caps
+	if stream := pad.GetStream(); stream != nil {
+		if c := stream.Caps(); c != nil && c.GetSize() > 0 {
+			streamCaps = c
+		}
+	}
+	if caps == nil || caps.GetSize() == 0 || streamCaps == nil || streamCaps.GetSize() == 0 {
+		return
+	}
+
+	st := caps.GetStructureAt(0)
+	mimeType := streamCaps.GetStructureAt(0).Name()
+
+	switch kind {
+	case types.Audio:
+		audio := &livekit.InputAudioState{
+			MimeType:   mimeType,
+			Channels:   getUintValue(st, "channels"),
+			SampleRate: getUintValue(st, "rate"),
+		}
+		p.UpdateState(func(state *livekit.IngressState) {
+			state.Audio = audio
+		})
+	case types.Video:
+		video := &livekit.InputVideoState{
+			MimeType: mimeType,
+			Width:    getUintValue(st, "width"),
+			Height:   getUintValue(st, "height"),
+		}
+		if v, err := st.GetValue("framerate"); err == nil {
+			if f, ok := v.(*gst.FractionValue); ok && f.Denom() > 0 {
+				video.Framerate = uint32(f.Num() / f.Denom())
+			}
+		}
+		p.UpdateState(func(state *livekit.IngressState) {
+			state.Video = video
+		})
+	}
+}
+
+func getVideoSize(caps *gst.Caps) (uint32, uint32) {
+	if caps == nil || caps.GetSize() == 0 {
+		return 0, 0
+	}
+
+	st := caps.GetStructureAt(0)
+	return getUintValue(st, "width"), getUintValue(st, "height")
+}
+
+func getUintValue(st *gst.Structure, name string) uint32 {
+	v, err := st.GetValue(name)
+	if err != nil {
+		return 0
+	}
+	i, ok := v.(int)
+	if !ok || i < 0 {
+		return 0
+	}
+
+	return uint32(i)
+}
+
+func (p *Pipeline) onReconnecting(reconnecting bool) {
+	p.reconnecting.Store(reconnecting)
+
+	if reconnecting {
+		logger.Infow("publisher disconnected, waiting for reconnection")
+		// The protocol has no reconnecting status. It is only reported to the service, with the session stats
+		p.SetStatus(livekit.IngressState_ENDPOINT_BUFFERING, "")
+	} else {
+		logger.Infow("publisher reconnected")
+		p.SetStatus(livekit.IngressState_ENDPOINT_PUBLISHING, "")
+	}
+
+	if p.onStatusUpdate != nil {
+		p.onStatusUpdate(context.Background(), p.GetInfo())
+	}
+}
+
+// IsReconnecting returns true while waiting for a disconnected publisher or source to reconnect
+func (p *Pipeline) IsReconnecting() bool {
+	return p.reconnecting.Load()
+}
+
+// UpdateVideoState updates the video input state with the measured resolution and frame rate. Returns true if it changed
+// enough to be worth an update to the control plane.
+func (p *Pipeline) UpdateVideoState(width, height uint32, frameRate float64) bool {
+	if width == 0 || height == 0 {
+		return false
+	}
+
+	changed := false
+	p.UpdateState(func(state *livekit.IngressState) {
+		video := state.Video
+		if video == nil {
+			return
+		}
+
+		if video.Width != width || video.Height != height {
+			video.Width = width
+			video.Height = height
+			changed = true
+		}
+
+		// The measured frame rate fluctuates around the nominal one
+		if fps := uint32(math.Round(frameRate)); fps > 0 && math.Abs(float64(fps)-float64(video.Framerate)) > frameRateTolerance {
+			video.Framerate = fps
+			changed = true
+		}
+	})
+
+	return changed
+}
+
+// GetInfo returns a copy of the ingress info, with the current state
 func (p *Pipeline) GetInfo() *livekit.IngressInfo {
-	return p.Params.IngressInfo
+	return p.CopyInfo()
+}
+
+// GetInputBytes returns the amount of media received from the publisher or source so far
+func (p *Pipeline) GetInputBytes() uint64 {
+	return p.input.BytesReceived()
+}
+
+// GetInputStats returns the counters of the media received so far for each kind
+func (p *Pipeline) GetInputStats() []*InputTrackStats {
+	return p.input.TrackStats()
+}
+
+// GetOutputStats returns the counters of the media sent so far for each track and simulcast layer
+func (p *Pipeline) GetOutputStats() []*OutputTrackStats {
+	return p.sink.TrackStats()
 }
 
 func (p *Pipeline) OnStatusUpdate(f func(context.Context, *livekit.IngressInfo)) {
@@ -152,6 +317,9 @@ func (p *Pipeline) Run(ctx context.Context) *livekit.IngressInfo {
 	p.loop.Run()
 
 	err = p.input.Close()
+	if p.slate != nil {
+		p.slate.Close()
+	}
 	p.sink.Close()
 
 	switch err {
@@ -0,0 +1,332 @@
+package pull
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/frostbyte73/core"
+	"github.com/tinyzimmer/go-gst/gst"
+	"github.com/tinyzimmer/go-gst/gst/app"
+	"go.uber.org/atomic"
+
+	"github.com/livekit/ingress/pkg/errors"
+	"github.com/livekit/protocol/logger"
+)
+
+const (
+	minRetryDelay = time.Second
+	maxRetryDelay = 30 * time.Second
+	// Consecutive failed connection attempts before giving up
+	maxRetries = 10
+
+	busPollInterval = 100 * time.Millisecond
+)
+
+// buildFunc adds the elements pulling the media to the pipeline, and links them to the sink.
+// It is called on every connection attempt, with a new pipeline.
+type buildFunc func(pipeline *gst.Pipeline, sink *gst.Element) error
+
+// PullSource pulls media from a remote server in an internal pipeline, and pushes the resulting stream to the
+// app source of the ingress pipeline. For live sources, the internal pipeline is rebuilt with an exponential backoff
+// when the connection fails or the remote stream ends. Other sources are read at the pace of the ingress pipeline,
+// and end the ingress on the first failure or at the end of the media, unless looping.
+type PullSource struct {
+	logger logger.Logger
+	build  buildFunc
+	appSrc *app.Source
+	live   bool
+	loop   bool
+
+	lock           sync.Mutex
+	onReconnecting func(reconnecting bool)
+	reconnecting   bool
+
+	closed core.Fuse
+	result chan error
+}
+
+func newPullSource(l logger.Logger, name string, caps string, live bool, build buildFunc) (*PullSource, error) {
+	elem, err := gst.NewElementWithName("appsrc", name)
+	if err != nil {
+		logger.Errorw("could not create appsrc", err)
+		return nil, err
+	}
+	if err = elem.SetProperty("caps", gst.NewCapsFromString(caps)); err != nil {
+		return nil, err
+	}
+	if err = elem.SetProperty("is-live", true); err != nil {
+		return nil, err
+	}
+	elem.SetArg("format", "time")
+	if !live {
+		// Back pressure from the ingress pipeline paces the reading of the media
+		if err = elem.SetProperty("block", true); err != nil {
+			return nil, err
+		}
+	}
+
+	return &PullSource{
+		logger: l,
+		build:  build,
+		appSrc: app.SrcFromElement(elem),
+		live:   live,
+		closed: core.NewFuse(),
+	}, nil
+}
+
+func (s *PullSource) GetSources(ctx context.Context) []*app.Source {
+	return []*app.Source{s.appSrc}
+}
+
+func (s *PullSource) OnReconnecting(f func(reconnecting bool)) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.onReconnecting = f
+}
+
+func (s *PullSource) Start(ctx context.Context) error {
+	s.result = make(chan error, 1)
+
+	go func() {
+		err := s.run()
+		s.appSrc.EndStream()
+
+		s.result <- err
+		close(s.result)
+	}()
+
+	return nil
+}
+
+func (s *PullSource) Close() error {
+	s.closed.Break()
+	if !s.live {
+		// Release a blocked push, the ingress pipeline may not be running anymore
+		_ = s.appSrc.SetState(gst.StateNull)
+	}
+	return <-s.result
+}
+
+func (s *PullSource) run() error {
+	if !s.live {
+		_, err := s.pull()
+		switch err {
+		case nil, io.EOF:
+			return nil
+		default:
+			s.logger.Warnw("pulling media failed", err)
+			return errors.ErrPullFailed(err)
+		}
+	}
+
+	retryDelay := minRetryDelay
+	failures := 0
+
+	for {
+		received, err := s.pull()
+		if s.closed.IsBroken() {
+			return nil
+		}
+
+		if received {
+			// The connection was established, start over with the retries
+			retryDelay = minRetryDelay
+			failures = 0
+			s.setReconnecting(true)
+		}
+
+		failures++
+		if failures > maxRetries {
+			s.logger.Warnw("giving up pulling media", err)
+			return errors.ErrPullFailed(err)
+		}
+
+		s.logger.Infow("pulling media failed, retrying", "error", err, "delay", retryDelay)
+
+		select {
+		case <-s.closed.Watch():
+			return nil
+		case <-time.After(retryDelay):
+		}
+
+		retryDelay *= 2
+		if retryDelay > maxRetryDelay {
+			retryDelay = maxRetryDelay
+		}
+	}
+}
+
+// pull runs the internal pipeline until it fails, ends or the source is closed. It returns true if any media was received
+func (s *PullSource) pull() (bool, error) {
+	pipeline, err := gst.NewPipeline("")
+	if err != nil {
+		return false, err
+	}
+
+	sink, err := app.NewAppSink()
+	if err != nil {
+		return false, err
+	}
+	// The ingress pipeline is in charge of synchronization
+	if err = sink.SetProperty("sync", false); err != nil {
+		return false, err
+	}
+
+	received := atomic.NewBool(false)
+	sink.SetCallbacks(&app.SinkCallbacks{
+		NewSampleFunc: func(sink *app.Sink) gst.FlowReturn {
+			sample := sink.PullSample()
+			if sample == nil {
+				return gst.FlowEOS
+			}
+			buffer := sample.GetBuffer()
+			if buffer == nil {
+				return gst.FlowError
+			}
+
+			if !received.Swap(true) {
+				s.setReconnecting(false)
+			}
+
+			// Timestamps are recovered from the stream by the ingress pipeline demuxer
+			ret := s.appSrc.PushBuffer(gst.NewBufferFromBytes(buffer.Bytes()))
+			switch ret {
+			case gst.FlowOK, gst.FlowFlushing:
+				return gst.FlowOK
+			default:
+				return ret
+			}
+		},
+	})
+
+	if err = pipeline.Add(sink.Element); err != nil {
+		return false, err
+	}
+	if err = s.build(pipeline, sink.Element); err != nil {
+		return false, err
+	}
+
+	defer func() {
+		_ = pipeline.BlockSetState(gst.StateNull)
+	}()
+	if err = pipeline.SetState(gst.StatePlaying); err != nil {
+		return false, err
+	}
+
+	msgTypes := gst.MessageError | gst.MessageEOS
+	if s.loop {
+		msgTypes |= gst.MessageAsyncDone | gst.MessageSegmentDone
+	}
+	looping := false
+
+	bus := pipeline.GetPipelineBus()
+	for !s.closed.IsBroken() {
+		msg := bus.TimedPopFiltered(busPollInterval, msgTypes)
+		if msg == nil {
+			continue
+		}
+
+		switch msg.Type() {
+		case gst.MessageError:
+			return received.Load(), msg.ParseError()
+		case gst.MessageEOS:
+			return received.Load(), io.EOF
+		case gst.MessageAsyncDone:
+			// Segment seeks post a segment done message instead of ending the stream,
+			// and keep the running time increasing across loops
+			if !looping {
+				looping = seekToStart(pipeline, gst.SeekFlagFlush)
+				if !looping {
+					s.logger.Warnw("media cannot be looped", nil)
+				}
+			}
+		case gst.MessageSegmentDone:
+			if !seekToStart(pipeline, gst.SeekFlagNone) {
+				return received.Load(), io.EOF
+			}
+		}
+	}
+
+	return received.Load(), nil
+}
+
+func seekToStart(pipeline *gst.Pipeline, flags gst.SeekFlags) bool {
+	return pipeline.SendEvent(gst.NewSeekEvent(1.0, gst.FormatTime, flags|gst.SeekFlagSegment, gst.SeekTypeSet, 0, gst.SeekTypeNone, -1))
+}
+
+func (s *PullSource) setReconnecting(reconnecting bool) {
+	s.lock.Lock()
+	if s.reconnecting == reconnecting {
+		s.lock.Unlock()
+		return
+	}
+	s.reconnecting = reconnecting
+	onReconnecting := s.onReconnecting
+	s.lock.Unlock()
+
+	if onReconnecting != nil {
+		onReconnecting(reconnecting)
+	}
+}
+
+// addParseBin parses the stream of the pad, and calls onParsedPad for each elementary stream found in it
+func addParseBin(l logger.Logger, pipeline *gst.Pipeline, pad *gst.Pad, onParsedPad func(pad *gst.Pad)) {
+	parse, err := gst.NewElement("parsebin")
+	if err != nil {
+		l.Errorw("could not create parsebin", err)
+		return
+	}
+
+	_, err = parse.Connect("pad-added", func(_ *gst.Element, pad *gst.Pad) {
+		onParsedPad(pad)
+	})
+	if err != nil {
+		l.Errorw("could not connect parsebin", err)
+		return
+	}
+
+	if err = pipeline.Add(parse); err != nil {
+		l.Errorw("could not add parsebin", err)
+		return
+	}
+	parse.SyncStateWithParent()
+
+	if linkReturn := pad.Link(parse.GetStaticPad("sink")); linkReturn != gst.PadLinkOK {
+		l.Errorw("failed to link parsebin", fmt.Errorf("%v", linkReturn))
+	}
+}
+
+// linkToMuxer links the pad to a new request pad of the muxer. Streams the muxer does not accept are dropped
+func linkToMuxer(l logger.Logger, pipeline *gst.Pipeline, mux *gst.Element, pad *gst.Pad, padTemplate string) {
+	if padTemplate != "" {
+		muxPad := mux.GetRequestPad(padTemplate)
+		if muxPad != nil {
+			linkReturn := pad.Link(muxPad)
+			if linkReturn == gst.PadLinkOK {
+				return
+			}
+			mux.ReleaseRequestPad(muxPad)
+		}
+	}
+
+	l.Infow("ignoring unsupported stream", "caps", pad.QueryCaps(nil).String())
+	linkFakeSink(l, pipeline, pad)
+}
+
+func linkFakeSink(l logger.Logger, pipeline *gst.Pipeline, pad *gst.Pad) {
+	sink, err := gst.NewElement("fakesink")
+	if err != nil {
+		l.Errorw("failed to create fakesink", err)
+		return
+	}
+	if err = pipeline.Add(sink); err != nil {
+		l.Errorw("failed to add fakesink", err)
+		return
+	}
+	sink.SyncStateWithParent()
+	pad.Link(sink.GetStaticPad("sink"))
+}
@@ -0,0 +1,68 @@
+package pull
+
+import (
+	"context"
+
+	"github.com/tinyzimmer/go-gst/gst"
+
+	"github.com/livekit/ingress/pkg/params"
+	"github.com/livekit/protocol/logger"
+	"github.com/livekit/protocol/tracer"
+)
+
+const (
+	RTSPAppSource = "rtspAppSrc"
+)
+
+// NewRTSPSource pulls the streams of an RTSP server, and remuxes them to MPEG-TS for the ingress pipeline
+func NewRTSPSource(ctx context.Context, p *params.Params) (*PullSource, error) {
+	ctx, span := tracer.Start(ctx, "RTSPSource.New")
+	defer span.End()
+
+	var transport string
+	if ep, ok := p.ExtraParams.(*params.PullExtraParams); ok {
+		transport = ep.RTSPTransport
+	}
+
+	l := logger.GetLogger().WithValues("url", params.RedactURL(p.Url), "transport", transport)
+
+	return newPullSource(l, RTSPAppSource, "video/mpegts,systemstream=true,packetsize=188", true, func(pipeline *gst.Pipeline, sink *gst.Element) error {
+		return buildRTSPPipeline(l, pipeline, sink, p.Url, transport)
+	})
+}
+
+func buildRTSPPipeline(l logger.Logger, pipeline *gst.Pipeline, sink *gst.Element, url string, transport string) error {
+	src, err := gst.NewElement("rtspsrc")
+	if err != nil {
+		return err
+	}
+	if err = src.SetProperty("location", url); err != nil {
+		return err
+	}
+	if transport != "" {
+		// Interleaved TCP or UDP only. Both are tried by default, UDP first
+		src.SetArg("protocols", transport)
+	}
+
+	mux, err := gst.NewElement("mpegtsmux")
+	if err != nil {
+		return err
+	}
+
+	if err = pipeline.AddMany(src, mux); err != nil {
+		return err
+	}
+	if err = mux.Link(sink); err != nil {
+		return err
+	}
+
+	// One pad per RTP stream, depayloaded and parsed before muxing
+	_, err = src.Connect("pad-added", func(_ *gst.Element, pad *gst.Pad) {
+		addParseBin(l, pipeline, pad, func(pad *gst.Pad) {
+			// Codecs that cannot be carried in MPEG-TS, e.g. G.711 audio, are dropped
+			linkToMuxer(l, pipeline, mux, pad, "sink_%d")
+		})
+	})
+
+	return err
+}
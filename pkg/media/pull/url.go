@@ -0,0 +1,95 @@
+package pull
+
+import (
+	"context"
+	"strings"
+
+	"github.com/tinyzimmer/go-gst/gst"
+
+	"github.com/livekit/ingress/pkg/params"
+	"github.com/livekit/protocol/logger"
+	"github.com/livekit/protocol/tracer"
+)
+
+const (
+	URLAppSource = "urlAppSrc"
+)
+
+// NewURLSource pulls the media of an HLS playlist, of a file served over HTTP or of a local file, and remuxes it
+// to Matroska for the ingress pipeline. Unlike MPEG-TS, Matroska can carry the VP8 and VP9 video of WebM files.
+func NewURLSource(ctx context.Context, p *params.Params) (*PullSource, error) {
+	ctx, span := tracer.Start(ctx, "URLSource.New")
+	defer span.End()
+
+	var loop bool
+	if ep, ok := p.ExtraParams.(*params.PullExtraParams); ok {
+		loop = ep.Loop
+	}
+
+	l := logger.GetLogger().WithValues("url", params.RedactURL(p.Url), "loop", loop)
+
+	s, err := newPullSource(l, URLAppSource, "video/x-matroska", false, func(pipeline *gst.Pipeline, sink *gst.Element) error {
+		return buildURLPipeline(l, pipeline, sink, p.Url)
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.loop = loop
+
+	return s, nil
+}
+
+func buildURLPipeline(l logger.Logger, pipeline *gst.Pipeline, sink *gst.Element, url string) error {
+	// Handles the HLS playlists as well as the plain HTTP and file URIs
+	src, err := gst.NewElement("urisourcebin")
+	if err != nil {
+		return err
+	}
+	if err = src.SetProperty("uri", url); err != nil {
+		return err
+	}
+
+	mux, err := gst.NewElement("matroskamux")
+	if err != nil {
+		return err
+	}
+	if err = mux.SetProperty("streamable", true); err != nil {
+		return err
+	}
+
+	if err = pipeline.AddMany(src, mux); err != nil {
+		return err
+	}
+	if err = mux.Link(sink); err != nil {
+		return err
+	}
+
+	_, err = src.Connect("pad-added", func(_ *gst.Element, pad *gst.Pad) {
+		addParseBin(l, pipeline, pad, func(pad *gst.Pad) {
+			linkToMuxer(l, pipeline, mux, pad, muxerPadTemplate(pad))
+		})
+	})
+
+	return err
+}
+
+// muxerPadTemplate returns the matroskamux request pad template matching the media kind of the pad
+func muxerPadTemplate(pad *gst.Pad) string {
+	caps := pad.GetCurrentCaps()
+	if caps == nil {
+		caps = pad.QueryCaps(nil)
+	}
+	if caps == nil || caps.GetSize() == 0 {
+		return ""
+	}
+
+	name := caps.GetStructureAt(0).Name()
+	switch {
+	case strings.HasPrefix(name, "video/"):
+		return "video_%u"
+	case strings.HasPrefix(name, "audio/"):
+		return "audio_%u"
+	default:
+		return ""
+	}
+}
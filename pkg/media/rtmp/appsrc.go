@@ -4,27 +4,65 @@ import (
 	"context"
 	"io"
 	"net/http"
+	"sync"
+	"time"
 
+	"github.com/frostbyte73/core"
 	"github.com/tinyzimmer/go-gst/gst"
 	"github.com/tinyzimmer/go-gst/gst/app"
 	"go.uber.org/atomic"
 
 	"github.com/livekit/ingress/pkg/errors"
 	"github.com/livekit/ingress/pkg/params"
+	"github.com/livekit/ingress/pkg/types"
 	"github.com/livekit/protocol/logger"
 	"github.com/livekit/protocol/tracer"
 )
 
 const (
-	FlvAppSource = "flvAppSrc"
+	FlvAppSource       = "flvAppSrc"
+	FlvBackupAppSource = "flvBackupAppSrc"
+
+	// FLV file header followed by the first PreviousTagSize field
+	flvHeaderSize = 13
+
+	relayRetryInterval = time.Second
+	stallCheckInterval = 500 * time.Millisecond
+	stallTimeout       = 2 * time.Second
 )
 
+// RTMPRelaySource pulls the FLV stream of the RTMP publisher from the relay. If a backup publisher
+// is accepted, both streams are pulled, and the selected one is switched whenever the active publisher
+// stalls or disconnects. The source ends once no publisher is connected anymore, after the reconnection grace period if any.
+// The timestamps of each publisher session are rebased on a timeline shared by the inputs, see flvTimestampRebaser.
 type RTMPRelaySource struct {
-	params *params.Params
+	params      *params.Params
+	gracePeriod time.Duration
+	createdAt   time.Time // origin of the timeline of the rebased timestamps
+
+	lock           sync.Mutex
+	inputs         []*relayInput
+	active         *relayInput
+	onSelect       func(src *app.Source)
+	onReconnecting func(reconnecting bool)
+	graceTimer     *time.Timer
+
+	fuse    core.Fuse
+	endOnce sync.Once
+	result  chan error
+}
+
+type relayInput struct {
+	name       string
+	relayUrl   string
+	flvSrc     *app.Source
+	writer     *appSrcWriter
+	timestamps *flvTimestampTracker
+	rebaser    *flvTimestampRebaser
 
-	flvSrc *app.Source
-	writer *appSrcWriter
-	result chan error
+	// protected by the source lock
+	connected bool
+	body      io.Closer
 }
 
 func NewRTMPRelaySource(ctx context.Context, p *params.Params) (*RTMPRelaySource, error) {
@@ -32,10 +70,31 @@ func NewRTMPRelaySource(ctx context.Context, p *params.Params) (*RTMPRelaySource
 	defer span.End()
 
 	s := &RTMPRelaySource{
-		params: p,
+		params:      p,
+		gracePeriod: time.Duration(p.ReconnectGracePeriodMs) * time.Millisecond,
+		createdAt:   time.Now(),
+		fuse:        core.NewFuse(),
+	}
+
+	in, err := newRelayInput(FlvAppSource, p.RelayUrl, s.elapsed)
+	if err != nil {
+		return nil, err
+	}
+	s.inputs = append(s.inputs, in)
+
+	if p.BackupRelayUrl != "" {
+		in, err = newRelayInput(FlvBackupAppSource, p.BackupRelayUrl, s.elapsed)
+		if err != nil {
+			return nil, err
+		}
+		s.inputs = append(s.inputs, in)
 	}
 
-	elem, err := gst.NewElementWithName("appsrc", FlvAppSource)
+	return s, nil
+}
+
+func newRelayInput(name string, relayUrl string, elapsed func() int64) (*relayInput, error) {
+	elem, err := gst.NewElementWithName("appsrc", name)
 	if err != nil {
 		logger.Errorw("could not create appsrc", err)
 		return nil, err
@@ -48,10 +107,22 @@ func NewRTMPRelaySource(ctx context.Context, p *params.Params) (*RTMPRelaySource
 	}
 	elem.SetArg("format", "time")
 
-	s.flvSrc = app.SrcFromElement(elem)
-	s.writer = newAppSrcWriter(s.flvSrc)
+	flvSrc := app.SrcFromElement(elem)
+	writer := newAppSrcWriter(flvSrc)
 
-	return s, nil
+	return &relayInput{
+		name:       name,
+		relayUrl:   relayUrl,
+		flvSrc:     flvSrc,
+		writer:     writer,
+		timestamps: newFLVTimestampTracker(),
+		rebaser:    newFLVTimestampRebaser(writer, elapsed),
+	}, nil
+}
+
+// elapsed returns the time in ms since the source was created, that the timestamps of all the inputs are rebased on
+func (s *RTMPRelaySource) elapsed() int64 {
+	return time.Since(s.createdAt).Milliseconds()
 }
 
 func (s *RTMPRelaySource) Start(ctx context.Context) error {
@@ -60,52 +131,318 @@ func (s *RTMPRelaySource) Start(ctx context.Context) error {
 
 	s.result = make(chan error, 1)
 
-	resp, err := http.Get(s.params.RelayUrl)
-	switch {
-	case err != nil:
-		return err
-	case resp != nil && (resp.StatusCode < 200 || resp.StatusCode >= 400):
-		return errors.ErrHttpRelayFailure(resp.StatusCode)
+	// At least one of the publishers must already be connected
+	resps := make([]*http.Response, len(s.inputs))
+	var connectErr error
+	for i, in := range s.inputs {
+		resp, err := connectRelay(s.params.RelayClient, in.relayUrl)
+		if err != nil {
+			if connectErr == nil {
+				connectErr = err
+			}
+			continue
+		}
+		resps[i] = resp
 	}
 
-	go func() {
-		defer resp.Body.Close()
-
-		_, err := io.Copy(s.writer, resp.Body)
-		switch err {
-		case nil, io.EOF:
-			err = nil
-		default:
-			logger.Errorw("error while copying media from relay", err)
-		}
+	connected := false
+	for _, resp := range resps {
+		connected = connected || resp != nil
+	}
+	if !connected {
+		return connectErr
+	}
 
-		s.flvSrc.EndStream()
+	for i, in := range s.inputs {
+		go s.runInput(in, resps[i])
+	}
 
-		s.result <- err
-		close(s.result)
-	}()
+	if len(s.inputs) > 1 {
+		go s.watchStalls()
+	}
 
 	return nil
 }
 
 func (s *RTMPRelaySource) Close() error {
-	s.writer.Close()
+	s.fuse.Break()
+
+	s.lock.Lock()
+	for _, in := range s.inputs {
+		in.writer.Close()
+		if in.body != nil {
+			in.body.Close()
+		}
+	}
+	s.lock.Unlock()
+
 	return <-s.result
 }
 
 func (s *RTMPRelaySource) GetSources(ctx context.Context) []*app.Source {
-	return []*app.Source{s.flvSrc}
+	sources := make([]*app.Source, 0, len(s.inputs))
+	for _, in := range s.inputs {
+		sources = append(sources, in.flvSrc)
+	}
+
+	return sources
+}
+
+// OnSourceSelected registers a callback called every time the app source to forward changes
+func (s *RTMPRelaySource) OnSourceSelected(f func(src *app.Source)) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.onSelect = f
+}
+
+// OnReconnecting registers a callback called when all publishers are disconnected, and when one reconnects within the grace period
+func (s *RTMPRelaySource) OnReconnecting(f func(reconnecting bool)) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.onReconnecting = f
+}
+
+// TimestampGaps returns the number of discontinuities in the FLV tag timestamps of a kind, all publishers included
+func (s *RTMPRelaySource) TimestampGaps(kind types.StreamKind) uint64 {
+	var gaps uint64
+	for _, in := range s.inputs {
+		gaps += in.timestamps.gaps(kind)
+	}
+
+	return gaps
+}
+
+func (s *RTMPRelaySource) runInput(in *relayInput, resp *http.Response) {
+	for {
+		if resp == nil {
+			resp = s.reconnect(in)
+			if resp == nil {
+				// Source closed
+				if s.connectedCount() == 0 {
+					s.end(nil)
+				}
+				return
+			}
+		}
+
+		s.onConnected(in, resp.Body)
+
+		err := s.copyFromRelay(in, resp)
+		resp = nil
+
+		if s.onDisconnected(in) == 0 {
+			if s.gracePeriod <= 0 {
+				s.end(err)
+				return
+			}
+
+			s.startGracePeriod(err)
+		}
+	}
+}
+
+func (s *RTMPRelaySource) startGracePeriod(err error) {
+	s.lock.Lock()
+	if s.graceTimer != nil {
+		s.graceTimer.Stop()
+	}
+	s.graceTimer = time.AfterFunc(s.gracePeriod, func() {
+		if s.connectedCount() == 0 {
+			logger.Infow("no publisher reconnected within the grace period", "gracePeriod", s.gracePeriod)
+			s.end(err)
+		}
+	})
+	onReconnecting := s.onReconnecting
+	s.lock.Unlock()
+
+	if onReconnecting != nil {
+		onReconnecting(true)
+	}
+}
+
+func (s *RTMPRelaySource) reconnect(in *relayInput) *http.Response {
+	ticker := time.NewTicker(relayRetryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.fuse.Watch():
+			return nil
+		case <-ticker.C:
+			resp, err := connectRelay(s.params.RelayClient, in.relayUrl)
+			if err == nil {
+				return resp
+			}
+		}
+	}
+}
+
+func (s *RTMPRelaySource) copyFromRelay(in *relayInput, resp *http.Response) error {
+	defer resp.Body.Close()
+
+	// The gaps are counted on the timestamps of the publisher, before they are rebased
+	in.timestamps.reset()
+	in.rebaser.startSession()
+
+	_, err := io.Copy(in.rebaser, io.TeeReader(resp.Body, in.timestamps))
+	switch err {
+	case nil, io.EOF:
+		err = nil
+	default:
+		logger.Errorw("error while copying media from relay", err, "input", in.name)
+	}
+
+	return err
+}
+
+func (s *RTMPRelaySource) onConnected(in *relayInput, body io.Closer) {
+	logger.Infow("relay input connected", "input", in.name)
+
+	s.lock.Lock()
+	in.connected = true
+	in.body = body
+	in.writer.touch()
+
+	var selected *relayInput
+	if s.active == nil || !s.active.connected {
+		selected = in
+		s.active = in
+	}
+	onSelect := s.onSelect
+
+	reconnected := false
+	if s.graceTimer != nil {
+		reconnected = s.graceTimer.Stop()
+		s.graceTimer = nil
+	}
+	onReconnecting := s.onReconnecting
+	s.lock.Unlock()
+
+	if selected != nil && onSelect != nil {
+		onSelect(selected.flvSrc)
+	}
+	if reconnected && onReconnecting != nil {
+		onReconnecting(false)
+	}
+}
+
+func (s *RTMPRelaySource) onDisconnected(in *relayInput) int {
+	logger.Infow("relay input disconnected", "input", in.name)
+
+	s.lock.Lock()
+	in.connected = false
+	in.body = nil
+
+	var selected *relayInput
+	if s.active == in {
+		for _, other := range s.inputs {
+			if other.connected {
+				selected = other
+				s.active = other
+				break
+			}
+		}
+	}
+	count := s.connectedCountLocked()
+	onSelect := s.onSelect
+	s.lock.Unlock()
+
+	if selected != nil && onSelect != nil {
+		logger.Infow("switching to relay input", "input", selected.name, "reason", "disconnected")
+		onSelect(selected.flvSrc)
+	}
+
+	return count
+}
+
+func (s *RTMPRelaySource) watchStalls() {
+	ticker := time.NewTicker(stallCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.fuse.Watch():
+			return
+		case <-ticker.C:
+			s.lock.Lock()
+			var selected *relayInput
+			if s.active != nil && s.active.writer.idleTime() > stallTimeout {
+				for _, other := range s.inputs {
+					if other != s.active && other.connected && other.writer.idleTime() < stallTimeout {
+						selected = other
+						s.active = other
+						break
+					}
+				}
+			}
+			onSelect := s.onSelect
+			s.lock.Unlock()
+
+			if selected != nil && onSelect != nil {
+				logger.Infow("switching to relay input", "input", selected.name, "reason", "stalled")
+				onSelect(selected.flvSrc)
+			}
+		}
+	}
+}
+
+func (s *RTMPRelaySource) connectedCount() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.connectedCountLocked()
+}
+
+func (s *RTMPRelaySource) connectedCountLocked() int {
+	count := 0
+	for _, in := range s.inputs {
+		if in.connected {
+			count++
+		}
+	}
+
+	return count
+}
+
+func (s *RTMPRelaySource) end(err error) {
+	s.endOnce.Do(func() {
+		s.fuse.Break()
+
+		for _, in := range s.inputs {
+			in.flvSrc.EndStream()
+		}
+
+		s.result <- err
+		close(s.result)
+	})
+}
+
+func connectRelay(client *http.Client, relayUrl string) (*http.Response, error) {
+	resp, err := client.Get(relayUrl)
+	switch {
+	case err != nil:
+		return nil, err
+	case resp != nil && (resp.StatusCode < 200 || resp.StatusCode >= 400):
+		resp.Body.Close()
+		return nil, errors.ErrHttpRelayFailure(resp.StatusCode)
+	}
+
+	return resp, nil
 }
 
 type appSrcWriter struct {
-	appSrc *app.Source
-	eos    *atomic.Bool
+	appSrc    *app.Source
+	eos       *atomic.Bool
+	lastWrite *atomic.Int64
 }
 
 func newAppSrcWriter(flvSrc *app.Source) *appSrcWriter {
 	return &appSrcWriter{
-		appSrc: flvSrc,
-		eos:    atomic.NewBool(false),
+		appSrc:    flvSrc,
+		eos:       atomic.NewBool(false),
+		lastWrite: atomic.NewInt64(0),
 	}
 }
 
@@ -114,6 +451,8 @@ func (w *appSrcWriter) Write(p []byte) (int, error) {
 		return 0, io.EOF
 	}
 
+	w.touch()
+
 	b := gst.NewBufferFromBytes(p)
 
 	ret := w.appSrc.PushBuffer(b)
@@ -129,6 +468,14 @@ func (w *appSrcWriter) Write(p []byte) (int, error) {
 	return len(p), nil
 }
 
+func (w *appSrcWriter) touch() {
+	w.lastWrite.Store(time.Now().UnixNano())
+}
+
+func (w *appSrcWriter) idleTime() time.Duration {
+	return time.Since(time.Unix(0, w.lastWrite.Load()))
+}
+
 func (w *appSrcWriter) Close() error {
 	w.eos.Store(true)
 
@@ -0,0 +1,110 @@
+package rtmp
+
+import (
+	"io"
+)
+
+// flvTimestampRebaser forwards the FLV streams of the successive sessions of a publisher as a single stream, for a
+// demuxer that only expects one. The file header of the first session is the only one forwarded, and the tag
+// timestamps of each session are shifted so that they start at the time elapsed on the timeline shared by all the
+// inputs of the ingress, and never go backward. Switching between inputs, or to the session of a reconnected publisher,
+// then keeps the timestamps continuous. Only complete tags are forwarded, a tag cut by a disconnection is dropped.
+type flvTimestampRebaser struct {
+	w       io.Writer
+	elapsed func() int64 // ms on the shared timeline
+
+	headerForwarded bool
+	inFileHeader    bool
+	based           bool
+	offset          int64
+	last            int64
+
+	buf []byte
+	out []byte
+}
+
+func newFLVTimestampRebaser(w io.Writer, elapsed func() int64) *flvTimestampRebaser {
+	r := &flvTimestampRebaser{
+		w:       w,
+		elapsed: elapsed,
+		last:    -1,
+	}
+	r.startSession()
+
+	return r
+}
+
+// startSession drops what is left of the previous session, and expects a new FLV stream starting with its file header
+func (r *flvTimestampRebaser) startSession() {
+	r.inFileHeader = true
+	r.based = false
+	r.buf = r.buf[:0]
+}
+
+func (r *flvTimestampRebaser) Write(p []byte) (int, error) {
+	r.buf = append(r.buf, p...)
+	r.out = r.out[:0]
+
+	pos := 0
+	for {
+		data := r.buf[pos:]
+
+		if r.inFileHeader {
+			if len(data) < flvHeaderSize {
+				break
+			}
+			if !r.headerForwarded {
+				r.out = append(r.out, data[:flvHeaderSize]...)
+				r.headerForwarded = true
+			}
+			r.inFileHeader = false
+			pos += flvHeaderSize
+			continue
+		}
+
+		if len(data) < flvTagHeaderSize {
+			break
+		}
+		size := flvTagHeaderSize + getFLVDataSize(data) + flvPrevTagSizeSize
+		if len(data) < size {
+			break
+		}
+
+		tag := data[:size]
+		r.rebase(tag)
+		r.out = append(r.out, tag...)
+		pos += size
+	}
+	r.buf = append(r.buf[:0], r.buf[pos:]...)
+
+	if len(r.out) > 0 {
+		if _, err := r.w.Write(r.out); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(p), nil
+}
+
+func (r *flvTimestampRebaser) rebase(tag []byte) {
+	ts := getFLVTimestamp(tag)
+
+	if !r.based {
+		start := r.elapsed()
+		if start <= r.last {
+			start = r.last + 1
+		}
+		r.offset = start - ts
+		r.based = true
+	}
+
+	rebased := ts + r.offset
+	if rebased < 0 {
+		rebased = 0
+	}
+	setFLVTimestamp(tag, rebased)
+
+	if rebased > r.last {
+		r.last = rebased
+	}
+}
@@ -0,0 +1,106 @@
+package rtmp
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+var testFLVHeader = []byte{'F', 'L', 'V', 0x01, 0x05, 0x00, 0x00, 0x00, 0x09, 0x00, 0x00, 0x00, 0x00}
+
+// parseFLVTimestamps returns the timestamps of the tags of a stream, after checking its single file header
+func parseFLVTimestamps(t *testing.T, b []byte) []int64 {
+	require.True(t, bytes.HasPrefix(b, testFLVHeader))
+	b = b[flvHeaderSize:]
+
+	var ts []int64
+	for len(b) > 0 {
+		require.GreaterOrEqual(t, len(b), flvTagHeaderSize)
+		size := flvTagHeaderSize + getFLVDataSize(b) + flvPrevTagSizeSize
+		require.GreaterOrEqual(t, len(b), size)
+		ts = append(ts, getFLVTimestamp(b))
+		b = b[size:]
+	}
+
+	return ts
+}
+
+func writeInChunks(t *testing.T, r *flvTimestampRebaser, b []byte) {
+	for i := 0; i < len(b); i += 5 {
+		end := i + 5
+		if end > len(b) {
+			end = len(b)
+		}
+		n, err := r.Write(b[i:end])
+		require.NoError(t, err)
+		require.Equal(t, end-i, n)
+	}
+}
+
+func TestFLVTimestampRebaserReconnect(t *testing.T) {
+	var elapsed int64
+	out := &bytes.Buffer{}
+	r := newFLVTimestampRebaser(out, func() int64 { return elapsed })
+
+	// First session, ended in the middle of a tag
+	session := append([]byte{}, testFLVHeader...)
+	for _, tag := range [][]byte{
+		flvTag(18, 0, 20),
+		flvTag(flvTagTypeVideo, 0, 10),
+		flvTag(flvTagTypeAudio, 0, 4),
+		flvTag(flvTagTypeVideo, 33, 10),
+		flvTag(flvTagTypeVideo, 66, 10),
+	} {
+		session = append(session, tag...)
+	}
+	session = append(session, flvTag(flvTagTypeVideo, 100, 10)[:15]...)
+	writeInChunks(t, r, session)
+	require.Equal(t, []int64{0, 0, 0, 33, 66}, parseFLVTimestamps(t, out.Bytes()))
+
+	// The reconnected publisher starts over at 0 with its init tags. Its timestamps continue after the last forwarded
+	// one, even though less time elapsed on the shared timeline
+	elapsed = 40
+	r.startSession()
+	session = append([]byte{}, testFLVHeader...)
+	for _, tag := range [][]byte{
+		flvTag(18, 0, 20),
+		flvTag(flvTagTypeVideo, 0, 10),
+		flvTag(flvTagTypeVideo, 33, 10),
+	} {
+		session = append(session, tag...)
+	}
+	writeInChunks(t, r, session)
+	require.Equal(t, []int64{0, 0, 0, 33, 66, 67, 67, 100}, parseFLVTimestamps(t, out.Bytes()))
+
+	// Reconnected later, the timestamps resume at the elapsed time
+	elapsed = 5000
+	r.startSession()
+	writeInChunks(t, r, append(append([]byte{}, testFLVHeader...), flvTag(flvTagTypeVideo, 0, 10)...))
+	require.Equal(t, []int64{0, 0, 0, 33, 66, 67, 67, 100, 5000}, parseFLVTimestamps(t, out.Bytes()))
+}
+
+func TestFLVTimestampRebaserSwitch(t *testing.T) {
+	var elapsed int64
+	primaryOut := &bytes.Buffer{}
+	backupOut := &bytes.Buffer{}
+	primary := newFLVTimestampRebaser(primaryOut, func() int64 { return elapsed })
+	backup := newFLVTimestampRebaser(backupOut, func() int64 { return elapsed })
+
+	writeInChunks(t, primary, append(append([]byte{}, testFLVHeader...), flvTag(flvTagTypeVideo, 0, 10)...))
+
+	// The backup publisher connects later, with its own timestamps. They are rebased on the same timeline, so that
+	// switching inputs keeps the timestamps continuous
+	elapsed = 3000
+	writeInChunks(t, primary, flvTag(flvTagTypeVideo, 3000, 10))
+	writeInChunks(t, backup, append(append([]byte{}, testFLVHeader...), flvTag(flvTagTypeVideo, 120000, 10)...))
+	writeInChunks(t, backup, flvTag(flvTagTypeVideo, 120033, 10))
+
+	require.Equal(t, []int64{0, 3000}, parseFLVTimestamps(t, primaryOut.Bytes()))
+	require.Equal(t, []int64{3000, 3033}, parseFLVTimestamps(t, backupOut.Bytes()))
+
+	// Extended timestamps are rewritten as well
+	tag := flvTag(flvTagTypeVideo, 0, 10)
+	setFLVTimestamp(tag, 0x01000021)
+	require.Equal(t, int64(0x01000021), getFLVTimestamp(tag))
+}
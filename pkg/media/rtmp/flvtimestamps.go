@@ -0,0 +1,122 @@
+package rtmp
+
+import (
+	"encoding/binary"
+
+	"go.uber.org/atomic"
+
+	"github.com/livekit/ingress/pkg/types"
+)
+
+const (
+	flvTagHeaderSize    = 11
+	flvPrevTagSizeSize  = 4
+	flvTagTypeAudio     = 8
+	flvTagTypeVideo     = 9
+	flvTagTypeMask      = 0x1f
+	flvMaxTimestampJump = 1000 // ms
+)
+
+// flvTimestampTracker parses the FLV stream written to it and counts the discontinuities in the timestamps of
+// the audio and video tags: timestamps going backward, or jumping forward by more than flvMaxTimestampJump.
+type flvTimestampTracker struct {
+	audioGaps atomic.Uint64
+	videoGaps atomic.Uint64
+
+	// parsing state, only accessed by the writer
+	skip      int
+	header    []byte
+	lastAudio int64
+	lastVideo int64
+}
+
+func newFLVTimestampTracker() *flvTimestampTracker {
+	t := &flvTimestampTracker{}
+	t.reset()
+
+	return t
+}
+
+// reset starts parsing a new FLV stream, beginning with its file header. Timestamps are not compared across streams.
+func (t *flvTimestampTracker) reset() {
+	t.skip = flvHeaderSize
+	t.header = t.header[:0]
+	t.lastAudio = -1
+	t.lastVideo = -1
+}
+
+func (t *flvTimestampTracker) Write(p []byte) (int, error) {
+	n := len(p)
+
+	for len(p) > 0 {
+		if t.skip > 0 {
+			if len(p) < t.skip {
+				t.skip -= len(p)
+				break
+			}
+			p = p[t.skip:]
+			t.skip = 0
+			continue
+		}
+
+		missing := flvTagHeaderSize - len(t.header)
+		if len(p) < missing {
+			t.header = append(t.header, p...)
+			break
+		}
+		t.header = append(t.header, p[:missing]...)
+		p = p[missing:]
+
+		t.onTagHeader(t.header)
+		t.header = t.header[:0]
+	}
+
+	return n, nil
+}
+
+func (t *flvTimestampTracker) onTagHeader(h []byte) {
+	ts := getFLVTimestamp(h)
+
+	t.skip = getFLVDataSize(h) + flvPrevTagSizeSize
+
+	switch h[0] & flvTagTypeMask {
+	case flvTagTypeAudio:
+		if isTimestampGap(t.lastAudio, ts) {
+			t.audioGaps.Inc()
+		}
+		t.lastAudio = ts
+	case flvTagTypeVideo:
+		if isTimestampGap(t.lastVideo, ts) {
+			t.videoGaps.Inc()
+		}
+		t.lastVideo = ts
+	}
+}
+
+func (t *flvTimestampTracker) gaps(kind types.StreamKind) uint64 {
+	switch kind {
+	case types.Audio:
+		return t.audioGaps.Load()
+	case types.Video:
+		return t.videoGaps.Load()
+	default:
+		return 0
+	}
+}
+
+func isTimestampGap(last, ts int64) bool {
+	return last >= 0 && (ts < last || ts-last > flvMaxTimestampJump)
+}
+
+func getFLVDataSize(h []byte) int {
+	return int(h[1])<<16 | int(h[2])<<8 | int(h[3])
+}
+
+func getFLVTimestamp(h []byte) int64 {
+	// The extended byte holds the upper 8 bits of the timestamp
+	return int64(binary.BigEndian.Uint32([]byte{h[7], h[4], h[5], h[6]}))
+}
+
+func setFLVTimestamp(h []byte, ts int64) {
+	h[4], h[5], h[6], h[7] = byte(ts>>16), byte(ts>>8), byte(ts), byte(ts>>24)
+}
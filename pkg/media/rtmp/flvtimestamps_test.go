@@ -0,0 +1,71 @@
+package rtmp
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/ingress/pkg/types"
+)
+
+func TestFLVTimestampTracker(t *testing.T) {
+	stream := func(tags ...[]byte) []byte {
+		b := &bytes.Buffer{}
+		b.Write([]byte{'F', 'L', 'V', 0x01, 0x05, 0x00, 0x00, 0x00, 0x09, 0x00, 0x00, 0x00, 0x00})
+		for _, tag := range tags {
+			b.Write(tag)
+		}
+		return b.Bytes()
+	}
+
+	tr := newFLVTimestampTracker()
+	s := stream(
+		flvTag(flvTagTypeVideo, 0, 10),
+		flvTag(flvTagTypeAudio, 0, 4),
+		flvTag(flvTagTypeVideo, 33, 10),
+		flvTag(flvTagTypeAudio, 23, 4),
+		// video jumps forward
+		flvTag(flvTagTypeVideo, 2033, 10),
+		// audio goes backward
+		flvTag(flvTagTypeAudio, 10, 4),
+		// script data is ignored
+		flvTag(18, 0, 20),
+		// extended timestamp, jumps forward
+		flvTag(flvTagTypeVideo, 0x01000000, 10),
+		flvTag(flvTagTypeVideo, 0x01000021, 10),
+	)
+
+	// Written in small chunks, splitting the tag headers
+	for i := 0; i < len(s); i += 7 {
+		end := i + 7
+		if end > len(s) {
+			end = len(s)
+		}
+		n, err := tr.Write(s[i:end])
+		require.NoError(t, err)
+		require.Equal(t, end-i, n)
+	}
+
+	require.Equal(t, uint64(1), tr.gaps(types.Audio))
+	require.Equal(t, uint64(2), tr.gaps(types.Video))
+
+	// Timestamps restart with a new stream
+	tr.reset()
+	_, err := tr.Write(stream(flvTag(flvTagTypeVideo, 0, 10), flvTag(flvTagTypeVideo, 33, 10)))
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), tr.gaps(types.Video))
+}
+
+func flvTag(tagType byte, ts uint32, dataSize int) []byte {
+	tag := []byte{
+		tagType,
+		byte(dataSize >> 16), byte(dataSize >> 8), byte(dataSize),
+		byte(ts >> 16), byte(ts >> 8), byte(ts), byte(ts >> 24),
+		0x00, 0x00, 0x00,
+	}
+	tag = append(tag, make([]byte, dataSize)...)
+	size := len(tag)
+
+	return append(tag, byte(size>>24), byte(size>>16), byte(size>>8), byte(size))
+}
@@ -0,0 +1,359 @@
+package media
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/frostbyte73/core"
+	"github.com/tinyzimmer/go-gst/gst"
+
+	"github.com/livekit/ingress/pkg/config"
+	"github.com/livekit/ingress/pkg/errors"
+	"github.com/livekit/ingress/pkg/types"
+	"github.com/livekit/protocol/livekit"
+	"github.com/livekit/protocol/logger"
+)
+
+// Slate publishes a placeholder image or silence in place of the input media of a track when the input of the track stops
+// producing buffers. Each track goes through an input-selector, switching between the input and a live slate source, so
+// that the published tracks stay continuous. The slate sources are paused while not selected.
+type Slate struct {
+	conf         *config.SlateConfig
+	videoOptions *livekit.IngressVideoEncodingOptions
+	timeout      time.Duration
+
+	lock      sync.Mutex
+	selectors map[types.StreamKind]*gst.Element
+	inputPads map[types.StreamKind]*gst.Pad
+	slatePads map[types.StreamKind]*gst.Pad
+	slateBins map[types.StreamKind]*gst.Bin
+	switches  *slateSwitch
+	watching  core.Fuse
+	closed    core.Fuse
+}
+
+func NewSlate(conf *config.SlateConfig, videoOptions *livekit.IngressVideoEncodingOptions) *Slate {
+	timeout := time.Duration(conf.TimeoutMs) * time.Millisecond
+
+	return &Slate{
+		conf:         conf,
+		videoOptions: videoOptions,
+		timeout:      timeout,
+		selectors:    make(map[types.StreamKind]*gst.Element),
+		inputPads:    make(map[types.StreamKind]*gst.Pad),
+		slatePads:    make(map[types.StreamKind]*gst.Pad),
+		slateBins:    make(map[types.StreamKind]*gst.Bin),
+		switches:     newSlateSwitch(timeout),
+		watching:     core.NewFuse(),
+		closed:       core.NewFuse(),
+	}
+}
+
+// AddTrack inserts the slate selector of the given kind in front of the output sink pad.
+// It returns the pad the input media must be linked to.
+func (s *Slate) AddTrack(pipeline *gst.Pipeline, kind types.StreamKind, outputPad *gst.Pad) (*gst.Pad, error) {
+	selector, err := gst.NewElementWithName("input-selector", fmt.Sprintf("%s_slate_selector", kind))
+	if err != nil {
+		return nil, err
+	}
+	// The inactive branch is dropped, not synchronized to the active one
+	if err = selector.SetProperty("sync-streams", false); err != nil {
+		return nil, err
+	}
+
+	var slateBin *gst.Bin
+	switch kind {
+	case types.Audio:
+		slateBin, err = newAudioSlateBin()
+	case types.Video:
+		slateBin, err = s.newVideoSlateBin()
+	default:
+		return nil, errors.ErrUnsupportedDecodeFormat
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err = pipeline.AddMany(selector, slateBin.Element); err != nil {
+		return nil, err
+	}
+
+	if linkReturn := selector.GetStaticPad("src").Link(outputPad); linkReturn != gst.PadLinkOK {
+		return nil, fmt.Errorf("failed to link slate selector: %v", linkReturn)
+	}
+
+	inputPad := selector.GetRequestPad("sink_%u")
+	slatePad := selector.GetRequestPad("sink_%u")
+	if linkReturn := slateBin.GetStaticPad("src").Link(slatePad); linkReturn != gst.PadLinkOK {
+		return nil, fmt.Errorf("failed to link slate source: %v", linkReturn)
+	}
+
+	if err = selector.SetProperty("active-pad", inputPad); err != nil {
+		return nil, err
+	}
+
+	inputPad.AddProbe(gst.PadProbeTypeBuffer, func(_ *gst.Pad, info *gst.PadProbeInfo) gst.PadProbeReturn {
+		s.onInputBuffer(kind, info.GetBuffer())
+		return gst.PadProbeOK
+	})
+
+	s.lock.Lock()
+	s.selectors[kind] = selector
+	s.inputPads[kind] = inputPad
+	s.slatePads[kind] = slatePad
+	s.slateBins[kind] = slateBin
+	s.switches.addTrack(kind)
+	s.lock.Unlock()
+
+	selector.SyncStateWithParent()
+	// The slate source only runs once selected. Live sources do not preroll, the pipeline reaches the playing state without it
+	if err = slateBin.SetState(gst.StatePaused); err != nil {
+		return nil, err
+	}
+
+	return inputPad, nil
+}
+
+func (s *Slate) Close() {
+	s.closed.Break()
+}
+
+func (s *Slate) onInputBuffer(kind types.StreamKind, buffer *gst.Buffer) {
+	s.watching.Once(func() {
+		go s.watch()
+	})
+
+	keyFrame := buffer != nil && !buffer.HasFlags(gst.BufferFlagDeltaUnit)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.switches.onBuffer(kind, keyFrame, time.Now()) {
+		logger.Infow("input media resumed, switching back from slate", "kind", kind)
+		s.setActiveLocked(kind, false)
+	}
+}
+
+func (s *Slate) watch() {
+	ticker := time.NewTicker(s.timeout / 4)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.closed.Watch():
+			return
+		case <-ticker.C:
+			s.lock.Lock()
+			for _, kind := range s.switches.stalled(time.Now()) {
+				logger.Infow("no input media, switching to slate", "kind", kind, "timeout", s.timeout)
+				s.setActiveLocked(kind, true)
+			}
+			s.lock.Unlock()
+		}
+	}
+}
+
+// setActiveLocked selects the slate or the input of a track. The slate source is started before being selected, and
+// paused once the input is selected again
+func (s *Slate) setActiveLocked(kind types.StreamKind, active bool) {
+	pad := s.inputPads[kind]
+	if active {
+		if err := s.slateBins[kind].SetState(gst.StatePlaying); err != nil {
+			logger.Errorw("failed to start slate", err, "kind", kind)
+		}
+		pad = s.slatePads[kind]
+	}
+
+	if err := s.selectors[kind].SetProperty("active-pad", pad); err != nil {
+		logger.Errorw("failed to switch slate selector", err, "kind", kind)
+	}
+
+	if !active {
+		if err := s.slateBins[kind].SetState(gst.StatePaused); err != nil {
+			logger.Errorw("failed to pause slate", err, "kind", kind)
+		}
+	}
+}
+
+// slateSwitch decides when each track switches to its slate and back, from the buffers of the input of the track
+type slateSwitch struct {
+	timeout    time.Duration
+	startedAt  time.Time // first input buffer, of any track
+	lastBuffer map[types.StreamKind]time.Time
+	active     map[types.StreamKind]bool
+}
+
+func newSlateSwitch(timeout time.Duration) *slateSwitch {
+	return &slateSwitch{
+		timeout:    timeout,
+		lastBuffer: make(map[types.StreamKind]time.Time),
+		active:     make(map[types.StreamKind]bool),
+	}
+}
+
+func (s *slateSwitch) addTrack(kind types.StreamKind) {
+	s.lastBuffer[kind] = time.Time{}
+	s.active[kind] = false
+}
+
+// onBuffer records a buffer of the input of a track. Returns true if the track must switch back from its slate, which
+// for video waits for a key frame
+func (s *slateSwitch) onBuffer(kind types.StreamKind, keyFrame bool, now time.Time) bool {
+	if s.startedAt.IsZero() {
+		s.startedAt = now
+	}
+	s.lastBuffer[kind] = now
+
+	if !s.active[kind] || (kind == types.Video && !keyFrame) {
+		return false
+	}
+
+	s.active[kind] = false
+	return true
+}
+
+// stalled returns the tracks whose input has not produced a buffer within the timeout, and which must switch to their
+// slate. A track that never received a buffer is timed from the first buffer of the other tracks
+func (s *slateSwitch) stalled(now time.Time) []types.StreamKind {
+	if s.startedAt.IsZero() {
+		return nil
+	}
+
+	var kinds []types.StreamKind
+	for kind, last := range s.lastBuffer {
+		if last.Before(s.startedAt) {
+			last = s.startedAt
+		}
+		if s.active[kind] || now.Sub(last) < s.timeout {
+			continue
+		}
+
+		s.active[kind] = true
+		kinds = append(kinds, kind)
+	}
+
+	return kinds
+}
+
+func (s *Slate) newVideoSlateBin() (*gst.Bin, error) {
+	var elements []*gst.Element
+
+	if s.conf.Image != "" {
+		fileSrc, err := gst.NewElement("filesrc")
+		if err != nil {
+			return nil, err
+		}
+		if err = fileSrc.SetProperty("location", s.conf.Image); err != nil {
+			return nil, err
+		}
+
+		pngDec, err := gst.NewElement("pngdec")
+		if err != nil {
+			return nil, err
+		}
+
+		imageFreeze, err := gst.NewElement("imagefreeze")
+		if err != nil {
+			return nil, err
+		}
+		if err = imageFreeze.SetProperty("is-live", true); err != nil {
+			return nil, err
+		}
+
+		elements = append(elements, fileSrc, pngDec, imageFreeze)
+	} else {
+		testSrc, err := gst.NewElement("videotestsrc")
+		if err != nil {
+			return nil, err
+		}
+		if err = testSrc.SetProperty("is-live", true); err != nil {
+			return nil, err
+		}
+		testSrc.SetArg("pattern", "black")
+
+		textOverlay, err := gst.NewElement("textoverlay")
+		if err != nil {
+			return nil, err
+		}
+		if err = textOverlay.SetProperty("text", s.conf.Text); err != nil {
+			return nil, err
+		}
+		if err = textOverlay.SetProperty("font-desc", "Sans, 24"); err != nil {
+			return nil, err
+		}
+		textOverlay.SetArg("valignment", "center")
+		textOverlay.SetArg("halignment", "center")
+
+		elements = append(elements, testSrc, textOverlay)
+	}
+
+	videoConvert, err := gst.NewElement("videoconvert")
+	if err != nil {
+		return nil, err
+	}
+	videoScale, err := gst.NewElement("videoscale")
+	if err != nil {
+		return nil, err
+	}
+	videoRate, err := gst.NewElement("videorate")
+	if err != nil {
+		return nil, err
+	}
+
+	caps := "video/x-raw"
+	if len(s.videoOptions.Layers) > 0 {
+		// Layers are sorted from highest to lowest quality
+		caps = fmt.Sprintf("%s,width=%d,height=%d", caps, s.videoOptions.Layers[0].Width, s.videoOptions.Layers[0].Height)
+	}
+	if s.videoOptions.FrameRate > 0 {
+		caps = fmt.Sprintf("%s,framerate=%d/1", caps, int(s.videoOptions.FrameRate))
+	}
+	capsFilter, err := gst.NewElement("capsfilter")
+	if err != nil {
+		return nil, err
+	}
+	if err = capsFilter.SetProperty("caps", gst.NewCapsFromString(caps)); err != nil {
+		return nil, err
+	}
+
+	elements = append(elements, videoConvert, videoScale, videoRate, capsFilter)
+
+	return newSlateBin("video_slate", elements)
+}
+
+func newAudioSlateBin() (*gst.Bin, error) {
+	testSrc, err := gst.NewElement("audiotestsrc")
+	if err != nil {
+		return nil, err
+	}
+	if err = testSrc.SetProperty("is-live", true); err != nil {
+		return nil, err
+	}
+	testSrc.SetArg("wave", "silence")
+
+	audioConvert, err := gst.NewElement("audioconvert")
+	if err != nil {
+		return nil, err
+	}
+
+	return newSlateBin("audio_slate", []*gst.Element{testSrc, audioConvert})
+}
+
+func newSlateBin(name string, elements []*gst.Element) (*gst.Bin, error) {
+	bin := gst.NewBin(name)
+
+	if err := bin.AddMany(elements...); err != nil {
+		return nil, err
+	}
+	if err := gst.ElementLinkMany(elements...); err != nil {
+		return nil, err
+	}
+
+	binSrc := gst.NewGhostPad("src", elements[len(elements)-1].GetStaticPad("src"))
+	if !bin.AddPad(binSrc.Pad) {
+		return nil, errors.ErrUnableToAddPad
+	}
+
+	return bin, nil
+}
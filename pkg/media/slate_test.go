@@ -0,0 +1,67 @@
+package media
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/ingress/pkg/types"
+)
+
+func TestSlateSwitchStall(t *testing.T) {
+	s := newSlateSwitch(2 * time.Second)
+	s.addTrack(types.Audio)
+	s.addTrack(types.Video)
+
+	start := time.Now()
+	require.Empty(t, s.stalled(start.Add(time.Minute)))
+
+	// The video stalls while the audio keeps flowing, only the video switches to its slate
+	require.False(t, s.onBuffer(types.Video, true, start))
+	for i := 0; i <= 6; i++ {
+		require.False(t, s.onBuffer(types.Audio, true, start.Add(time.Duration(i)*500*time.Millisecond)))
+	}
+	require.Empty(t, s.stalled(start.Add(1900*time.Millisecond)))
+	require.Equal(t, []types.StreamKind{types.Video}, s.stalled(start.Add(2*time.Second)))
+	require.Empty(t, s.stalled(start.Add(3*time.Second)))
+
+	// The audio stalls in turn
+	require.Equal(t, []types.StreamKind{types.Audio}, s.stalled(start.Add(5*time.Second)))
+	require.Empty(t, s.stalled(start.Add(6*time.Second)))
+}
+
+func TestSlateSwitchNoBuffer(t *testing.T) {
+	s := newSlateSwitch(2 * time.Second)
+	s.addTrack(types.Audio)
+	s.addTrack(types.Video)
+
+	// A track without any buffer is timed from the first buffer of the input
+	start := time.Now()
+	require.False(t, s.onBuffer(types.Video, true, start))
+	require.False(t, s.onBuffer(types.Video, false, start.Add(time.Second)))
+	require.Empty(t, s.stalled(start.Add(1900*time.Millisecond)))
+	require.Equal(t, []types.StreamKind{types.Audio}, s.stalled(start.Add(2*time.Second)))
+}
+
+func TestSlateSwitchBack(t *testing.T) {
+	s := newSlateSwitch(2 * time.Second)
+	s.addTrack(types.Audio)
+	s.addTrack(types.Video)
+
+	start := time.Now()
+	require.False(t, s.onBuffer(types.Audio, true, start))
+	require.False(t, s.onBuffer(types.Video, true, start))
+	require.ElementsMatch(t, []types.StreamKind{types.Audio, types.Video}, s.stalled(start.Add(3*time.Second)))
+
+	// The video switches back on the next key frame, the audio on its next buffer
+	require.False(t, s.onBuffer(types.Video, false, start.Add(4*time.Second)))
+	require.True(t, s.onBuffer(types.Audio, false, start.Add(4*time.Second)))
+	require.False(t, s.onBuffer(types.Audio, false, start.Add(4500*time.Millisecond)))
+	require.False(t, s.onBuffer(types.Video, false, start.Add(4500*time.Millisecond)))
+	require.True(t, s.onBuffer(types.Video, true, start.Add(5*time.Second)))
+	require.False(t, s.onBuffer(types.Video, true, start.Add(5500*time.Millisecond)))
+
+	// Both tracks are back on their input
+	require.Empty(t, s.stalled(start.Add(6*time.Second)))
+}
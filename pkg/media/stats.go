@@ -0,0 +1,180 @@
+package media
+
+import (
+	"encoding/binary"
+	"sync"
+	"time"
+
+	"github.com/tinyzimmer/go-gst/gst"
+	"go.uber.org/atomic"
+
+	"github.com/livekit/ingress/pkg/types"
+)
+
+const (
+	// pending buffers are forgotten beyond this count, for encoders not preserving all the timestamps
+	maxPendingLatencyBuffers = 300
+	latencySmoothing         = 0.1
+)
+
+// InputTrackStats are the counters of the media of a kind received so far, before decoding
+type InputTrackStats struct {
+	Kind   types.StreamKind
+	Bytes  uint64
+	Frames uint64
+	// current decoded resolution, only for video
+	Width  uint32
+	Height uint32
+	// time between the last two key frames
+	KeyFrameInterval time.Duration
+	// only for sources able to detect them
+	TimestampGaps uint64
+}
+
+// OutputTrackStats are the counters of the media sent so far for a track, or a simulcast layer of the video track
+type OutputTrackStats struct {
+	Kind           types.StreamKind
+	Layer          string
+	Bytes          uint64
+	SamplesDelayed uint64
+	// moving average, only when transcoding
+	EncoderLatency time.Duration
+	// energy of the audio encoded so far, normalized samples squared, only for audio
+	AudioSumSquares float64
+	AudioSamples    uint64
+}
+
+// TimestampGapsSource is implemented by sources detecting discontinuities in the timestamps of the media they receive
+type TimestampGapsSource interface {
+	TimestampGaps(kind types.StreamKind) uint64
+}
+
+// parsedStats counts the media output by the parsers of a kind
+type parsedStats struct {
+	bytes            atomic.Uint64
+	keyFrameInterval atomic.Duration
+}
+
+// decodedStats counts the frames of a kind surfaced by the input, from the selected app source for failover sources
+type decodedStats struct {
+	pad    *gst.Pad
+	frames atomic.Uint64
+}
+
+func (s *parsedStats) newProbe() gst.PadProbeCallback {
+	// Only accessed from the streaming thread of the probed pad
+	lastKeyFrame := gst.ClockTimeNone
+
+	return func(_ *gst.Pad, info *gst.PadProbeInfo) gst.PadProbeReturn {
+		buffer := info.GetBuffer()
+		if buffer == nil {
+			return gst.PadProbeOK
+		}
+
+		s.bytes.Add(uint64(buffer.GetSize()))
+
+		if !buffer.HasFlags(gst.BufferFlagDeltaUnit) && !buffer.HasFlags(gst.BufferFlagHeader) {
+			if pts := buffer.PresentationTimestamp(); pts >= 0 {
+				if lastKeyFrame >= 0 && pts > lastKeyFrame {
+					s.keyFrameInterval.Store(pts - lastKeyFrame)
+				}
+				lastKeyFrame = pts
+			}
+		}
+
+		return gst.PadProbeOK
+	}
+}
+
+// latencyTracker measures the time buffers spend in an element, matching them by presentation timestamp
+type latencyTracker struct {
+	lock    sync.Mutex
+	pending map[time.Duration]time.Time
+	latency time.Duration
+}
+
+func newLatencyTracker() *latencyTracker {
+	return &latencyTracker{
+		pending: make(map[time.Duration]time.Time),
+	}
+}
+
+func (t *latencyTracker) track(e *gst.Element) {
+	e.GetStaticPad("sink").AddProbe(gst.PadProbeTypeBuffer, func(_ *gst.Pad, info *gst.PadProbeInfo) gst.PadProbeReturn {
+		if buffer := info.GetBuffer(); buffer != nil {
+			t.onInput(buffer.PresentationTimestamp(), time.Now())
+		}
+		return gst.PadProbeOK
+	})
+	e.GetStaticPad("src").AddProbe(gst.PadProbeTypeBuffer, func(_ *gst.Pad, info *gst.PadProbeInfo) gst.PadProbeReturn {
+		if buffer := info.GetBuffer(); buffer != nil {
+			t.onOutput(buffer.PresentationTimestamp(), time.Now())
+		}
+		return gst.PadProbeOK
+	})
+}
+
+func (t *latencyTracker) onInput(pts time.Duration, now time.Time) {
+	if pts < 0 {
+		return
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if len(t.pending) >= maxPendingLatencyBuffers {
+		t.pending = make(map[time.Duration]time.Time)
+	}
+	t.pending[pts] = now
+}
+
+func (t *latencyTracker) onOutput(pts time.Duration, now time.Time) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	in, ok := t.pending[pts]
+	if !ok {
+		return
+	}
+	delete(t.pending, pts)
+
+	latency := now.Sub(in)
+	if t.latency == 0 {
+		t.latency = latency
+	} else {
+		t.latency += time.Duration(latencySmoothing * float64(latency-t.latency))
+	}
+}
+
+func (t *latencyTracker) get() time.Duration {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	return t.latency
+}
+
+// audioLevel accumulates the energy of S16LE audio samples
+type audioLevel struct {
+	sumSquares atomic.Float64
+	samples    atomic.Uint64
+}
+
+func (a *audioLevel) newProbe() gst.PadProbeCallback {
+	return func(_ *gst.Pad, info *gst.PadProbeInfo) gst.PadProbeReturn {
+		if buffer := info.GetBuffer(); buffer != nil {
+			a.add(buffer.Bytes())
+		}
+		return gst.PadProbeOK
+	}
+}
+
+func (a *audioLevel) add(data []byte) {
+	var sum float64
+	for i := 0; i+1 < len(data); i += 2 {
+		v := float64(int16(binary.LittleEndian.Uint16(data[i:]))) / 32768
+		sum += v * v
+	}
+
+	a.sumSquares.Add(sum)
+	a.samples.Add(uint64(len(data) / 2))
+}
@@ -2,12 +2,17 @@ package media
 
 import (
 	"context"
+	"fmt"
+	"sync"
 
 	"github.com/tinyzimmer/go-gst/gst"
 
+	"github.com/livekit/ingress/pkg/errors"
 	"github.com/livekit/ingress/pkg/lksdk_output"
 	"github.com/livekit/ingress/pkg/params"
+	"github.com/livekit/ingress/pkg/recorder"
 	"github.com/livekit/ingress/pkg/types"
+	"github.com/livekit/protocol/livekit"
 	"github.com/livekit/protocol/logger"
 	"github.com/livekit/protocol/tracer"
 	"github.com/livekit/protocol/utils"
@@ -16,21 +21,34 @@ import (
 type WebRTCSink struct {
 	params *params.Params
 
-	sdkOut *lksdk_output.LKSDKOutput
+	sdkOut    lksdk_output.RoomOutput
+	timeline  *recorder.Timeline
+	recorders []*recorder.TrackRecorder
+
+	lock    sync.Mutex
+	outputs []*Output
+}
+
+// RoomOutputFunc creates the output the tracks of the pipeline are published to
+type RoomOutputFunc func(ctx context.Context, p *params.Params) (lksdk_output.RoomOutput, error)
+
+func newLKSDKOutput(ctx context.Context, p *params.Params) (lksdk_output.RoomOutput, error) {
+	return lksdk_output.NewLKSDKOutput(ctx, p)
 }
 
-func NewWebRTCSink(ctx context.Context, p *params.Params) (*WebRTCSink, error) {
+func NewWebRTCSink(ctx context.Context, p *params.Params, newOutput RoomOutputFunc) (*WebRTCSink, error) {
 	ctx, span := tracer.Start(ctx, "media.NewWebRTCSink")
 	defer span.End()
 
-	sdkOut, err := lksdk_output.NewLKSDKOutput(ctx, p)
+	sdkOut, err := newOutput(ctx, p)
 	if err != nil {
 		return nil, err
 	}
 
 	return &WebRTCSink{
-		params: p,
-		sdkOut: sdkOut,
+		params:   p,
+		sdkOut:   sdkOut,
+		timeline: recorder.NewTimeline(),
 	}, nil
 }
 
@@ -41,11 +59,14 @@ func (s *WebRTCSink) addAudioTrack() (*Output, error) {
 		return nil, err
 	}
 
-	err = s.sdkOut.AddAudioTrack(output, utils.GetMimeTypeForAudioCodec(s.params.AudioEncodingOptions.AudioCodec), s.params.AudioEncodingOptions.DisableDtx, s.params.AudioEncodingOptions.Channels > 1)
+	mimeType := utils.GetMimeTypeForAudioCodec(s.params.AudioEncodingOptions.AudioCodec)
+	err = s.sdkOut.AddAudioTrack(output, mimeType, s.params.AudioEncodingOptions.DisableDtx, s.params.AudioEncodingOptions.Channels > 1)
 	if err != nil {
 		return nil, err
 	}
 
+	s.addRecorder(output.Output, types.Audio, mimeType)
+
 	return output.Output, nil
 }
 
@@ -61,15 +82,48 @@ func (s *WebRTCSink) addVideoTrack() ([]*Output, error) {
 		sbArray = append(sbArray, output)
 	}
 
-	err := s.sdkOut.AddVideoTrack(sbArray, s.params.VideoEncodingOptions.Layers, utils.GetMimeTypeForVideoCodec(s.params.VideoEncodingOptions.VideoCodec))
+	mimeType := utils.GetMimeTypeForVideoCodec(s.params.VideoEncodingOptions.VideoCodec)
+	err := s.sdkOut.AddVideoTrack(sbArray, s.params.VideoEncodingOptions.Layers, mimeType)
 	if err != nil {
 		return nil, err
 	}
 
+	// Only the highest quality layer is recorded
+	if len(outputs) > 0 {
+		s.addRecorder(outputs[0], types.Video, mimeType)
+	}
+
 	return outputs, nil
 }
 
-func (s *WebRTCSink) AddTrack(kind types.StreamKind) (*gst.Bin, error) {
+func (s *WebRTCSink) addPassthroughVideoTrack(caps *gst.Caps) (*Output, error) {
+	w, h, err := getH264Dimensions(caps)
+	if err != nil {
+		return nil, err
+	}
+
+	output, err := NewPassthroughVideoOutput()
+	if err != nil {
+		return nil, err
+	}
+
+	layers := []*livekit.VideoLayer{
+		&livekit.VideoLayer{Width: w, Height: h, Quality: livekit.VideoQuality_HIGH},
+	}
+
+	mimeType := utils.GetMimeTypeForVideoCodec(livekit.VideoCodec_H264_BASELINE)
+	logger.Infow("adding passthrough video track", "width", w, "height", h)
+	err = s.sdkOut.AddVideoTrack([]lksdk_output.VideoSampleProvider{output}, layers, mimeType)
+	if err != nil {
+		return nil, err
+	}
+
+	s.addRecorder(output.Output, types.Video, mimeType)
+
+	return output.Output, nil
+}
+
+func (s *WebRTCSink) AddTrack(kind types.StreamKind, caps *gst.Caps) (*gst.Bin, error) {
 	var bin *gst.Bin
 
 	switch kind {
@@ -81,8 +135,21 @@ func (s *WebRTCSink) AddTrack(kind types.StreamKind) (*gst.Bin, error) {
 		}
 
 		bin = output.bin
+		s.addOutputs(output)
 
 	case types.Video:
+		if s.params.BypassTranscoding {
+			output, err := s.addPassthroughVideoTrack(caps)
+			if err != nil {
+				logger.Errorw("could not add passthrough video track", err)
+				return nil, err
+			}
+
+			bin = output.bin
+			s.addOutputs(output)
+			break
+		}
+
 		outputs, err := s.addVideoTrack()
 		if err != nil {
 			logger.Errorw("could not add video track", err)
@@ -96,11 +163,82 @@ func (s *WebRTCSink) AddTrack(kind types.StreamKind) (*gst.Bin, error) {
 		}
 
 		bin = pp.GetBin()
+		s.addOutputs(outputs...)
 	}
 
 	return bin, nil
 }
 
+func (s *WebRTCSink) addOutputs(outputs ...*Output) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.outputs = append(s.outputs, outputs...)
+}
+
+// TrackStats returns the counters of the media sent so far for each track, and each simulcast layer of the video track
+func (s *WebRTCSink) TrackStats() []*OutputTrackStats {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	trackStats := make([]*OutputTrackStats, 0, len(s.outputs))
+	for _, output := range s.outputs {
+		trackStats = append(trackStats, output.Stats())
+	}
+
+	return trackStats
+}
+
+// addRecorder archives the samples of the output if recording is enabled. Recording failures do not affect the ingress
+func (s *WebRTCSink) addRecorder(output *Output, kind types.StreamKind, mimeType string) {
+	if !s.params.Recording.Enabled {
+		return
+	}
+
+	r, err := recorder.NewTrackRecorder(&s.params.Recording, s.timeline, s.params.IngressId, kind, mimeType)
+	if err != nil {
+		logger.Errorw("could not create recorder", err, "kind", kind)
+		return
+	}
+
+	output.recorder = r
+	s.recorders = append(s.recorders, r)
+}
+
+func getH264Dimensions(caps *gst.Caps) (uint32, uint32, error) {
+	if caps == nil || caps.GetSize() == 0 {
+		return 0, 0, errors.ErrUnsupportedDecodeFormat
+	}
+
+	st := caps.GetStructureAt(0)
+	if st.Name() != "video/x-h264" {
+		return 0, 0, errors.ErrUnsupportedDecodeFormat
+	}
+
+	w, err := st.GetValue("width")
+	if err != nil {
+		return 0, 0, err
+	}
+	h, err := st.GetValue("height")
+	if err != nil {
+		return 0, 0, err
+	}
+
+	width, ok := w.(int)
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid width in caps %s", caps.String())
+	}
+	height, ok := h.(int)
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid height in caps %s", caps.String())
+	}
+
+	return uint32(width), uint32(height), nil
+}
+
 func (s *WebRTCSink) Close() {
+	for _, r := range s.recorders {
+		r.Close()
+	}
 	s.sdkOut.Close()
 }
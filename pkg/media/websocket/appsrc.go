@@ -0,0 +1,116 @@
+package websocket
+
+import (
+	"context"
+	"io"
+
+	"github.com/frostbyte73/core"
+	"github.com/tinyzimmer/go-gst/gst"
+	"github.com/tinyzimmer/go-gst/gst/app"
+
+	"github.com/livekit/ingress/pkg/errors"
+	"github.com/livekit/ingress/pkg/params"
+	"github.com/livekit/protocol/logger"
+	"github.com/livekit/protocol/tracer"
+)
+
+const (
+	WebSocketAppSource = "websocketAppSrc"
+)
+
+// WebSocketRelaySource pushes the media chunks relayed from the WebSocket session. The container, e.g. WebM
+// or fragmented MP4, is detected by the ingress pipeline decoder.
+type WebSocketRelaySource struct {
+	params *params.Params
+
+	appSrc *app.Source
+	body   io.ReadCloser
+	closed core.Fuse
+	result chan error
+}
+
+func NewWebSocketRelaySource(ctx context.Context, p *params.Params) (*WebSocketRelaySource, error) {
+	ctx, span := tracer.Start(ctx, "WebSocketRelaySource.New")
+	defer span.End()
+
+	elem, err := gst.NewElementWithName("appsrc", WebSocketAppSource)
+	if err != nil {
+		logger.Errorw("could not create appsrc", err)
+		return nil, err
+	}
+	if err = elem.SetProperty("is-live", true); err != nil {
+		return nil, err
+	}
+	elem.SetArg("format", "time")
+
+	return &WebSocketRelaySource{
+		params: p,
+		appSrc: app.SrcFromElement(elem),
+		closed: core.NewFuse(),
+	}, nil
+}
+
+func (s *WebSocketRelaySource) Start(ctx context.Context) error {
+	ctx, span := tracer.Start(ctx, "WebSocketRelaySource.Start")
+	defer span.End()
+
+	s.result = make(chan error, 1)
+
+	resp, err := s.params.RelayClient.Get(s.params.RelayUrl)
+	switch {
+	case err != nil:
+		return err
+	case resp != nil && (resp.StatusCode < 200 || resp.StatusCode >= 400):
+		return errors.ErrHttpRelayFailure(resp.StatusCode)
+	}
+
+	s.body = resp.Body
+
+	go func() {
+		err := s.copyMedia(resp.Body)
+		switch {
+		case err == nil, err == io.EOF, s.closed.IsBroken():
+			err = nil
+		default:
+			logger.Errorw("error while copying media from relay", err)
+		}
+
+		s.appSrc.EndStream()
+
+		s.result <- err
+		close(s.result)
+	}()
+
+	return nil
+}
+
+func (s *WebSocketRelaySource) copyMedia(r io.Reader) error {
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			ret := s.appSrc.PushBuffer(gst.NewBufferFromBytes(buf[:n]))
+			switch ret {
+			case gst.FlowOK, gst.FlowFlushing:
+			case gst.FlowEOS:
+				return nil
+			default:
+				return errors.ErrFromGstFlowReturn(ret)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (s *WebSocketRelaySource) Close() error {
+	// Disconnects the publisher if it is still streaming
+	s.closed.Break()
+	s.body.Close()
+	return <-s.result
+}
+
+func (s *WebSocketRelaySource) GetSources(ctx context.Context) []*app.Source {
+	return []*app.Source{s.appSrc}
+}
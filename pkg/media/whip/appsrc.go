@@ -6,6 +6,7 @@ import (
 	"io"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/frostbyte73/core"
 	"github.com/livekit/ingress/pkg/errors"
@@ -18,26 +19,41 @@ import (
 	"github.com/tinyzimmer/go-gst/gst/app"
 )
 
+const (
+	relayRetryInterval = time.Second
+)
+
 type whipAppSource struct {
-	appSrc     *app.Source
-	trackKind  types.StreamKind
-	relayUrl   string
-	resourceId string
+	appSrc      *app.Source
+	trackKind   types.StreamKind
+	relayClient *http.Client
+	relayUrl    string
+	resourceId  string
+	gracePeriod time.Duration
+
+	// Timestamps of a reconnected session are shifted to follow the ones of the previous session
+	tsOffset time.Duration
+	firstTs  time.Duration
+	lastTs   time.Duration
+
+	onReconnecting func(reconnecting bool)
 
 	fuse   core.Fuse
 	result chan error
 }
 
-func NewWHIPAppSource(ctx context.Context, resourceId string, trackKind types.StreamKind, mimeType string, relayUrl string) (*whipAppSource, error) {
+func NewWHIPAppSource(ctx context.Context, resourceId string, trackKind types.StreamKind, mimeType string, relayClient *http.Client, relayUrl string, gracePeriod time.Duration) (*whipAppSource, error) {
 	ctx, span := tracer.Start(ctx, "WHIPRelaySource.New")
 	defer span.End()
 
 	w := &whipAppSource{
-		trackKind:  trackKind,
-		relayUrl:   relayUrl,
-		resourceId: resourceId,
-		fuse:       core.NewFuse(),
-		result:     make(chan error, 1),
+		trackKind:   trackKind,
+		relayClient: relayClient,
+		relayUrl:    relayUrl,
+		resourceId:  resourceId,
+		gracePeriod: gracePeriod,
+		fuse:        core.NewFuse(),
+		result:      make(chan error, 1),
 	}
 
 	elem, err := gst.NewElementWithName("appsrc", fmt.Sprintf("%s_%s", WHIPAppSourceLabel, trackKind))
@@ -68,7 +84,7 @@ func (w *whipAppSource) Start(ctx context.Context) error {
 
 	logger.Debugw("starting WHIP app source", "resourceID", w.resourceId, "kind", w.trackKind)
 
-	resp, err := http.Get(w.relayUrl)
+	resp, err := w.relayClient.Get(w.relayUrl)
 	switch {
 	case err != nil:
 		return err
@@ -77,9 +93,19 @@ func (w *whipAppSource) Start(ctx context.Context) error {
 	}
 
 	go func() {
-		defer resp.Body.Close()
+		var err error
+		for {
+			err = w.copyFromRelay(resp)
+			if err != io.ErrUnexpectedEOF || w.gracePeriod <= 0 {
+				break
+			}
 
-		err := w.copyRelayedData(resp.Body)
+			// The publisher went away without deleting the resource. Wait for it to come back with a new session
+			resp, err = w.waitForReconnection()
+			if err != nil {
+				break
+			}
+		}
 		logger.Debugw("WHIP app source relay stopped", "error", err, "resourceID", w.resourceId, "kind", w.trackKind)
 
 		w.appSrc.EndStream()
@@ -102,6 +128,54 @@ func (w *whipAppSource) GetAppSource() *app.Source {
 	return w.appSrc
 }
 
+func (w *whipAppSource) copyFromRelay(resp *http.Response) error {
+	defer resp.Body.Close()
+
+	return w.copyRelayedData(resp.Body)
+}
+
+func (w *whipAppSource) waitForReconnection() (*http.Response, error) {
+	logger.Infow("WHIP publisher disconnected, waiting for reconnection", "resourceID", w.resourceId, "kind", w.trackKind, "gracePeriod", w.gracePeriod)
+
+	disconnectedAt := time.Now()
+	if w.onReconnecting != nil {
+		w.onReconnecting(true)
+	}
+
+	deadline := time.NewTimer(w.gracePeriod)
+	defer deadline.Stop()
+	ticker := time.NewTicker(relayRetryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.fuse.Watch():
+			return nil, io.EOF
+		case <-deadline.C:
+			logger.Infow("WHIP publisher did not reconnect within the grace period", "resourceID", w.resourceId, "kind", w.trackKind)
+			return nil, io.ErrUnexpectedEOF
+		case <-ticker.C:
+			resp, err := w.relayClient.Get(w.relayUrl)
+			if err != nil {
+				continue
+			}
+			if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+				resp.Body.Close()
+				continue
+			}
+
+			w.tsOffset = w.lastTs + time.Since(disconnectedAt)
+			w.firstTs = -1
+
+			if w.onReconnecting != nil {
+				w.onReconnecting(false)
+			}
+
+			return resp, nil
+		}
+	}
+}
+
 func (w *whipAppSource) copyRelayedData(r io.Reader) error {
 	for {
 		if w.fuse.IsBroken() {
@@ -124,6 +198,12 @@ func (w *whipAppSource) copyRelayedData(r io.Reader) error {
 			return err
 		}
 
+		if w.firstTs < 0 {
+			w.firstTs = ts
+		}
+		ts = w.tsOffset + ts - w.firstTs
+		w.lastTs = ts
+
 		b := gst.NewBufferFromBytes(data)
 		b.SetPresentationTimestamp(ts)
 
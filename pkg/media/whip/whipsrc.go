@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"sync"
+	"time"
 
 	"github.com/tinyzimmer/go-gst/gst/app"
 
@@ -24,6 +26,9 @@ type WHIPSource struct {
 	params     *params.Params
 	resourceId string
 	trackSrc   map[types.StreamKind]*whipAppSource
+
+	lock              sync.Mutex
+	reconnectingCount int
 }
 
 func NewWHIPRelaySource(ctx context.Context, p *params.Params) (*WHIPSource, error) {
@@ -36,7 +41,7 @@ func NewWHIPRelaySource(ctx context.Context, p *params.Params) (*WHIPSource, err
 	mimeTypes := s.params.ExtraParams.(*params.WhipExtraParams).MimeTypes
 	for k, v := range mimeTypes {
 		relayUrl := s.getRelayUrl(k)
-		t, err := NewWHIPAppSource(ctx, s.resourceId, k, v, relayUrl)
+		t, err := NewWHIPAppSource(ctx, s.resourceId, k, v, p.RelayClient, relayUrl, time.Duration(p.ReconnectGracePeriodMs)*time.Millisecond)
 		if err != nil {
 			return nil, err
 		}
@@ -47,6 +52,29 @@ func NewWHIPRelaySource(ctx context.Context, p *params.Params) (*WHIPSource, err
 	return s, nil
 }
 
+// OnReconnecting registers a callback called when the publisher disconnects, and when it reconnects within the grace period
+func (s *WHIPSource) OnReconnecting(f func(reconnecting bool)) {
+	for _, t := range s.trackSrc {
+		t.onReconnecting = func(reconnecting bool) {
+			s.lock.Lock()
+			defer s.lock.Unlock()
+
+			// Only report the first track disconnecting and the last one reconnecting
+			if reconnecting {
+				s.reconnectingCount++
+				if s.reconnectingCount == 1 {
+					f(true)
+				}
+			} else {
+				s.reconnectingCount--
+				if s.reconnectingCount == 0 {
+					f(false)
+				}
+			}
+		}
+	}
+}
+
 func (s *WHIPSource) Start(ctx context.Context) error {
 	for _, t := range s.trackSrc {
 		err := t.Start(ctx)
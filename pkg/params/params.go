@@ -3,6 +3,10 @@ package params
 import (
 	"context"
 	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
 	"time"
 
 	"github.com/livekit/ingress/pkg/config"
@@ -13,6 +17,9 @@ import (
 	"google.golang.org/protobuf/proto"
 )
 
+// The relay is reached over a Unix domain socket, the host of its URLs is not used
+const relayUrlPrefix = "http://relay"
+
 type Params struct {
 	*config.Config
 	*livekit.IngressInfo
@@ -24,11 +31,17 @@ type Params struct {
 	WsUrl string
 	Token string
 
-	// relay info
-	RelayUrl string
+	// relay info, the URLs must be requested with RelayClient
+	RelayClient    *http.Client
+	RelayUrl       string
+	BackupRelayUrl string // only set if a backup publisher is accepted
+	ReportUrl      string // the handler process reports the state of the session to the service on this URL
 
 	// Input type specific private parameters
 	ExtraParams any
+
+	// guards IngressInfo.State, written by the pipeline and read when reporting the state
+	stateLock sync.Mutex
 }
 
 type WhipExtraParams struct {
@@ -36,17 +49,35 @@ type WhipExtraParams struct {
 	MimeTypes  map[types.StreamKind]string `json:"mime_types"`
 }
 
+type PullExtraParams struct {
+	RTSPTransport string `json:"rtsp_transport,omitempty"` // "tcp" or "udp". Both are tried if not set
+	Loop          bool   `json:"loop,omitempty"`           // URL input only
+}
+
 func GetParams(ctx context.Context, conf *config.Config, info *livekit.IngressInfo, wsUrl, token string, ep any) (*Params, error) {
 	var err error
 
 	relayUrl := ""
+	backupRelayUrl := ""
 	fields := []interface{}{"ingressID", info.IngressId}
 	switch info.InputType {
 	case livekit.IngressInput_RTMP_INPUT:
-		relayUrl = getRTMPRelayUrl(conf, info.StreamKey)
+		relayUrl = getRTMPRelayUrl(info.StreamKey)
+		if conf.RTMPBackup {
+			backupRelayUrl = getRTMPRelayUrl(info.StreamKey + types.BackupStreamKeySuffix)
+		}
 	case livekit.IngressInput_WHIP_INPUT:
 		fields = append(fields, "resourceID", ep.(*WhipExtraParams).ResourceId)
-		relayUrl = getWHIPRelayUrlPrefix(conf, ep.(*WhipExtraParams).ResourceId)
+		relayUrl = getWHIPRelayUrlPrefix(ep.(*WhipExtraParams).ResourceId)
+	case types.SRTInput:
+		relayUrl = getSRTRelayUrl(info.StreamKey)
+	case types.UDPInput:
+		relayUrl = getUDPRelayUrl(info.StreamKey)
+	case types.WebSocketInput:
+		relayUrl = getWebSocketRelayUrl(info.StreamKey)
+	case types.RTSPInput, types.URLInput:
+		// The media is pulled by the handler itself
+		fields = append(fields, "url", RedactURL(info.Url))
 	}
 
 	err = conf.InitLogger(fields...)
@@ -54,7 +85,7 @@ func GetParams(ctx context.Context, conf *config.Config, info *livekit.IngressIn
 		return nil, err
 	}
 
-	err = ingress.Validate(info)
+	err = Validate(info)
 	if err != nil {
 		return nil, err
 	}
@@ -101,19 +132,63 @@ func GetParams(ctx context.Context, conf *config.Config, info *livekit.IngressIn
 		VideoEncodingOptions: videoEncodingOptions,
 		Token:                token,
 		WsUrl:                wsUrl,
+		RelayClient:          NewRelayClient(conf, 0),
 		RelayUrl:             relayUrl,
+		BackupRelayUrl:       backupRelayUrl,
+		ReportUrl:            getReportUrl(info.IngressId),
 		ExtraParams:          ep,
 	}
 
 	return p, nil
 }
 
-func getRTMPRelayUrl(conf *config.Config, streamKey string) string {
-	return fmt.Sprintf("http://localhost:%d/rtmp/%s", conf.HTTPRelayPort, streamKey)
+// RedactURL hides the password of a source URL, to make it suitable for logging
+func RedactURL(u string) string {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return ""
+	}
+
+	return parsed.Redacted()
+}
+
+// NewRelayClient returns a client sending its requests to the relay socket of the service, whatever the host of the URL
+func NewRelayClient(conf *config.Config, timeout time.Duration) *http.Client {
+	socket := conf.HTTPRelaySocket
+	dialer := &net.Dialer{}
+
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return dialer.DialContext(ctx, "unix", socket)
+			},
+		},
+		Timeout: timeout,
+	}
+}
+
+func getRTMPRelayUrl(streamKey string) string {
+	return fmt.Sprintf("%s/rtmp/%s", relayUrlPrefix, streamKey)
+}
+
+func getWHIPRelayUrlPrefix(resourceId string) string {
+	return fmt.Sprintf("%s/whip/%s", relayUrlPrefix, resourceId)
 }
 
-func getWHIPRelayUrlPrefix(conf *config.Config, resourceId string) string {
-	return fmt.Sprintf("http://localhost:%d/whip/%s", conf.HTTPRelayPort, resourceId)
+func getSRTRelayUrl(streamKey string) string {
+	return fmt.Sprintf("%s/srt/%s", relayUrlPrefix, streamKey)
+}
+
+func getUDPRelayUrl(streamKey string) string {
+	return fmt.Sprintf("%s/udp/%s", relayUrlPrefix, streamKey)
+}
+
+func getWebSocketRelayUrl(streamKey string) string {
+	return fmt.Sprintf("%s/websocket/%s", relayUrlPrefix, streamKey)
+}
+
+func getReportUrl(ingressID string) string {
+	return fmt.Sprintf("%s/handler/%s", relayUrlPrefix, ingressID)
 }
 
 func getAudioEncodingOptions(options *livekit.IngressAudioOptions) (*livekit.IngressAudioEncodingOptions, error) {
@@ -204,10 +279,32 @@ func populateVideoEncodingOptionsDefaults(options *livekit.IngressVideoEncodingO
 }
 
 func (p *Params) SetStatus(status livekit.IngressState_Status, errString string) {
+	p.stateLock.Lock()
+	defer p.stateLock.Unlock()
+
 	p.State.Status = status
 	p.State.Error = errString
 }
 
 func (p *Params) SetRoomId(roomId string) {
+	p.stateLock.Lock()
+	defer p.stateLock.Unlock()
+
 	p.State.RoomId = roomId
 }
+
+// UpdateState runs f with the lock of the ingress state held, for the updates that SetStatus and SetRoomId do not cover
+func (p *Params) UpdateState(f func(state *livekit.IngressState)) {
+	p.stateLock.Lock()
+	defer p.stateLock.Unlock()
+
+	f(p.State)
+}
+
+// CopyInfo returns a copy of the ingress info, safe to use while the state keeps being updated
+func (p *Params) CopyInfo() *livekit.IngressInfo {
+	p.stateLock.Lock()
+	defer p.stateLock.Unlock()
+
+	return proto.Clone(p.IngressInfo).(*livekit.IngressInfo)
+}
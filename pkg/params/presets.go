@@ -15,6 +15,15 @@ const (
 	refFramerate = 30
 )
 
+// VideoPresets lists the video encoding presets supported by the service
+var VideoPresets = []livekit.IngressVideoEncodingPreset{
+	livekit.IngressVideoEncodingPreset_H264_720P_30FPS_3_LAYERS,
+	livekit.IngressVideoEncodingPreset_H264_1080P_30FPS_3_LAYERS,
+	livekit.IngressVideoEncodingPreset_H264_540P_25FPS_2_LAYERS,
+	livekit.IngressVideoEncodingPreset_H264_720P_30FPS_1_LAYER,
+	livekit.IngressVideoEncodingPreset_H264_1080P_30FPS_1_LAYER,
+}
+
 func getOptionsForVideoPreset(preset livekit.IngressVideoEncodingPreset) (*livekit.IngressVideoEncodingOptions, error) {
 	switch preset {
 	case livekit.IngressVideoEncodingPreset_H264_720P_30FPS_3_LAYERS:
@@ -43,3 +43,10 @@ func TestComputeVideoLayers(t *testing.T) {
 	require.Equal(t, expectedDefaultLayers[:1], l)
 
 }
+
+func TestVideoPresets(t *testing.T) {
+	for _, preset := range VideoPresets {
+		_, err := getOptionsForVideoPreset(preset)
+		require.NoError(t, err, preset.String())
+	}
+}
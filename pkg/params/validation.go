@@ -0,0 +1,111 @@
+package params
+
+import (
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/livekit/ingress/pkg/types"
+	"github.com/livekit/protocol/ingress"
+	"github.com/livekit/protocol/livekit"
+	"google.golang.org/protobuf/proto"
+)
+
+// Validate extends ingress.Validate with the input types that are only known to this service.
+func Validate(info *livekit.IngressInfo) error {
+	if info == nil {
+		return ingress.ErrInvalidIngress("missing IngressInfo")
+	}
+
+	switch info.InputType {
+	case types.SRTInput, types.RTSPInput, types.URLInput, types.UDPInput, types.WebSocketInput:
+		switch info.InputType {
+		case types.RTSPInput:
+			if err := validatePullURL(info.Url, "rtsp", "rtsps"); err != nil {
+				return err
+			}
+		case types.URLInput:
+			if err := validatePullURL(info.Url, "http", "https", "file"); err != nil {
+				return err
+			}
+		}
+
+		if info.BypassTranscoding {
+			return ingress.NewInvalidTranscodingBypassError("bypassing transcoding impossible with selected input type")
+		}
+
+		// Validate everything else as if this was a transcoded RTMP ingress
+		infoCopy := proto.Clone(info).(*livekit.IngressInfo)
+		infoCopy.InputType = livekit.IngressInput_RTMP_INPUT
+
+		return ingress.Validate(infoCopy)
+	case livekit.IngressInput_RTMP_INPUT:
+		if !info.BypassTranscoding {
+			return ingress.Validate(info)
+		}
+
+		// Transcoding bypass is only known to this service for RTMP. Audio is still transcoded
+		infoCopy := proto.Clone(info).(*livekit.IngressInfo)
+		infoCopy.BypassTranscoding = false
+
+		return ingress.Validate(infoCopy)
+	default:
+		return ingress.Validate(info)
+	}
+}
+
+// validatePullURL checks that the media of a pull ingress can be fetched from the URL with one of the given schemes
+func validatePullURL(u string, schemes ...string) error {
+	if u == "" {
+		return ingress.ErrInvalidIngress("no source URL")
+	}
+
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return ingress.ErrInvalidIngress("invalid source URL")
+	}
+
+	for _, scheme := range schemes {
+		if parsed.Scheme == scheme {
+			return nil
+		}
+	}
+
+	return ingress.ErrInvalidIngress("unsupported source URL scheme")
+}
+
+// ResolveFileURL checks that a file URL points inside the directory local files can be pulled from, once symbolic
+// links are resolved, and returns the URL of the resolved file. The handler is given the resolved URL, for the links
+// not to be changed after the check. Other URLs are returned as is, and file URLs are rejected when no directory is given
+func ResolveFileURL(u string, dir string) (string, error) {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return "", ingress.ErrInvalidIngress("invalid source URL")
+	}
+	if parsed.Scheme != "file" {
+		return u, nil
+	}
+	if dir == "" {
+		return "", ingress.ErrInvalidIngress("local source files not allowed")
+	}
+	if (parsed.Host != "" && parsed.Host != "localhost") || !filepath.IsAbs(parsed.Path) {
+		return "", ingress.ErrInvalidIngress("invalid source URL")
+	}
+
+	file, err := filepath.EvalSymlinks(parsed.Path)
+	if err != nil {
+		return "", ingress.ErrInvalidIngress("source file not found")
+	}
+	dir, err = filepath.EvalSymlinks(dir)
+	if err != nil {
+		return "", ingress.ErrInvalidIngress("source file directory not found")
+	}
+
+	rel, err := filepath.Rel(dir, file)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", ingress.ErrInvalidIngress("source file outside of the allowed directory")
+	}
+
+	resolved := &url.URL{Scheme: "file", Path: file}
+	return resolved.String(), nil
+}
@@ -0,0 +1,117 @@
+package recorder
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/Eyevinn/mp4ff/bits"
+	"github.com/Eyevinn/mp4ff/mp4"
+)
+
+// Sample entry configuration boxes for the codecs mp4ff does not provide
+
+const (
+	// Samples to discard at the beginning of the Opus stream, libopus default
+	opusPreSkip = 312
+)
+
+// dOpsBox is the Opus specific box, https://opus-codec.org/docs/opus_in_isobmff.html
+type dOpsBox struct {
+	outputChannelCount uint8
+	preSkip            uint16
+	inputSampleRate    uint32
+}
+
+func newDOpsBox(channels uint8) *dOpsBox {
+	return &dOpsBox{
+		outputChannelCount: channels,
+		preSkip:            opusPreSkip,
+		inputSampleRate:    audioTimescale,
+	}
+}
+
+func (b *dOpsBox) Type() string {
+	return "dOps"
+}
+
+func (b *dOpsBox) Size() uint64 {
+	// header, version, channel count, pre-skip, input sample rate, output gain, channel mapping family
+	return 8 + 1 + 1 + 2 + 4 + 2 + 1
+}
+
+func (b *dOpsBox) Encode(w io.Writer) error {
+	return encodeBox(b, w)
+}
+
+func (b *dOpsBox) EncodeSW(sw bits.SliceWriter) error {
+	if err := mp4.EncodeHeaderSW(b, sw); err != nil {
+		return err
+	}
+	sw.WriteUint8(0) // version
+	sw.WriteUint8(b.outputChannelCount)
+	sw.WriteUint16(b.preSkip)
+	sw.WriteUint32(b.inputSampleRate)
+	sw.WriteInt16(0) // output gain
+	sw.WriteUint8(0) // channel mapping family, mono or stereo
+	return sw.AccError()
+}
+
+func (b *dOpsBox) Info(w io.Writer, _, indent, _ string) error {
+	_, err := fmt.Fprintf(w, "%s[%s] size=%d channels=%d preSkip=%d inputSampleRate=%d\n",
+		indent, b.Type(), b.Size(), b.outputChannelCount, b.preSkip, b.inputSampleRate)
+	return err
+}
+
+// vpcCBox is the VP codec configuration box, https://www.webmproject.org/vp9/mp4/
+type vpcCBox struct{}
+
+func newVpcCBox() *vpcCBox {
+	return &vpcCBox{}
+}
+
+func (b *vpcCBox) Type() string {
+	return "vpcC"
+}
+
+func (b *vpcCBox) Size() uint64 {
+	// header, version and flags, profile, level, bit depth/chroma/range, colour description, codec init data size
+	return 8 + 4 + 1 + 1 + 1 + 3 + 2
+}
+
+func (b *vpcCBox) Encode(w io.Writer) error {
+	return encodeBox(b, w)
+}
+
+func (b *vpcCBox) EncodeSW(sw bits.SliceWriter) error {
+	if err := mp4.EncodeHeaderSW(b, sw); err != nil {
+		return err
+	}
+	sw.WriteUint32(1 << 24) // version 1, no flags
+	sw.WriteUint8(0)        // profile
+	sw.WriteUint8(0)        // level, unused for VP8
+	// 8 bit depth, 4:2:0 colocated chroma, limited range
+	sw.WriteBits(8, 4)
+	sw.WriteBits(1, 3)
+	sw.WriteBits(0, 1)
+	sw.FlushBits()
+	// BT.709 colour primaries, transfer characteristics and matrix coefficients
+	sw.WriteUint8(1)
+	sw.WriteUint8(1)
+	sw.WriteUint8(1)
+	sw.WriteUint16(0) // no codec initialization data
+	return sw.AccError()
+}
+
+func (b *vpcCBox) Info(w io.Writer, _, indent, _ string) error {
+	_, err := fmt.Fprintf(w, "%s[%s] size=%d\n", indent, b.Type(), b.Size())
+	return err
+}
+
+func encodeBox(b mp4.Box, w io.Writer) error {
+	sw := bits.NewFixedSliceWriter(int(b.Size()))
+	if err := b.EncodeSW(sw); err != nil {
+		return err
+	}
+	_, err := w.Write(sw.Bytes())
+	return err
+}
@@ -0,0 +1,415 @@
+package recorder
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Eyevinn/mp4ff/avc"
+	"github.com/Eyevinn/mp4ff/mp4"
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media"
+
+	"github.com/livekit/ingress/pkg/config"
+	"github.com/livekit/ingress/pkg/errors"
+	"github.com/livekit/ingress/pkg/types"
+	"github.com/livekit/protocol/logger"
+)
+
+const (
+	videoTimescale = 90000
+	audioTimescale = 48000
+
+	// Fragments are also cut at every video key frame
+	fragmentDuration = time.Second
+
+	// Samples are written back to back, unless their timestamps are further ahead than this
+	maxTimestampDrift = 100 * time.Millisecond
+)
+
+// Timeline is shared by the recorders of the tracks of an ingress. Their decode times are relative to the first sample
+// recorded, so that the files of the different tracks can be aligned.
+type Timeline struct {
+	lock    sync.Mutex
+	started bool
+	start   time.Duration
+}
+
+func NewTimeline() *Timeline {
+	return &Timeline{}
+}
+
+// decodeTime returns the time elapsed between the first sample and the given timestamp, in timescale units
+func (t *Timeline) decodeTime(ts time.Duration, timescale uint64) uint64 {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if !t.started {
+		t.start = ts
+		t.started = true
+	}
+	if ts <= t.start {
+		return 0
+	}
+
+	return uint64((ts - t.start).Seconds() * float64(timescale))
+}
+
+// TrackRecorder writes the samples of a single track to a rolling series of fragmented MP4 files.
+// Each file is self contained and starts with a key frame, so that it can be played back on its own.
+type TrackRecorder struct {
+	logger          logger.Logger
+	timeline        *Timeline
+	dir             string
+	kind            types.StreamKind
+	mimeType        string
+	timescale       uint64
+	segmentDuration uint64 // in timescale units
+	retention       time.Duration
+
+	lock          sync.Mutex
+	file          *os.File
+	fragment      *mp4.Fragment
+	segmentStart  uint64
+	fragmentStart uint64
+	seqNumber     uint32
+	segmentIndex  int
+	decodeTime    uint64 // of the next sample
+	lastDuration  uint32
+	closed        bool
+	sps, pps      [][]byte // H.264
+	width, height uint16   // VP8
+	channels      uint8    // Opus
+	configured    bool
+}
+
+func NewTrackRecorder(conf *config.RecordingConfig, timeline *Timeline, ingressID string, kind types.StreamKind, mimeType string) (*TrackRecorder, error) {
+	var timescale uint64
+	switch strings.ToLower(mimeType) {
+	case strings.ToLower(webrtc.MimeTypeH264), strings.ToLower(webrtc.MimeTypeVP8):
+		timescale = videoTimescale
+	case strings.ToLower(webrtc.MimeTypeOpus):
+		timescale = audioTimescale
+	default:
+		return nil, errors.ErrRecordingUnsupported
+	}
+
+	dir := path.Join(conf.Directory, ingressID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	return &TrackRecorder{
+		logger:          logger.GetLogger().WithValues("ingressID", ingressID, "kind", kind),
+		timeline:        timeline,
+		dir:             dir,
+		kind:            kind,
+		mimeType:        strings.ToLower(mimeType),
+		timescale:       timescale,
+		segmentDuration: uint64(conf.SegmentDurationMs) * timescale / 1000,
+		retention:       time.Duration(conf.RetentionMinutes) * time.Minute,
+		segmentIndex:    getNextSegmentIndex(dir, kind),
+	}, nil
+}
+
+// getNextSegmentIndex returns the index following the ones of the files already recorded for the kind, if any
+func getNextSegmentIndex(dir string, kind types.StreamKind) int {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0
+	}
+
+	next := 0
+	for _, e := range entries {
+		var index int
+		if _, err := fmt.Sscanf(e.Name(), string(kind)+"_%d.mp4", &index); err == nil && index >= next {
+			next = index + 1
+		}
+	}
+
+	return next
+}
+
+// WriteSample records a sample with the given timestamp, on a clock shared by the tracks of the ingress, or a negative one
+// if unknown. H.264 samples are expected in Annex B format, with the parameter sets before key frames.
+// Recording is stopped on the first failure, without affecting the ingress.
+func (r *TrackRecorder) WriteSample(s *media.Sample, ts time.Duration) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.closed {
+		return
+	}
+
+	if err := r.writeSample(s, ts); err != nil {
+		r.logger.Errorw("recording failed, stopping", err)
+		r.closeLocked()
+	}
+}
+
+func (r *TrackRecorder) Close() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.closeLocked()
+}
+
+func (r *TrackRecorder) closeLocked() {
+	if r.closed {
+		return
+	}
+	r.closed = true
+
+	if err := r.closeSegment(); err != nil {
+		r.logger.Warnw("failed to close recording", err)
+	}
+}
+
+func (r *TrackRecorder) writeSample(s *media.Sample, ts time.Duration) error {
+	data, keyFrame, err := r.parseSample(s.Data)
+	if err != nil || len(data) == 0 {
+		return err
+	}
+
+	// The timestamps jitter, samples only move the decode time forward when there is a gap
+	discontinuity := false
+	if ts >= 0 {
+		sampleTime := r.timeline.decodeTime(ts, r.timescale)
+		if r.file == nil || sampleTime > r.decodeTime+uint64(maxTimestampDrift.Seconds()*float64(r.timescale)) {
+			discontinuity = r.file != nil
+			r.decodeTime = sampleTime
+		}
+	}
+
+	if r.file != nil && keyFrame && r.decodeTime-r.segmentStart >= r.segmentDuration {
+		if err = r.closeSegment(); err != nil {
+			return err
+		}
+	}
+
+	if r.file == nil {
+		if !keyFrame || !r.configured {
+			// Wait for a key frame with the codec configuration
+			return nil
+		}
+		if err = r.openSegment(); err != nil {
+			return err
+		}
+	}
+
+	cutFragment := r.decodeTime-r.fragmentStart >= uint64(fragmentDuration.Seconds()*float64(r.timescale))
+	if r.kind == types.Video && keyFrame {
+		cutFragment = true
+	}
+	if r.fragment != nil && (cutFragment || discontinuity) {
+		if err = r.writeFragment(); err != nil {
+			return err
+		}
+	}
+
+	if r.fragment == nil {
+		r.seqNumber++
+		r.fragment, err = mp4.CreateFragment(r.seqNumber, 1)
+		if err != nil {
+			return err
+		}
+		r.fragmentStart = r.decodeTime
+	}
+
+	dur := uint32(uint64(s.Duration) * r.timescale / uint64(time.Second))
+	if s.Duration <= 0 || s.Duration > fragmentDuration {
+		// Missing or bogus duration, assume the sample rate did not change
+		dur = r.lastDuration
+	}
+	r.lastDuration = dur
+
+	flags := mp4.NonSyncSampleFlags
+	if keyFrame {
+		flags = mp4.SyncSampleFlags
+	}
+
+	r.fragment.AddFullSample(mp4.FullSample{
+		Sample:     mp4.NewSample(flags, dur, uint32(len(data)), 0),
+		DecodeTime: r.decodeTime,
+		Data:       data,
+	})
+	r.decodeTime += uint64(dur)
+
+	return nil
+}
+
+// parseSample converts the sample to its MP4 representation, and picks up codec configuration changes
+func (r *TrackRecorder) parseSample(data []byte) ([]byte, bool, error) {
+	if len(data) == 0 {
+		return nil, false, nil
+	}
+
+	switch r.mimeType {
+	case strings.ToLower(webrtc.MimeTypeH264):
+		var sample []byte
+		keyFrame := false
+		for _, nalu := range avc.ExtractNalusFromByteStream(data) {
+			if len(nalu) == 0 {
+				continue
+			}
+
+			switch naluType := avc.GetNaluType(nalu[0]); naluType {
+			case avc.NALU_SPS:
+				r.sps = [][]byte{nalu}
+			case avc.NALU_PPS:
+				r.pps = [][]byte{nalu}
+			case avc.NALU_AUD:
+				// dropped
+			default:
+				if naluType == avc.NALU_IDR {
+					keyFrame = true
+				}
+				sample = binary.BigEndian.AppendUint32(sample, uint32(len(nalu)))
+				sample = append(sample, nalu...)
+			}
+		}
+		r.configured = len(r.sps) > 0 && len(r.pps) > 0
+
+		return sample, keyFrame, nil
+
+	case strings.ToLower(webrtc.MimeTypeVP8):
+		// Key frames have a 10 byte header including the dimensions, RFC 6386 section 9.1
+		keyFrame := data[0]&0x01 == 0
+		if keyFrame {
+			if len(data) < 10 {
+				return nil, false, fmt.Errorf("invalid VP8 key frame")
+			}
+			r.width = binary.LittleEndian.Uint16(data[6:8]) & 0x3fff
+			r.height = binary.LittleEndian.Uint16(data[8:10]) & 0x3fff
+			r.configured = true
+		}
+
+		return data, keyFrame, nil
+
+	case strings.ToLower(webrtc.MimeTypeOpus):
+		// The stereo flag is in the TOC byte, RFC 6716 section 3.1
+		if !r.configured {
+			r.channels = 1
+			if data[0]&0x04 != 0 {
+				r.channels = 2
+			}
+			r.configured = true
+		}
+
+		return data, true, nil
+	}
+
+	return nil, false, errors.ErrRecordingUnsupported
+}
+
+func (r *TrackRecorder) openSegment() error {
+	initSeg := mp4.CreateEmptyInit()
+
+	switch r.kind {
+	case types.Video:
+		initSeg.AddEmptyTrack(uint32(r.timescale), "video", "und")
+	default:
+		initSeg.AddEmptyTrack(uint32(r.timescale), "audio", "und")
+	}
+	trak := initSeg.Moov.Trak
+
+	switch r.mimeType {
+	case strings.ToLower(webrtc.MimeTypeH264):
+		if err := trak.SetAVCDescriptor("avc1", r.sps, r.pps, true); err != nil {
+			return err
+		}
+	case strings.ToLower(webrtc.MimeTypeVP8):
+		trak.Tkhd.Width = mp4.Fixed32(uint32(r.width) << 16)
+		trak.Tkhd.Height = mp4.Fixed32(uint32(r.height) << 16)
+		trak.Mdia.Minf.Stbl.Stsd.AddChild(mp4.CreateVisualSampleEntryBox("vp08", r.width, r.height, newVpcCBox()))
+	case strings.ToLower(webrtc.MimeTypeOpus):
+		trak.Mdia.Minf.Stbl.Stsd.AddChild(mp4.CreateAudioSampleEntryBox("Opus", uint16(r.channels), 16, audioTimescale, newDOpsBox(r.channels)))
+	}
+
+	// Files are never overwritten, e.g. by the recorder of a restarted handler
+	var name string
+	var f *os.File
+	for {
+		name = path.Join(r.dir, fmt.Sprintf("%s_%06d.mp4", r.kind, r.segmentIndex))
+		r.segmentIndex++
+
+		var err error
+		f, err = os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			break
+		}
+		if !os.IsExist(err) {
+			return err
+		}
+	}
+
+	if err := initSeg.Encode(f); err != nil {
+		f.Close()
+		return err
+	}
+
+	r.logger.Debugw("recording to new file", "file", name)
+
+	r.file = f
+	r.seqNumber = 0
+	r.segmentStart = r.decodeTime
+
+	r.deleteExpiredSegments()
+
+	return nil
+}
+
+func (r *TrackRecorder) writeFragment() error {
+	f := r.fragment
+	r.fragment = nil
+
+	return f.Encode(r.file)
+}
+
+func (r *TrackRecorder) closeSegment() error {
+	if r.file == nil {
+		return nil
+	}
+
+	var err error
+	if r.fragment != nil {
+		err = r.writeFragment()
+	}
+	if closeErr := r.file.Close(); err == nil {
+		err = closeErr
+	}
+	r.file = nil
+
+	return err
+}
+
+func (r *TrackRecorder) deleteExpiredSegments() {
+	if r.retention <= 0 {
+		return
+	}
+
+	entries, err := os.ReadDir(r.dir)
+	if err != nil {
+		r.logger.Warnw("failed to list recordings", err)
+		return
+	}
+
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasPrefix(e.Name(), fmt.Sprintf("%s_", r.kind)) || path.Ext(e.Name()) != ".mp4" {
+			continue
+		}
+
+		info, err := e.Info()
+		if err != nil || time.Since(info.ModTime()) < r.retention {
+			continue
+		}
+
+		if err = os.Remove(path.Join(r.dir, e.Name())); err != nil {
+			r.logger.Warnw("failed to delete expired recording", err, "file", e.Name())
+		}
+	}
+}
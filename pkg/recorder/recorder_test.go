@@ -0,0 +1,150 @@
+package recorder
+
+import (
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/Eyevinn/mp4ff/mp4"
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media"
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/ingress/pkg/config"
+	"github.com/livekit/ingress/pkg/types"
+)
+
+func TestOpusRecording(t *testing.T) {
+	conf := &config.RecordingConfig{
+		Enabled:           true,
+		Directory:         t.TempDir(),
+		SegmentDurationMs: 1000,
+	}
+
+	r, err := NewTrackRecorder(conf, NewTimeline(), "ingressID", types.Audio, webrtc.MimeTypeOpus)
+	require.NoError(t, err)
+
+	// 2.5s of stereo 20ms frames, the segments rotate every second
+	for i := 0; i < 125; i++ {
+		r.WriteSample(&media.Sample{Data: []byte{0xfc, 0x01, 0x02, 0x03}, Duration: 20 * time.Millisecond}, time.Duration(i)*20*time.Millisecond)
+	}
+	r.Close()
+
+	dir := path.Join(conf.Directory, "ingressID")
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+
+	f, err := mp4.ReadMP4File(path.Join(dir, entries[0].Name()))
+	require.NoError(t, err)
+	require.Equal(t, uint32(audioTimescale), f.Init.Moov.Trak.Mdia.Mdhd.Timescale)
+
+	var sampleCount int
+	for _, s := range f.Segments {
+		for _, frag := range s.Fragments {
+			sampleCount += int(frag.Moof.Traf.Trun.SampleCount())
+		}
+	}
+	require.Equal(t, 50, sampleCount)
+}
+
+func TestVideoRecordingStartsOnKeyFrame(t *testing.T) {
+	conf := &config.RecordingConfig{
+		Enabled:           true,
+		Directory:         t.TempDir(),
+		SegmentDurationMs: 60000,
+	}
+
+	r, err := NewTrackRecorder(conf, NewTimeline(), "ingressID", types.Video, webrtc.MimeTypeVP8)
+	require.NoError(t, err)
+
+	// Inter frame, dropped
+	r.WriteSample(&media.Sample{Data: []byte{0x01, 0x00, 0x00}, Duration: 33 * time.Millisecond}, 0)
+
+	dir := path.Join(conf.Directory, "ingressID")
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+
+	// 640x360 key frame
+	r.WriteSample(&media.Sample{Data: []byte{0x00, 0x00, 0x00, 0x9d, 0x01, 0x2a, 0x80, 0x02, 0x68, 0x01}, Duration: 33 * time.Millisecond}, 33*time.Millisecond)
+	r.WriteSample(&media.Sample{Data: []byte{0x01, 0x00, 0x00}, Duration: 33 * time.Millisecond}, 66*time.Millisecond)
+	r.Close()
+
+	entries, err = os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+
+	f, err := mp4.ReadMP4File(path.Join(dir, entries[0].Name()))
+	require.NoError(t, err)
+	require.Equal(t, mp4.Fixed32(640<<16), f.Init.Moov.Trak.Tkhd.Width)
+	require.Equal(t, mp4.Fixed32(360<<16), f.Init.Moov.Trak.Tkhd.Height)
+	require.Equal(t, uint32(2), f.Segments[0].Fragments[0].Moof.Traf.Trun.SampleCount())
+}
+
+func TestUnsupportedRecordingFormat(t *testing.T) {
+	_, err := NewTrackRecorder(&config.RecordingConfig{Directory: t.TempDir()}, NewTimeline(), "ingressID", types.Video, webrtc.MimeTypeVP9)
+	require.Error(t, err)
+}
+
+func TestRecordingDoesNotOverwrite(t *testing.T) {
+	conf := &config.RecordingConfig{
+		Enabled:           true,
+		Directory:         t.TempDir(),
+		SegmentDurationMs: 60000,
+	}
+
+	// Recorder of a restarted handler
+	for i := 0; i < 2; i++ {
+		r, err := NewTrackRecorder(conf, NewTimeline(), "ingressID", types.Audio, webrtc.MimeTypeOpus)
+		require.NoError(t, err)
+		r.WriteSample(&media.Sample{Data: []byte{0xfc, 0x01, 0x02, 0x03}, Duration: 20 * time.Millisecond}, -1)
+		r.Close()
+	}
+
+	entries, err := os.ReadDir(path.Join(conf.Directory, "ingressID"))
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	require.Equal(t, "audio_000000.mp4", entries[0].Name())
+	require.Equal(t, "audio_000001.mp4", entries[1].Name())
+}
+
+func TestRecordingTimeline(t *testing.T) {
+	conf := &config.RecordingConfig{
+		Enabled:           true,
+		Directory:         t.TempDir(),
+		SegmentDurationMs: 60000,
+	}
+
+	timeline := NewTimeline()
+	audio, err := NewTrackRecorder(conf, timeline, "ingressID", types.Audio, webrtc.MimeTypeOpus)
+	require.NoError(t, err)
+	video, err := NewTrackRecorder(conf, timeline, "ingressID", types.Video, webrtc.MimeTypeVP8)
+	require.NoError(t, err)
+
+	start := 10 * time.Second
+	audio.WriteSample(&media.Sample{Data: []byte{0xfc, 0x01}, Duration: 20 * time.Millisecond}, start)
+	// Jitter
+	audio.WriteSample(&media.Sample{Data: []byte{0xfc, 0x01}, Duration: 20 * time.Millisecond}, start+22*time.Millisecond)
+	// Gap
+	audio.WriteSample(&media.Sample{Data: []byte{0xfc, 0x01}, Duration: 20 * time.Millisecond}, start+time.Second/2)
+	audio.Close()
+
+	// The video starts half a second after the audio
+	video.WriteSample(&media.Sample{Data: []byte{0x00, 0x00, 0x00, 0x9d, 0x01, 0x2a, 0x80, 0x02, 0x68, 0x01}, Duration: 33 * time.Millisecond}, start+time.Second/2)
+	video.Close()
+
+	dir := path.Join(conf.Directory, "ingressID")
+	f, err := mp4.ReadMP4File(path.Join(dir, "audio_000000.mp4"))
+	require.NoError(t, err)
+	fragments := f.Segments[0].Fragments
+	require.Len(t, fragments, 2)
+	require.Equal(t, uint64(0), fragments[0].Moof.Traf.Tfdt.BaseMediaDecodeTime())
+	require.Equal(t, uint32(2), fragments[0].Moof.Traf.Trun.SampleCount())
+	require.Equal(t, uint64(audioTimescale/2), fragments[1].Moof.Traf.Tfdt.BaseMediaDecodeTime())
+
+	f, err = mp4.ReadMP4File(path.Join(dir, "video_000000.mp4"))
+	require.NoError(t, err)
+	require.Equal(t, uint64(videoTimescale/2), f.Segments[0].Fragments[0].Moof.Traf.Tfdt.BaseMediaDecodeTime())
+}
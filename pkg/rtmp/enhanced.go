@@ -0,0 +1,86 @@
+package rtmp
+
+import (
+	"io"
+
+	"github.com/livekit/psrpc"
+)
+
+// Enhanced RTMP extended video tag header, https://github.com/veovera/enhanced-rtmp
+
+const (
+	exHeaderFlag      = 0x80
+	exFrameTypeMask   = 0x70
+	exPacketTypeMask  = 0x0f
+	exFrameTypeOffset = 4
+	fourCCSize        = 4
+
+	exFrameTypeKeyFrame = 1
+)
+
+type ExVideoPacketType uint8
+
+const (
+	ExVideoPacketTypeSequenceStart        ExVideoPacketType = 0
+	ExVideoPacketTypeCodedFrames          ExVideoPacketType = 1
+	ExVideoPacketTypeSequenceEnd          ExVideoPacketType = 2
+	ExVideoPacketTypeCodedFramesX         ExVideoPacketType = 3
+	ExVideoPacketTypeMetadata             ExVideoPacketType = 4
+	ExVideoPacketTypeMPEG2TSSequenceStart ExVideoPacketType = 5
+)
+
+const (
+	FourCCHEVC = "hvc1"
+	FourCCAV1  = "av01"
+	FourCCVP9  = "vp09"
+)
+
+type ExVideoTagHeader struct {
+	FrameType  uint8
+	PacketType ExVideoPacketType
+	FourCC     string
+}
+
+func (h *ExVideoTagHeader) IsKeyFrame() bool {
+	return h.FrameType == exFrameTypeKeyFrame
+}
+
+func (h *ExVideoTagHeader) IsSequenceStart() bool {
+	return h.PacketType == ExVideoPacketTypeSequenceStart
+}
+
+// IsExVideoTagHeader returns true if the first byte of an FLV video tag signals an Enhanced RTMP header
+func IsExVideoTagHeader(b byte) bool {
+	return b&exHeaderFlag != 0
+}
+
+// ParseExVideoTagHeader parses the Enhanced RTMP extended video tag header at the beginning of the payload.
+// The header is not consumed, so that the payload can be forwarded unchanged.
+func ParseExVideoTagHeader(payload []byte) (*ExVideoTagHeader, error) {
+	if len(payload) < 1+fourCCSize {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	if !IsExVideoTagHeader(payload[0]) {
+		return nil, psrpc.NewErrorf(psrpc.InvalidArgument, "not an extended video tag header")
+	}
+
+	h := &ExVideoTagHeader{
+		FrameType:  (payload[0] & exFrameTypeMask) >> exFrameTypeOffset,
+		PacketType: ExVideoPacketType(payload[0] & exPacketTypeMask),
+		FourCC:     string(payload[1 : 1+fourCCSize]),
+	}
+
+	// Multitrack and ModEx packets are not supported
+	if h.PacketType > ExVideoPacketTypeMPEG2TSSequenceStart {
+		return nil, psrpc.NewErrorf(psrpc.NotAcceptable, "unsupported video packet type %d", h.PacketType)
+	}
+
+	switch h.FourCC {
+	case FourCCHEVC, FourCCAV1, FourCCVP9:
+	default:
+		return nil, psrpc.NewErrorf(psrpc.NotAcceptable, "unsupported video codec %q", h.FourCC)
+	}
+
+	return h, nil
+}
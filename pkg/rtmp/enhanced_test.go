@@ -0,0 +1,36 @@
+package rtmp
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseExVideoTagHeader(t *testing.T) {
+	// HEVC sequence start on a key frame
+	h, err := ParseExVideoTagHeader([]byte{0x90, 'h', 'v', 'c', '1', 0x01})
+	require.NoError(t, err)
+	require.Equal(t, FourCCHEVC, h.FourCC)
+	require.True(t, h.IsKeyFrame())
+	require.True(t, h.IsSequenceStart())
+
+	// AV1 coded frames on an inter frame
+	h, err = ParseExVideoTagHeader([]byte{0xa1, 'a', 'v', '0', '1', 0x00, 0x00, 0x00})
+	require.NoError(t, err)
+	require.Equal(t, FourCCAV1, h.FourCC)
+	require.False(t, h.IsKeyFrame())
+	require.Equal(t, ExVideoPacketTypeCodedFrames, h.PacketType)
+
+	// Legacy AVC tag
+	require.False(t, IsExVideoTagHeader(0x17))
+	_, err = ParseExVideoTagHeader([]byte{0x17, 0x00, 0x00, 0x00, 0x00})
+	require.Error(t, err)
+
+	// Unknown FourCC
+	_, err = ParseExVideoTagHeader([]byte{0x90, 'a', 'b', 'c', 'd'})
+	require.Error(t, err)
+
+	// Truncated
+	_, err = ParseExVideoTagHeader([]byte{0x90, 'h', 'v'})
+	require.Error(t, err)
+}
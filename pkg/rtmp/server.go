@@ -2,6 +2,7 @@ package rtmp
 
 import (
 	"bytes"
+	"crypto/tls"
 	"fmt"
 	"io"
 	"net"
@@ -21,29 +22,17 @@ import (
 )
 
 type RTMPServer struct {
-	server   *rtmp.Server
-	handlers sync.Map
+	servers   []*rtmp.Server
+	listeners []net.Listener
+	handlers  sync.Map
 }
 
 func NewRTMPServer() *RTMPServer {
 	return &RTMPServer{}
 }
 
-func (s *RTMPServer) Start(conf *config.Config, onPublish func(streamKey string) error) error {
-	port := conf.RTMPPort
-
-	tcpAddr, err := net.ResolveTCPAddr("tcp", fmt.Sprintf(":%d", port))
-	if err != nil {
-		return err
-	}
-
-	listener, err := net.ListenTCP("tcp", tcpAddr)
-	if err != nil {
-		logger.Errorw("failed to start TCP listener", err, "port", port)
-		return err
-	}
-
-	srv := rtmp.NewServer(&rtmp.ServerConfig{
+func (s *RTMPServer) Start(conf *config.Config, onPublish func(streamKey, remoteAddr string) error) error {
+	serverConfig := &rtmp.ServerConfig{
 		OnConnect: func(conn net.Conn) (io.ReadWriteCloser, *rtmp.ConnConfig) {
 			// Should we find a way to use our own logger?
 			l := log.StandardLogger()
@@ -52,10 +41,17 @@ func (s *RTMPServer) Start(conf *config.Config, onPublish func(streamKey string)
 			}
 			lf := l.WithFields(conf.GetLoggerFields())
 
+			remoteAddr := conn.RemoteAddr().String()
+
 			h := NewRTMPHandler()
 			h.OnPublishCallback(func(streamKey string) error {
+				if _, ok := s.handlers.Load(streamKey); ok {
+					// Another publisher is already connected on this stream key
+					return errors.ErrStreamKeyInUse
+				}
+
 				if onPublish != nil {
-					err := onPublish(streamKey)
+					err := onPublish(streamKey, remoteAddr)
 					if err != nil {
 						return err
 					}
@@ -79,16 +75,68 @@ func (s *RTMPServer) Start(conf *config.Config, onPublish func(streamKey string)
 				Logger: lf,
 			}
 		},
-	})
+	}
+
+	if conf.RTMPPort > 0 {
+		listener, err := listenTCP(conf.RTMPPort)
+		if err != nil {
+			return err
+		}
+
+		s.serve(rtmp.NewServer(serverConfig), listener)
+	}
+
+	if conf.RTMPSPort > 0 {
+		listener, err := listenTCP(conf.RTMPSPort)
+		if err != nil {
+			// Do not leave the plain RTMP listener open
+			_ = s.Stop()
+			return err
+		}
+
+		certReloader, err := utils.NewCertificateReloader(conf.RTMPSCertFile, conf.RTMPSKeyFile)
+		if err != nil {
+			logger.Errorw("failed to load RTMPS certificate", err, "certFile", conf.RTMPSCertFile, "keyFile", conf.RTMPSKeyFile)
+			listener.Close()
+			_ = s.Stop()
+			return err
+		}
+
+		tlsListener := tls.NewListener(listener, &tls.Config{
+			GetCertificate: certReloader.GetCertificate,
+			MinVersion:     tls.VersionTLS12,
+		})
+
+		s.serve(rtmp.NewServer(serverConfig), tlsListener)
+	}
+
+	return nil
+}
+
+func (s *RTMPServer) serve(srv *rtmp.Server, listener net.Listener) {
+	s.servers = append(s.servers, srv)
+	s.listeners = append(s.listeners, listener)
 
-	s.server = srv
 	go func() {
 		if err := srv.Serve(listener); err != nil {
-			logger.Errorw("failed to start RTMP server", err)
+			logger.Errorw("failed to start RTMP server", err, "addr", listener.Addr().String())
 		}
 	}()
+}
 
-	return nil
+func listenTCP(port int) (net.Listener, error) {
+	tcpAddr, err := net.ResolveTCPAddr("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return nil, err
+	}
+
+	listener, err := net.ListenTCP("tcp", tcpAddr)
+	if err != nil {
+		logger.Errorw("failed to start TCP listener", err, "port", port)
+		return nil, err
+	}
+
+	return listener, nil
 }
 
 func (s *RTMPServer) AssociateRelay(streamKey string, w io.WriteCloser) error {
@@ -105,6 +153,12 @@ func (s *RTMPServer) AssociateRelay(streamKey string, w io.WriteCloser) error {
 	return nil
 }
 
+// IsPublishing returns true if a publisher is connected with the stream key
+func (s *RTMPServer) IsPublishing(streamKey string) bool {
+	_, ok := s.handlers.Load(streamKey)
+	return ok
+}
+
 func (s *RTMPServer) DissociateRelay(streamKey string) error {
 	h, ok := s.handlers.Load(streamKey)
 	if ok && h != nil {
@@ -120,7 +174,18 @@ func (s *RTMPServer) DissociateRelay(streamKey string) error {
 }
 
 func (s *RTMPServer) Stop() error {
-	return s.server.Close()
+	var err error
+	for _, srv := range s.servers {
+		if e := srv.Close(); e != nil {
+			err = e
+		}
+	}
+	// The server only closes its listener once serving
+	for _, l := range s.listeners {
+		_ = l.Close()
+	}
+
+	return err
 }
 
 type RTMPHandler struct {
@@ -254,23 +319,50 @@ func (h *RTMPHandler) OnVideo(timestamp uint32, payload io.Reader) error {
 		}
 	}
 
-	var video flvtag.VideoData
-	if err := flvtag.DecodeVideoData(payload, &video); err != nil {
+	body, err := io.ReadAll(payload)
+	if err != nil {
 		return err
 	}
+	if len(body) == 0 {
+		return nil
+	}
 
-	flvBody := new(bytes.Buffer)
-	if _, err := io.Copy(flvBody, video.Data); err != nil {
-		return err
+	var video flvtag.VideoData
+	var exHeader *ExVideoTagHeader
+	if IsExVideoTagHeader(body[0]) {
+		// Enhanced RTMP. Keep the header bytes as is so that the tag can be forwarded unchanged
+		exHeader, err = ParseExVideoTagHeader(body)
+		if err != nil {
+			return err
+		}
+
+		video.FrameType = flvtag.FrameType(body[0] >> 4)
+		video.CodecID = flvtag.CodecID(body[0] & 0x0f)
+		video.Data = bytes.NewBuffer(body[1:])
+	} else {
+		if err := flvtag.DecodeVideoData(bytes.NewReader(body), &video); err != nil {
+			return err
+		}
+
+		flvBody := new(bytes.Buffer)
+		if _, err := io.Copy(flvBody, video.Data); err != nil {
+			return err
+		}
+		video.Data = flvBody
 	}
-	video.Data = flvBody
 
-	if h.videoInit == nil {
+	switch {
+	case exHeader != nil:
+		if exHeader.IsSequenceStart() {
+			h.log.Infow("received enhanced RTMP sequence start", "fourCC", exHeader.FourCC)
+			h.videoInit = copyVideoTag(&video)
+		}
+	case h.videoInit == nil:
 		h.videoInit = copyVideoTag(&video)
 	}
 
 	if !h.keyFrameFound {
-		if video.FrameType == flvtag.FrameTypeKeyFrame {
+		if isKeyFrame(&video, exHeader) {
 			h.log.Infow("key frame found")
 			h.keyFrameFound = true
 		} else {
@@ -338,6 +430,14 @@ func (h *RTMPHandler) initFlvEncoder() error {
 	return nil
 }
 
+func isKeyFrame(video *flvtag.VideoData, exHeader *ExVideoTagHeader) bool {
+	if exHeader != nil {
+		return exHeader.IsKeyFrame()
+	}
+
+	return video.FrameType == flvtag.FrameTypeKeyFrame
+}
+
 func copyVideoTag(in *flvtag.VideoData) *flvtag.VideoData {
 	ret := *in
 	ret.Data = bytes.NewBuffer(in.Data.(*bytes.Buffer).Bytes())
@@ -0,0 +1,185 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protojson"
+
+	"github.com/livekit/ingress/pkg/errors"
+	"github.com/livekit/ingress/pkg/stats"
+	"github.com/livekit/ingress/pkg/types"
+	"github.com/livekit/protocol/auth"
+	"github.com/livekit/protocol/livekit"
+	"github.com/livekit/psrpc"
+)
+
+const adminSessionsPath = "/admin/sessions"
+
+// sessionInfo describes an ingress session running on this node, as listed by the admin API
+type sessionInfo struct {
+	IngressID           string    `json:"ingress_id"`
+	InputType           string    `json:"input_type"`
+	RoomName            string    `json:"room_name"`
+	ParticipantIdentity string    `json:"participant_identity"`
+	BypassTranscoding   bool      `json:"bypass_transcoding"`
+	RemoteAddr          string    `json:"remote_addr,omitempty"`
+	Status              string    `json:"status"`
+	Error               string    `json:"error,omitempty"`
+	VideoCodec          string    `json:"video_codec,omitempty"`
+	Width               uint32    `json:"width,omitempty"`
+	Height              uint32    `json:"height,omitempty"`
+	Framerate           uint32    `json:"framerate,omitempty"`
+	AudioCodec          string    `json:"audio_codec,omitempty"`
+	AudioChannels       uint32    `json:"audio_channels,omitempty"`
+	AudioSampleRate     uint32    `json:"audio_sample_rate,omitempty"`
+	InputBitrate        uint64    `json:"input_bitrate"`
+	StartedAt           time.Time `json:"started_at"`
+	UptimeSeconds       int64     `json:"uptime_seconds"`
+	HandlerPID          int       `json:"handler_pid,omitempty"`
+
+	Inputs  []*stats.InputReport  `json:"inputs,omitempty"`
+	Outputs []*stats.OutputReport `json:"outputs,omitempty"`
+}
+
+// sessionInfo must be called with the lock of the process manager held
+func (h *process) sessionInfo() *sessionInfo {
+	si := &sessionInfo{
+		IngressID:           h.info.IngressId,
+		InputType:           types.InputTypeName(h.info.InputType),
+		RoomName:            h.info.RoomName,
+		ParticipantIdentity: h.info.ParticipantIdentity,
+		BypassTranscoding:   h.info.BypassTranscoding,
+		RemoteAddr:          h.remoteAddr,
+		InputBitrate:        h.inputBitrate,
+		StartedAt:           h.startedAt,
+		UptimeSeconds:       int64(time.Since(h.startedAt).Seconds()),
+		Inputs:              h.inputs,
+		Outputs:             h.outputs,
+	}
+	if h.running != nil {
+		si.HandlerPID = h.running.Pid
+	}
+
+	if state := h.state; state != nil {
+		si.Status = state.Status.String()
+		if h.reconnecting {
+			si.Status = "ENDPOINT_RECONNECTING"
+		}
+		si.Error = state.Error
+		if video := state.Video; video != nil {
+			si.VideoCodec = video.MimeType
+			si.Width = video.Width
+			si.Height = video.Height
+			si.Framerate = video.Framerate
+		}
+		if audio := state.Audio; audio != nil {
+			si.AudioCodec = audio.MimeType
+			si.AudioChannels = audio.Channels
+			si.AudioSampleRate = audio.SampleRate
+		}
+	}
+
+	return si
+}
+
+// AdminHandler serves the admin API, authenticated with a token signed with the API key and secret, granting ingressAdmin.
+//
+//	GET    /admin/sessions               lists the sessions running on this node
+//	DELETE /admin/sessions/<ingress ID>  ends a session
+func (s *Service) AdminHandler(w http.ResponseWriter, r *http.Request) {
+	if err := s.authenticateAdmin(r); err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+
+	switch {
+	case r.URL.Path == adminSessionsPath && r.Method == http.MethodGet:
+		sessions := s.manager.listSessions()
+		sort.Slice(sessions, func(i, j int) bool {
+			return sessions[i].StartedAt.Before(sessions[j].StartedAt)
+		})
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{"sessions": sessions})
+
+	case path.Dir(r.URL.Path) == adminSessionsPath && r.Method == http.MethodDelete:
+		if err := s.manager.killIngress(path.Base(r.URL.Path)); err != nil {
+			writeHTTPError(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusAccepted)
+
+	case r.URL.Path == adminSessionsPath || path.Dir(r.URL.Path) == adminSessionsPath:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (s *Service) authenticateAdmin(r *http.Request) error {
+	authHeader := r.Header.Get("Authorization")
+	token := strings.TrimPrefix(authHeader, "Bearer ")
+	if token == "" || token == authHeader {
+		return errors.ErrInvalidAdminToken
+	}
+
+	v, err := auth.ParseAPIToken(token)
+	if err != nil || v.APIKey() != s.conf.ApiKey {
+		return errors.ErrInvalidAdminToken
+	}
+
+	claims, err := v.Verify(s.conf.ApiSecret)
+	if err != nil {
+		return errors.ErrInvalidAdminToken
+	}
+	if claims.Video == nil || !claims.Video.IngressAdmin {
+		return errors.ErrAdminPermissionDenied
+	}
+
+	return nil
+}
+
+// ReportHandler receives the reports of the handler processes. The ingress ID is the last element of the path
+func (s *Service) ReportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	report := &stats.HandlerReport{}
+	if err := json.NewDecoder(r.Body).Decode(report); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	state := &livekit.IngressState{}
+	if err := protojson.Unmarshal(report.State, state); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ingressID := path.Base(r.URL.Path)
+	if err := s.manager.updateReport(ingressID, state, report); err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	s.monitor.UpdateSessionMetrics(ingressID, report)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeHTTPError(w http.ResponseWriter, err error) {
+	var psrpcErr psrpc.Error
+	if errors.As(err, &psrpcErr) {
+		http.Error(w, psrpcErr.Error(), psrpcErr.ToHttp())
+	} else {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
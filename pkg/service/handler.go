@@ -1,8 +1,15 @@
 package service
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"sync"
+	"time"
 
+	"google.golang.org/protobuf/encoding/protojson"
 	google_protobuf2 "google.golang.org/protobuf/types/known/emptypb"
 
 	"github.com/frostbyte73/core"
@@ -10,40 +17,58 @@ import (
 	"github.com/livekit/ingress/pkg/errors"
 	"github.com/livekit/ingress/pkg/media"
 	"github.com/livekit/ingress/pkg/params"
+	"github.com/livekit/ingress/pkg/stats"
+	"github.com/livekit/ingress/pkg/types"
 	"github.com/livekit/protocol/livekit"
 	"github.com/livekit/protocol/logger"
 	"github.com/livekit/protocol/rpc"
 	"github.com/livekit/protocol/tracer"
 )
 
+const (
+	reportInterval = 5 * time.Second
+	reportTimeout  = 2 * time.Second
+	minAudioLevel  = -127 // dBFS
+)
+
 type Handler struct {
 	conf      *config.Config
 	pipeline  *media.Pipeline
 	rpcClient rpc.IOInfoClient
 	kill      core.Fuse
 	done      core.Fuse
+
+	reportClient *http.Client
+	reportLock   sync.Mutex
+	inputBitrate uint64
+	inputs       []*stats.InputReport
+	outputs      []*stats.OutputReport
 }
 
 func NewHandler(conf *config.Config, rpcClient rpc.IOInfoClient) *Handler {
 	return &Handler{
-		conf:      conf,
-		rpcClient: rpcClient,
-		kill:      core.NewFuse(),
-		done:      core.NewFuse(),
+		conf:         conf,
+		rpcClient:    rpcClient,
+		kill:         core.NewFuse(),
+		done:         core.NewFuse(),
+		reportClient: params.NewRelayClient(conf, reportTimeout),
 	}
 }
 
-func (h *Handler) HandleIngress(ctx context.Context, info *livekit.IngressInfo, wsUrl, token string, extraParams any) {
+// HandleIngress runs the ingress until it ends. Returns an error if it failed, after reporting it
+func (h *Handler) HandleIngress(ctx context.Context, info *livekit.IngressInfo, wsUrl, token string, extraParams any) error {
 	ctx, span := tracer.Start(ctx, "Handler.HandleRequest")
 	defer span.End()
 
 	p, err := h.buildPipeline(ctx, info, wsUrl, token, extraParams)
 	if err != nil {
 		span.RecordError(err)
-		return
+		return err
 	}
 	h.pipeline = p
 
+	go h.runReports(ctx)
+
 	// start ingress
 	result := make(chan *livekit.IngressInfo, 1)
 	go func() {
@@ -63,7 +88,10 @@ func (h *Handler) HandleIngress(ctx context.Context, info *livekit.IngressInfo,
 		case res := <-result:
 			// ingress finished
 			h.sendUpdate(ctx, res)
-			return
+			if res.State.Status == livekit.IngressState_ENDPOINT_ERROR {
+				return errors.New(res.State.Error)
+			}
+			return nil
 		}
 	}
 }
@@ -74,7 +102,7 @@ func (h *Handler) killAndReturnState(ctx context.Context) (*livekit.IngressState
 	case <-ctx.Done():
 		return nil, ctx.Err()
 	case <-h.done.Watch():
-		return h.pipeline.State, nil
+		return h.pipeline.GetInfo().State, nil
 	}
 }
 
@@ -145,6 +173,164 @@ func (h *Handler) sendUpdate(ctx context.Context, info *livekit.IngressInfo) {
 	if err != nil {
 		logger.Errorw("failed to send update", err)
 	}
+
+	h.sendReport(ctx)
+}
+
+// runReports periodically reports the state of the session to the service, until the pipeline ends
+func (h *Handler) runReports(ctx context.Context) {
+	ticker := time.NewTicker(reportInterval)
+	defer ticker.Stop()
+
+	lastBytes := h.pipeline.GetInputBytes()
+	lastInputs := h.pipeline.GetInputStats()
+	lastOutputs := h.pipeline.GetOutputStats()
+	lastTime := time.Now()
+
+	for {
+		select {
+		case <-h.done.Watch():
+			return
+		case now := <-ticker.C:
+			inputBytes := h.pipeline.GetInputBytes()
+			inputs := h.pipeline.GetInputStats()
+			outputs := h.pipeline.GetOutputStats()
+
+			if elapsed := now.Sub(lastTime).Seconds(); elapsed > 0 {
+				inputReports := getInputReports(lastInputs, inputs, elapsed)
+
+				h.reportLock.Lock()
+				h.inputBitrate = uint64(float64(inputBytes-lastBytes) * 8 / elapsed)
+				h.inputs = inputReports
+				h.outputs = getOutputReports(lastOutputs, outputs, elapsed)
+				h.reportLock.Unlock()
+
+				h.updateVideoState(ctx, inputReports)
+			}
+			lastBytes = inputBytes
+			lastInputs = inputs
+			lastOutputs = outputs
+			lastTime = now
+
+			h.sendReport(ctx)
+		}
+	}
+}
+
+// updateVideoState reports changes of the resolution or frame rate sent by the publisher to the control plane
+func (h *Handler) updateVideoState(ctx context.Context, inputs []*stats.InputReport) {
+	for _, r := range inputs {
+		if r.Kind == types.Video && h.pipeline.UpdateVideoState(r.Width, r.Height, r.FrameRate) {
+			h.sendUpdate(ctx, h.pipeline.GetInfo())
+		}
+	}
+}
+
+func getInputReports(last, current []*media.InputTrackStats, elapsed float64) []*stats.InputReport {
+	reports := make([]*stats.InputReport, 0, len(current))
+	for _, st := range current {
+		var lastBytes, lastFrames uint64
+		for _, l := range last {
+			if l.Kind == st.Kind {
+				lastBytes, lastFrames = l.Bytes, l.Frames
+			}
+		}
+
+		r := &stats.InputReport{
+			Kind:          string(st.Kind),
+			Bitrate:       uint64(float64(st.Bytes-lastBytes) * 8 / elapsed),
+			TimestampGaps: st.TimestampGaps,
+		}
+		if st.Kind == types.Video {
+			// Every audio frame is a key frame
+			r.Width = st.Width
+			r.Height = st.Height
+			r.FrameRate = float64(st.Frames-lastFrames) / elapsed
+			r.KeyFrameInterval = st.KeyFrameInterval.Seconds()
+		}
+		reports = append(reports, r)
+	}
+
+	return reports
+}
+
+func getOutputReports(last, current []*media.OutputTrackStats, elapsed float64) []*stats.OutputReport {
+	reports := make([]*stats.OutputReport, 0, len(current))
+	for _, st := range current {
+		var lastBytes, lastSamples uint64
+		var lastSumSquares float64
+		for _, l := range last {
+			if l.Kind == st.Kind && l.Layer == st.Layer {
+				lastBytes, lastSamples, lastSumSquares = l.Bytes, l.AudioSamples, l.AudioSumSquares
+			}
+		}
+
+		r := &stats.OutputReport{
+			Kind:           string(st.Kind),
+			Layer:          st.Layer,
+			Bitrate:        uint64(float64(st.Bytes-lastBytes) * 8 / elapsed),
+			SamplesDelayed: st.SamplesDelayed,
+			EncoderLatency: st.EncoderLatency.Seconds(),
+		}
+		if st.Kind == types.Audio && st.AudioSamples > lastSamples {
+			r.AudioLevel = getAudioLevel((st.AudioSumSquares - lastSumSquares) / float64(st.AudioSamples-lastSamples))
+		}
+		reports = append(reports, r)
+	}
+
+	return reports
+}
+
+// getAudioLevel returns the level in dBFS of audio with the given mean of the normalized samples squared
+func getAudioLevel(meanSquare float64) float64 {
+	if meanSquare <= 0 {
+		return minAudioLevel
+	}
+
+	return math.Max(10*math.Log10(meanSquare), minAudioLevel)
+}
+
+func (h *Handler) sendReport(ctx context.Context) {
+	if h.pipeline == nil {
+		return
+	}
+
+	state, err := protojson.Marshal(h.pipeline.GetInfo().State)
+	if err != nil {
+		logger.Errorw("failed to marshal state", err)
+		return
+	}
+
+	h.reportLock.Lock()
+	report := &stats.HandlerReport{
+		State:        state,
+		InputBitrate: h.inputBitrate,
+		Reconnecting: h.pipeline.IsReconnecting(),
+		Inputs:       h.inputs,
+		Outputs:      h.outputs,
+	}
+	h.reportLock.Unlock()
+
+	b, err := json.Marshal(report)
+	if err != nil {
+		logger.Errorw("failed to marshal report", err)
+		return
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.pipeline.ReportUrl, bytes.NewReader(b))
+	if err != nil {
+		logger.Errorw("failed to create report request", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := h.reportClient.Do(req)
+	if err != nil {
+		// The service may be restarting, the next report will catch up
+		logger.Debugw("failed to send report", "error", err)
+		return
+	}
+	resp.Body.Close()
 }
 
 func (h *Handler) Kill() {
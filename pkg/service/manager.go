@@ -7,12 +7,16 @@ import (
 	"os/exec"
 	"sync"
 	"syscall"
+	"time"
 
 	"google.golang.org/protobuf/encoding/protojson"
 	"gopkg.in/yaml.v3"
 
 	"github.com/frostbyte73/core"
+	"github.com/livekit/ingress/pkg/cgroup"
 	"github.com/livekit/ingress/pkg/config"
+	"github.com/livekit/ingress/pkg/errors"
+	"github.com/livekit/ingress/pkg/params"
 	"github.com/livekit/ingress/pkg/stats"
 	"github.com/livekit/protocol/livekit"
 	"github.com/livekit/protocol/logger"
@@ -20,19 +24,98 @@ import (
 	"github.com/livekit/protocol/tracer"
 )
 
+const (
+	// HandlerExitCodePipelineError is the exit code of a handler whose ingress failed. The handler reports the error itself
+	HandlerExitCodePipelineError = 3
+	// HandlerExitCodeSetupError is the exit code of a handler that could not be set up, e.g. with invalid arguments.
+	// It is neither restarted nor counted as a crash
+	HandlerExitCodeSetupError = 4
+
+	handlerRestartBackoff    = time.Second
+	maxHandlerRestartBackoff = 30 * time.Second
+)
+
+// handlerExit classifies how a handler process ended
+type handlerExit int
+
+const (
+	handlerExitClean handlerExit = iota
+	handlerExitPipelineError
+	handlerExitSetupError
+	// the handler crashed, was killed by a signal, or could not be launched
+	handlerExitCrash
+)
+
+func (e handlerExit) String() string {
+	switch e {
+	case handlerExitClean:
+		return "clean"
+	case handlerExitPipelineError:
+		return "pipeline_error"
+	case handlerExitSetupError:
+		return "setup_error"
+	default:
+		return "crash"
+	}
+}
+
+func classifyExit(err error) handlerExit {
+	if err == nil {
+		return handlerExitClean
+	}
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		switch exitErr.ExitCode() {
+		case HandlerExitCodePipelineError:
+			return handlerExitPipelineError
+		case HandlerExitCodeSetupError:
+			return handlerExitSetupError
+		}
+	}
+
+	// Includes the exits caused by a signal, for which ExitCode returns -1
+	return handlerExitCrash
+}
+
 type process struct {
-	info   *livekit.IngressInfo
-	cmd    *exec.Cmd
-	closed core.Fuse
+	resp        *rpc.GetIngressInfoResponse
+	info        *livekit.IngressInfo
+	extraParams any
+	remoteAddr  string
+	startedAt   time.Time
+	cmd         *exec.Cmd
+	running     *os.Process   // the started process of cmd, nil until it is started
+	group       *cgroup.Group // nil if the handler resources are not limited
+	restarts    int
+	kill        core.Fuse
+	closed      core.Fuse
+
+	// last sampled from the cgroup
+	cpuTime   time.Duration
+	sampledAt time.Time
+
+	// last reported by the handler
+	state        *livekit.IngressState
+	reconnecting bool
+	inputBitrate uint64
+	inputs       []*stats.InputReport
+	outputs      []*stats.OutputReport
 }
 
 type ProcessManager struct {
 	conf    *config.Config
 	monitor *stats.Monitor
 
+	cgroups *cgroup.Controller
+
 	mu             sync.RWMutex
 	activeHandlers map[string]*process
-	onFatal        func(info *livekit.IngressInfo, err error)
+	crashes        []time.Time
+
+	isPublishing func(info *livekit.IngressInfo, extraParams any) bool
+	onCrash      func(info *livekit.IngressInfo, state *livekit.IngressState, err error)
+	onUnhealthy  func()
 }
 
 func NewProcessManager(conf *config.Config, monitor *stats.Monitor) *ProcessManager {
@@ -43,34 +126,168 @@ func NewProcessManager(conf *config.Config, monitor *stats.Monitor) *ProcessMana
 	}
 }
 
-func (s *ProcessManager) onFatalError(f func(info *livekit.IngressInfo, err error)) {
-	s.onFatal = f
+// limitResources places each handler launched afterwards in its own cgroup, limited to the CPU cost of its ingress type
+func (s *ProcessManager) limitResources(c *cgroup.Controller) {
+	s.cgroups = c
+}
+
+// onPublisherCheck registers the function telling if the publisher of an ingress is still connected, for its handler
+// to be restarted after a crash
+func (s *ProcessManager) onPublisherCheck(f func(info *livekit.IngressInfo, extraParams any) bool) {
+	s.isPublishing = f
 }
 
-func (s *ProcessManager) launchHandler(ctx context.Context, resp *rpc.GetIngressInfoResponse, extraParams any) {
+// onHandlerCrash registers a callback called when a crashed handler is not restarted
+func (s *ProcessManager) onHandlerCrash(f func(info *livekit.IngressInfo, state *livekit.IngressState, err error)) {
+	s.onCrash = f
+}
+
+// onRepeatedCrashes registers a callback called when handlers crash too often for the node to be considered healthy
+func (s *ProcessManager) onRepeatedCrashes(f func()) {
+	s.onUnhealthy = f
+}
+
+// reserve registers the process of an ingress before its publisher is replied to, so that the publishers of the ingress
+// connecting until its handler is launched see it as active. If a process is already registered for the ingress, the
+// publisher is attached to it if canAttach is set, or rejected if exclusive is set. The process is replaced otherwise
+func (s *ProcessManager) reserve(resp *rpc.GetIngressInfoResponse, resourceId string, canAttach bool, exclusive bool) (*process, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if existing, ok := s.activeHandlers[resp.Info.IngressId]; ok {
+		if canAttach && existing.isRelaying(resourceId) {
+			return nil, true, nil
+		}
+		if exclusive {
+			return nil, false, errors.ErrStreamKeyInUse
+		}
+	}
+
+	h := &process{
+		resp:      resp,
+		info:      resp.Info,
+		startedAt: time.Now(),
+		kill:      core.NewFuse(),
+		closed:    core.NewFuse(),
+		state:     resp.Info.State,
+	}
+	if resourceId != "" {
+		h.extraParams = &params.WhipExtraParams{ResourceId: resourceId}
+	}
+	s.activeHandlers[resp.Info.IngressId] = h
+
+	return h, false, nil
+}
+
+// launchHandler starts the handler of a process registered with reserve
+func (s *ProcessManager) launchHandler(ctx context.Context, h *process, remoteAddr string, extraParams any) {
 	// TODO send update on failure
 	_, span := tracer.Start(ctx, "Service.launchHandler")
 	defer span.End()
 
-	confString, err := yaml.Marshal(s.conf)
+	cmd, err := s.newHandlerCommand(h.resp, extraParams)
 	if err != nil {
 		span.RecordError(err)
-		logger.Errorw("could not marshal config", err)
+		s.remove(h)
 		return
 	}
 
+	s.monitor.IngressStarted(h.info)
+
+	var group *cgroup.Group
+	if s.cgroups != nil {
+		group = s.newGroup(h.info)
+	}
+
+	s.mu.Lock()
+	h.extraParams = extraParams
+	h.remoteAddr = remoteAddr
+	h.cmd = cmd
+	h.group = group
+	s.mu.Unlock()
+
+	go s.supervise(h)
+}
+
+// remove unregisters the process of an ingress, unless it was replaced by a newer one
+func (s *ProcessManager) remove(h *process) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.activeHandlers[h.info.IngressId] == h {
+		delete(s.activeHandlers, h.info.IngressId)
+	}
+}
+
+// newGroup creates the cgroup of the handler of an ingress. The handler runs unconstrained if it cannot be created
+func (s *ProcessManager) newGroup(info *livekit.IngressInfo) *cgroup.Group {
+	// The quota is sized on the configured cost, including a backup publisher that may connect later. The handler cannot
+	// use more than its quota, so the headroom leaves room for the measured cost to exceed the configured one
+	cpus, _ := s.monitor.GetCPULimit(info)
+	cpus *= 1 + s.conf.CGroups.CPUHeadroom
+	memoryLimit := s.conf.CGroups.MemoryLimitMB << 20
+
+	g, err := s.cgroups.NewGroup("handler_"+info.IngressId, cpus, memoryLimit)
+	if err != nil {
+		logger.Errorw("could not create handler cgroup", err, "ingressID", info.IngressId)
+		return nil
+	}
+
+	return g
+}
+
+// runHandler runs the command of a handler in its cgroup
+func (s *ProcessManager) runHandler(h *process) error {
+	if h.group != nil {
+		// The handler is started in its cgroup, rather than moved to it once running. Failing to do so is a launch error
+		dir, err := h.group.Open()
+		if err != nil {
+			logger.Errorw("could not open handler cgroup", err, "ingressID", h.info.IngressId)
+			return err
+		}
+		defer dir.Close()
+
+		h.cmd.SysProcAttr = &syscall.SysProcAttr{
+			UseCgroupFD: true,
+			CgroupFD:    int(dir.Fd()),
+		}
+	}
+
+	if err := h.cmd.Start(); err != nil {
+		return err
+	}
+
+	// The process is published under the lock, for killIngress and the admin API
+	s.mu.Lock()
+	h.running = h.cmd.Process
+	killed := h.kill.IsBroken()
+	s.mu.Unlock()
+	if killed {
+		// Killed while being launched, the signal could not be sent to the process then
+		_ = h.cmd.Process.Signal(syscall.SIGINT)
+	}
+	s.monitor.HandlerStarted(h.info, h.cmd.Process.Pid)
+
+	return h.cmd.Wait()
+}
+
+func (s *ProcessManager) newHandlerCommand(resp *rpc.GetIngressInfoResponse, extraParams any) (*exec.Cmd, error) {
+	confString, err := yaml.Marshal(s.conf)
+	if err != nil {
+		logger.Errorw("could not marshal config", err)
+		return nil, err
+	}
+
 	infoString, err := protojson.Marshal(resp.Info)
 	if err != nil {
-		span.RecordError(err)
 		logger.Errorw("could not marshal request", err)
-		return
+		return nil, err
 	}
 
 	extraParamsString := ""
 	if extraParams != nil {
 		p, err := json.Marshal(extraParams)
 		if err != nil {
-			span.RecordError(err)
 			logger.Errorw("could not marshall extra parameters", err)
 		}
 		extraParamsString = string(p)
@@ -100,35 +317,112 @@ func (s *ProcessManager) launchHandler(ctx context.Context, resp *rpc.GetIngress
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 
-	s.monitor.IngressStarted(resp.Info)
-	h := &process{
-		info:   resp.Info,
-		cmd:    cmd,
-		closed: core.NewFuse(),
-	}
+	return cmd, nil
+}
 
-	s.mu.Lock()
-	s.activeHandlers[resp.Info.IngressId] = h
-	s.mu.Unlock()
+// supervise runs the handler of an ingress until it ends. A crashed handler is restarted while the publisher is connected
+func (s *ProcessManager) supervise(h *process) {
+	for {
+		err := s.runHandler(h)
+		exit := classifyExit(err)
+		s.monitor.HandlerExited(exit.String())
 
-	go s.awaitCleanup(h)
-}
+		if exit == handlerExitSetupError {
+			// The handler did not get to report the failure itself
+			logger.Warnw("handler could not be set up", err, "ingressID", h.info.IngressId)
+			if s.onCrash != nil {
+				s.onCrash(h.info, nil, errors.ErrHandlerSetupFailed)
+			}
+			break
+		}
+		if exit != handlerExitCrash {
+			if exit == handlerExitPipelineError {
+				logger.Infow("handler ended with an error", "ingressID", h.info.IngressId)
+			}
+			break
+		}
+
+		logger.Errorw("handler crashed", err, "ingressID", h.info.IngressId, "restarts", h.restarts)
+		s.recordCrash()
+
+		if !s.restart(h) {
+			if s.onCrash != nil {
+				s.mu.RLock()
+				state := h.state
+				s.mu.RUnlock()
 
-func (s *ProcessManager) awaitCleanup(h *process) {
-	if err := h.cmd.Run(); err != nil {
-		logger.Errorw("could not launch handler", err)
-		if s.onFatal != nil {
-			s.onFatal(h.info, err)
+				s.onCrash(h.info, state, errors.ErrHandlerCrashed)
+			}
+			break
 		}
 	}
 
 	h.closed.Break()
 	s.monitor.IngressEnded(h.info)
+	if h.group != nil {
+		if err := h.group.Remove(); err != nil {
+			logger.Warnw("could not remove handler cgroup", err, "ingressID", h.info.IngressId)
+		}
+	}
+
+	s.remove(h)
+}
+
+// restart waits for the backoff delay then replaces the command of a crashed handler. Returns false if the handler must not
+// be restarted
+func (s *ProcessManager) restart(h *process) bool {
+	if h.restarts >= s.conf.Supervision.MaxRestarts || h.kill.IsBroken() {
+		return false
+	}
+	if s.isPublishing == nil || !s.isPublishing(h.info, h.extraParams) {
+		return false
+	}
+
+	backoff := handlerRestartBackoff << h.restarts
+	if backoff > maxHandlerRestartBackoff {
+		backoff = maxHandlerRestartBackoff
+	}
+
+	select {
+	case <-h.kill.Watch():
+		return false
+	case <-time.After(backoff):
+	}
+
+	cmd, err := s.newHandlerCommand(h.resp, h.extraParams)
+	if err != nil {
+		return false
+	}
 
 	s.mu.Lock()
-	defer s.mu.Unlock()
+	h.cmd = cmd
+	h.running = nil
+	h.restarts++
+	s.mu.Unlock()
 
-	delete(s.activeHandlers, h.info.IngressId)
+	logger.Infow("restarting handler", "ingressID", h.info.IngressId, "restarts", h.restarts)
+	return true
+}
+
+// recordCrash calls the unhealthy callback when the crashes within the crash window reach the limit
+func (s *ProcessManager) recordCrash() {
+	now := time.Now()
+	window := time.Duration(s.conf.Supervision.CrashWindowSeconds) * time.Second
+
+	s.mu.Lock()
+	crashes := s.crashes[:0]
+	for _, t := range s.crashes {
+		if now.Sub(t) < window {
+			crashes = append(crashes, t)
+		}
+	}
+	s.crashes = append(crashes, now)
+	unhealthy := len(s.crashes) >= s.conf.Supervision.CrashLimit
+	s.mu.Unlock()
+
+	if unhealthy && s.onUnhealthy != nil {
+		s.onUnhealthy()
+	}
 }
 
 func (s *ProcessManager) isIdle() bool {
@@ -138,6 +432,25 @@ func (s *ProcessManager) isIdle() bool {
 	return len(s.activeHandlers) == 0
 }
 
+// isActive returns true if a handler is running for the ingress. For WHIP, the handler must also be relaying the given resource.
+func (s *ProcessManager) isActive(ingressID string, resourceId string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	h, ok := s.activeHandlers[ingressID]
+	return ok && h.isRelaying(resourceId)
+}
+
+// isRelaying returns true if the handler relays the given WHIP resource, or if no resource is given
+func (h *process) isRelaying(resourceId string) bool {
+	if resourceId == "" {
+		return true
+	}
+
+	ep, ok := h.extraParams.(*params.WhipExtraParams)
+	return ok && ep.ResourceId == resourceId
+}
+
 func (s *ProcessManager) listIngress() []string {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
@@ -149,13 +462,105 @@ func (s *ProcessManager) listIngress() []string {
 	return ingressIDs
 }
 
+// updateReport records the state of a session, as reported by its handler
+func (s *ProcessManager) updateReport(ingressID string, state *livekit.IngressState, report *stats.HandlerReport) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	h, ok := s.activeHandlers[ingressID]
+	if !ok {
+		return errors.ErrIngressNotFound
+	}
+
+	h.state = state
+	h.reconnecting = report.Reconnecting
+	h.inputBitrate = report.InputBitrate
+	h.inputs = report.Inputs
+	h.outputs = report.Outputs
+
+	return nil
+}
+
+// updateResourceMetrics samples the cgroups of the handlers and exports their limits and usage
+func (s *ProcessManager) updateResourceMetrics() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for ingressID, h := range s.activeHandlers {
+		if h.group == nil {
+			continue
+		}
+
+		usage, err := h.group.Usage()
+		if err != nil {
+			logger.Debugw("could not read handler cgroup usage", "error", err, "ingressID", ingressID)
+			continue
+		}
+
+		r := &stats.HandlerResources{
+			CPULimit:    h.group.CPULimit(),
+			MemoryLimit: h.group.MemoryLimit(),
+			MemoryUsage: usage.Memory,
+			OOMKills:    usage.OOMKills,
+		}
+		if !h.sampledAt.IsZero() {
+			r.CPUUsage = (usage.CPUTime - h.cpuTime).Seconds() / now.Sub(h.sampledAt).Seconds()
+		}
+		h.cpuTime = usage.CPUTime
+		h.sampledAt = now
+
+		s.monitor.UpdateHandlerResources(ingressID, r)
+	}
+}
+
+func (s *ProcessManager) listSessions() []*sessionInfo {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	sessions := make([]*sessionInfo, 0, len(s.activeHandlers))
+	for _, h := range s.activeHandlers {
+		sessions = append(sessions, h.sessionInfo())
+	}
+
+	return sessions
+}
+
+func (s *ProcessManager) killIngress(ingressID string) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	h, ok := s.activeHandlers[ingressID]
+	if !ok {
+		return errors.ErrIngressNotFound
+	}
+
+	if h.closed.IsBroken() {
+		return nil
+	}
+	h.kill.Break()
+
+	// The handler ends the ingress gracefully. A crashed handler waiting to be restarted is not restarted, and a handler
+	// still being launched is signaled by runHandler once started
+	if h.running == nil {
+		return nil
+	}
+	if err := h.running.Signal(syscall.SIGINT); err != nil && !errors.Is(err, os.ErrProcessDone) {
+		logger.Errorw("failed to kill process", err, "ingressID", ingressID)
+		return err
+	}
+
+	return nil
+}
+
 func (s *ProcessManager) killAll() {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
 	for _, h := range s.activeHandlers {
-		if !h.closed.IsBroken() {
-			if err := h.cmd.Process.Signal(syscall.SIGINT); err != nil {
+		h.kill.Break()
+		if !h.closed.IsBroken() && h.running != nil {
+			if err := h.running.Signal(syscall.SIGINT); err != nil && !errors.Is(err, os.ErrProcessDone) {
 				logger.Errorw("failed to kill process", err, "ingressID", h.info.IngressId)
 			}
 		}
@@ -1,55 +1,152 @@
 package service
 
 import (
-	"fmt"
+	"net"
 	"net/http"
+	"os"
+	"path/filepath"
+	"syscall"
 
 	"github.com/livekit/ingress/pkg/config"
+	"github.com/livekit/ingress/pkg/errors"
 	"github.com/livekit/ingress/pkg/rtmp"
+	"github.com/livekit/ingress/pkg/srt"
+	"github.com/livekit/ingress/pkg/udp"
+	"github.com/livekit/ingress/pkg/utils"
 	"github.com/livekit/ingress/pkg/whip"
 	"github.com/livekit/protocol/logger"
 )
 
 type Relay struct {
 	server     *http.Server
+	socket     string
+	tempDir    string // removed on stop, if the socket path was not configured
 	rtmpServer *rtmp.RTMPServer
 	whipServer *whip.WHIPServer
+	srtServer  *srt.SRTServer
+	udpServer  *udp.UDPServer
+	svc        *Service
 }
 
-func NewRelay(rtmpServer *rtmp.RTMPServer, whipServer *whip.WHIPServer) *Relay {
+func NewRelay(rtmpServer *rtmp.RTMPServer, whipServer *whip.WHIPServer, srtServer *srt.SRTServer, udpServer *udp.UDPServer, svc *Service) *Relay {
 	return &Relay{
 		rtmpServer: rtmpServer,
 		whipServer: whipServer,
+		srtServer:  srtServer,
+		udpServer:  udpServer,
+		svc:        svc,
 	}
 }
 
-func (r *Relay) Start(conf *config.Config) error {
-	port := conf.HTTPRelayPort
+// Start serves the relay on the configured socket, or on a socket in a new private temporary directory. The handler
+// processes are given the socket path with the config, the relay must be started before any of them is launched.
+// Stop must be called once done, the temporary directory is only removed on failure otherwise.
+func (r *Relay) Start(conf *config.Config) (err error) {
+	// Only the user running the service, and its handler processes, can connect to the relay
+	if conf.HTTPRelaySocket == "" {
+		// The name of the directory is random, it cannot be created beforehand by another user
+		r.tempDir, err = os.MkdirTemp("", "livekit-ingress-")
+		if err != nil {
+			return err
+		}
+		conf.HTTPRelaySocket = filepath.Join(r.tempDir, "relay.sock")
+
+		defer func() {
+			if err != nil {
+				r.removeTempDir()
+			}
+		}()
+	} else if err := checkSocketDir(filepath.Dir(conf.HTTPRelaySocket)); err != nil {
+		return err
+	}
+	r.socket = conf.HTTPRelaySocket
 
 	mux := http.NewServeMux()
 
 	if r.rtmpServer != nil {
-		h := rtmp.NewRTMPRelayHandler(r.rtmpServer)
-		mux.Handle("/rtmp/", h)
+		mux.Handle("/rtmp/", utils.NewStreamRelayHandler("/rtmp/", r.rtmpServer))
 	}
 	if r.whipServer != nil {
 		h := whip.NewWHIPRelayHandler(r.whipServer)
 		mux.Handle("/whip/", h)
+
+		wsh := whip.NewWebSocketRelayHandler(r.whipServer)
+		mux.Handle("/websocket/", wsh)
+	}
+	if r.srtServer != nil {
+		mux.Handle("/srt/", utils.NewStreamRelayHandler("/srt/", r.srtServer))
+	}
+	if r.udpServer != nil {
+		mux.Handle("/udp/", utils.NewStreamRelayHandler("/udp/", r.udpServer))
+	}
+	if r.svc != nil {
+		// State reports of the handler processes
+		mux.HandleFunc("/handler/", r.svc.ReportHandler)
+	}
+
+	if err := os.Remove(r.socket); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	l, err := net.Listen("unix", r.socket)
+	if err != nil {
+		return err
+	}
+	if err = os.Chmod(r.socket, 0600); err != nil {
+		_ = l.Close()
+		return err
 	}
 
 	r.server = &http.Server{
 		Handler: mux,
-		Addr:    fmt.Sprintf(":%d", port),
 	}
 
 	go func() {
-		err := r.server.ListenAndServe()
+		err := r.server.Serve(l)
 		logger.Debugw("Relay stopped", "error", err)
 	}()
 
 	return nil
 }
 
+// Stop closes the relay and removes its socket. It can be called more than once
 func (r *Relay) Stop() error {
-	return r.server.Close()
+	var err error
+	if r.server != nil {
+		err = r.server.Close()
+	}
+	if r.socket != "" {
+		_ = os.Remove(r.socket)
+	}
+	r.removeTempDir()
+
+	return err
+}
+
+func (r *Relay) removeTempDir() {
+	if r.tempDir != "" {
+		if err := os.RemoveAll(r.tempDir); err != nil {
+			logger.Warnw("could not remove relay directory", err, "dir", r.tempDir)
+		}
+		r.tempDir = ""
+	}
+}
+
+// checkSocketDir creates the directory of the socket if needed, and makes sure that it is owned by the user running the
+// service and not accessible to anyone else
+func checkSocketDir(dir string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !info.IsDir() || !ok || int(st.Uid) != os.Getuid() || info.Mode().Perm()&0077 != 0 {
+		return errors.ErrRelaySocketDirUnsafe
+	}
+
+	return nil
 }
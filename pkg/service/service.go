@@ -2,17 +2,24 @@ package service
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
+	"path"
+	"strings"
 	"time"
 
 	"github.com/frostbyte73/core"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/protobuf/encoding/protojson"
 
+	"github.com/livekit/ingress/pkg/cgroup"
 	"github.com/livekit/ingress/pkg/config"
 	"github.com/livekit/ingress/pkg/errors"
 	"github.com/livekit/ingress/pkg/params"
+	"github.com/livekit/ingress/pkg/rtmp"
 	"github.com/livekit/ingress/pkg/stats"
 	"github.com/livekit/ingress/pkg/types"
 	"github.com/livekit/ingress/pkg/whip"
@@ -25,23 +32,32 @@ import (
 	"github.com/livekit/psrpc"
 )
 
-const shutdownTimer = time.Second * 5
+const (
+	shutdownTimer        = time.Second * 5
+	rtpMetricsTimer      = time.Second * 5
+	resourceMetricsTimer = time.Second * 5
+)
 
 type publishRequest struct {
-	streamKey string
-	inputType livekit.IngressInput
-	result    chan<- publishResponse
+	streamKey  string
+	inputType  livekit.IngressInput
+	resourceId string // only for WHIP
+	pull       bool   // the input type is the one of the ingress, RTSP or URL
+	result     chan<- publishResponse
 }
 
 type publishResponse struct {
-	resp *rpc.GetIngressInfoResponse
-	err  error
+	resp     *rpc.GetIngressInfoResponse
+	process  *process // registered for the handler to launch, nil if attached or without handler
+	attached bool     // the publisher was attached to an ingress already running
+	err      error
 }
 
 type Service struct {
 	conf    *config.Config
 	monitor *stats.Monitor
 	manager *ProcessManager
+	rtmpSrv *rtmp.RTMPServer
 	whipSrv *whip.WHIPServer
 
 	psrpcClient rpc.IOInfoClient
@@ -51,26 +67,37 @@ type Service struct {
 
 	publishRequests chan publishRequest
 	shutdown        core.Fuse
+	unhealthy       core.Fuse
 }
 
-func NewService(conf *config.Config, psrpcClient rpc.IOInfoClient, bus psrpc.MessageBus, whipSrv *whip.WHIPServer) *Service {
+func NewService(conf *config.Config, psrpcClient rpc.IOInfoClient, bus psrpc.MessageBus, rtmpSrv *rtmp.RTMPServer, whipSrv *whip.WHIPServer) *Service {
 	monitor := stats.NewMonitor()
 
 	s := &Service{
 		conf:            conf,
 		monitor:         monitor,
 		manager:         NewProcessManager(conf, monitor),
+		rtmpSrv:         rtmpSrv,
 		whipSrv:         whipSrv,
 		psrpcClient:     psrpcClient,
 		bus:             bus,
 		publishRequests: make(chan publishRequest, 5),
 		shutdown:        core.NewFuse(),
+		unhealthy:       core.NewFuse(),
 	}
 
-	s.manager.onFatalError(func(info *livekit.IngressInfo, err error) {
-		s.sendUpdate(context.Background(), info, err)
-
-		s.Stop(false)
+	s.manager.onPublisherCheck(s.isPublishing)
+	if conf.RTMPBackup {
+		monitor.OnBackupDecodingCheck(s.isDecodingBackup)
+	}
+	s.manager.onHandlerCrash(func(info *livekit.IngressInfo, state *livekit.IngressState, err error) {
+		s.sendUpdate(context.Background(), &livekit.IngressInfo{IngressId: info.IngressId, State: state}, err)
+	})
+	s.manager.onRepeatedCrashes(func() {
+		// The running ingresses are kept, no new one is accepted
+		s.unhealthy.Once(func() {
+			logger.Warnw("handlers crashing repeatedly, marking node unhealthy", nil)
+		})
 	})
 
 	if conf.PrometheusPort > 0 {
@@ -83,14 +110,45 @@ func NewService(conf *config.Config, psrpcClient rpc.IOInfoClient, bus psrpc.Mes
 	return s
 }
 
-func (s *Service) HandleRTMPPublishRequest(streamKey string) error {
+func (s *Service) HandleRTMPPublishRequest(streamKey, remoteAddr string) error {
 	ctx, span := tracer.Start(context.Background(), "Service.HandleRTMPPublishRequest")
 	defer span.End()
 
+	if s.conf.RTMPBackup {
+		// The backup publisher feeds the same ingress as the primary one
+		streamKey = strings.TrimSuffix(streamKey, types.BackupStreamKeySuffix)
+	}
+
+	return s.handleRelayedPublishRequest(ctx, streamKey, remoteAddr, livekit.IngressInput_RTMP_INPUT)
+}
+
+func (s *Service) HandleSRTPublishRequest(streamKey, remoteAddr string) error {
+	ctx, span := tracer.Start(context.Background(), "Service.HandleSRTPublishRequest")
+	defer span.End()
+
+	return s.handleRelayedPublishRequest(ctx, streamKey, remoteAddr, types.SRTInput)
+}
+
+func (s *Service) HandleUDPPublishRequest(streamKey, remoteAddr string) error {
+	ctx, span := tracer.Start(context.Background(), "Service.HandleUDPPublishRequest")
+	defer span.End()
+
+	return s.handleRelayedPublishRequest(ctx, streamKey, remoteAddr, types.UDPInput)
+}
+
+func (s *Service) HandleWebSocketPublishRequest(streamKey, remoteAddr string) error {
+	ctx, span := tracer.Start(context.Background(), "Service.HandleWebSocketPublishRequest")
+	defer span.End()
+
+	return s.handleRelayedPublishRequest(ctx, streamKey, remoteAddr, types.WebSocketInput)
+}
+
+// handleRelayedPublishRequest admits publishers whose media is relayed as is to the handler process
+func (s *Service) handleRelayedPublishRequest(ctx context.Context, streamKey, remoteAddr string, inputType livekit.IngressInput) error {
 	res := make(chan publishResponse)
 	r := publishRequest{
 		streamKey: streamKey,
-		inputType: livekit.IngressInput_RTMP_INPUT,
+		inputType: inputType,
 		result:    res,
 	}
 
@@ -105,19 +163,53 @@ func (s *Service) HandleRTMPPublishRequest(streamKey string) error {
 		}
 	}
 
-	go s.manager.launchHandler(ctx, pRes.resp, nil)
+	if pRes.attached {
+		// The running handler pulls the media of all the publishers of the ingress
+		return nil
+	}
+
+	go s.manager.launchHandler(ctx, pRes.process, remoteAddr, nil)
 
 	return nil
 }
 
-func (s *Service) HandleWHIPPublishRequest(streamKey, resourceId string, ihs rpc.IngressHandlerServerImpl) (p *params.Params, ready func(mimeTypes map[types.StreamKind]string, err error), ended func(err error), err error) {
+// HandlePullRequest starts an ingress pulling its media from the URL of the ingress
+func (s *Service) HandlePullRequest(streamKey string, ep *params.PullExtraParams) (*livekit.IngressInfo, error) {
+	ctx, span := tracer.Start(context.Background(), "Service.HandlePullRequest")
+	defer span.End()
+
 	res := make(chan publishResponse)
 	r := publishRequest{
 		streamKey: streamKey,
-		inputType: livekit.IngressInput_WHIP_INPUT,
+		pull:      true,
 		result:    res,
 	}
 
+	var pRes publishResponse
+	select {
+	case <-s.shutdown.Watch():
+		return nil, errors.ErrServerShuttingDown
+	case s.publishRequests <- r:
+		pRes = <-res
+		if pRes.err != nil {
+			return nil, pRes.err
+		}
+	}
+
+	go s.manager.launchHandler(ctx, pRes.process, params.RedactURL(pRes.resp.Info.Url), ep)
+
+	return pRes.resp.Info, nil
+}
+
+func (s *Service) HandleWHIPPublishRequest(streamKey, resourceId, remoteAddr string, ihs rpc.IngressHandlerServerImpl) (p *params.Params, ready func(mimeTypes map[types.StreamKind]string, err error), ended func(err error), err error) {
+	res := make(chan publishResponse)
+	r := publishRequest{
+		streamKey:  streamKey,
+		inputType:  livekit.IngressInput_WHIP_INPUT,
+		resourceId: resourceId,
+		result:     res,
+	}
+
 	var pRes publishResponse
 	select {
 	case <-s.shutdown.Watch():
@@ -140,6 +232,9 @@ func (s *Service) HandleWHIPPublishRequest(streamKey, resourceId string, ihs rpc
 
 	p, err = params.GetParams(context.Background(), s.conf, pRes.resp.Info, wsUrl, pRes.resp.Token, extraParams)
 	if err != nil {
+		if pRes.process != nil {
+			s.manager.remove(pRes.process)
+		}
 		return nil, nil, nil, err
 	}
 
@@ -163,9 +258,17 @@ func (s *Service) HandleWHIPPublishRequest(streamKey, resourceId string, ihs rpc
 		defer span.End()
 		if err != nil {
 			// Client failed to finalize session start
+			if pRes.attached {
+				// The running handler ends the ingress if no other session starts within the grace period
+				logger.Warnw("reconnected WHIP session failed to start", err, "ingressID", p.IngressInfo.IngressId)
+				span.RecordError(err)
+				return
+			}
 			s.sendUpdate(ctx, p.IngressInfo, err)
 			if p.IngressInfo.BypassTranscoding {
 				DeregisterIngressRpcHandlers(rpcServer, p.IngressInfo, p.ExtraParams)
+			} else {
+				s.manager.remove(pRes.process)
 			}
 			span.RecordError(err)
 			return
@@ -177,10 +280,13 @@ func (s *Service) HandleWHIPPublishRequest(streamKey, resourceId string, ihs rpc
 			s.sendUpdate(ctx, p.IngressInfo, nil)
 
 			s.monitor.IngressStarted(p.IngressInfo)
+		} else if pRes.attached {
+			// The handler of the previous session is still running and pulls the media of this one
+			logger.Infow("WHIP publisher reconnected", "ingressID", p.IngressInfo.IngressId, "resourceID", resourceId)
 		} else {
 			extraParams.MimeTypes = mimeTypes
 
-			go s.manager.launchHandler(ctx, pRes.resp, extraParams)
+			go s.manager.launchHandler(ctx, pRes.process, remoteAddr, extraParams)
 		}
 	}
 
@@ -200,27 +306,57 @@ func (s *Service) HandleWHIPPublishRequest(streamKey, resourceId string, ihs rpc
 	return p, ready, ended, nil
 }
 
-func (s *Service) handleNewPublisher(ctx context.Context, streamKey string, inputType livekit.IngressInput) (*rpc.GetIngressInfoResponse, error) {
+func (s *Service) handleNewPublisher(ctx context.Context, streamKey string, inputType livekit.IngressInput, resourceId string, pull bool) (*rpc.GetIngressInfoResponse, *process, bool, error) {
+	if s.conf.Standalone == nil && (pull || types.IsCustomInput(inputType)) {
+		return nil, nil, false, errors.ErrStandaloneInputType
+	}
+
 	resp, err := s.psrpcClient.GetIngressInfo(ctx, &rpc.GetIngressInfoRequest{
 		StreamKey: streamKey,
 	})
 	if err != nil {
-		return nil, err
+		return nil, nil, false, err
 	}
 
-	err = ingress.Validate(resp.Info)
+	err = params.Validate(resp.Info)
 	if err != nil {
-		return resp, err
+		return resp, nil, false, err
+	}
+	if resp.Info.InputType == types.URLInput {
+		if resp.Info.Url, err = params.ResolveFileURL(resp.Info.Url, s.conf.URLFileDirectory); err != nil {
+			return resp, nil, false, err
+		}
 	}
 
-	if inputType != resp.Info.InputType {
-		return nil, ingress.ErrInvalidIngressType
+	switch {
+	case pull && resp.Info.InputType != types.RTSPInput && resp.Info.InputType != types.URLInput:
+		return nil, nil, false, ingress.ErrInvalidIngressType
+	case !pull && inputType != resp.Info.InputType:
+		return nil, nil, false, ingress.ErrInvalidIngressType
+	}
+
+	if s.canAttach(resp.Info) && s.manager.isActive(resp.Info.IngressId, resourceId) {
+		// Backup or reconnecting publisher joining an ingress whose handler is already running
+		if s.conf.RTMPBackup && resp.Info.InputType == livekit.IngressInput_RTMP_INPUT && s.isPublishing(resp.Info, nil) {
+			// The other publisher is still connected, the media of both is decoded from now on
+			if !s.monitor.AcceptRTMPBackup() {
+				logger.Debugw("rejecting backup publisher")
+				return nil, nil, false, errors.ErrServerCapacityExceeded
+			}
+		}
+
+		logger.Infow("attaching publisher to running ingress", "ingressID", resp.Info.IngressId)
+		return resp, nil, true, nil
+	}
+
+	if pull && s.manager.isActive(resp.Info.IngressId, "") {
+		return nil, nil, false, errors.ErrStreamKeyInUse
 	}
 
 	// check cpu load
 	if !s.monitor.AcceptIngress(resp.Info) {
 		logger.Debugw("rejecting ingress")
-		return nil, errors.ErrServerCapacityExceeded
+		return nil, nil, false, errors.ErrServerCapacityExceeded
 	}
 
 	resp.Info.State = &livekit.IngressState{
@@ -228,7 +364,53 @@ func (s *Service) handleNewPublisher(ctx context.Context, streamKey string, inpu
 		StartedAt: time.Now().UnixNano(),
 	}
 
-	return resp, nil
+	if resp.Info.InputType == livekit.IngressInput_WHIP_INPUT && resp.Info.BypassTranscoding {
+		// The media is published by the WHIP session itself, without handler
+		return resp, nil, false, nil
+	}
+
+	// Registered before replying, for the publishers of the ingress connecting meanwhile to see it as active
+	h, attached, err := s.manager.reserve(resp, resourceId, s.canAttach(resp.Info), pull)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	if attached {
+		logger.Infow("attaching publisher to running ingress", "ingressID", resp.Info.IngressId)
+	}
+
+	return resp, h, attached, nil
+}
+
+// canAttach returns true if a new publisher can feed the running handler of the ingress
+func (s *Service) canAttach(info *livekit.IngressInfo) bool {
+	switch info.InputType {
+	case livekit.IngressInput_RTMP_INPUT:
+		return s.conf.RTMPBackup || s.conf.ReconnectGracePeriodMs > 0
+	case livekit.IngressInput_WHIP_INPUT:
+		// When bypassing transcoding, the media is published by the WHIP session itself
+		return !info.BypassTranscoding && s.conf.ReconnectGracePeriodMs > 0
+	default:
+		return false
+	}
+}
+
+// isPublishing returns true if the publisher of an ingress whose media is relayed by this process is still connected
+func (s *Service) isPublishing(info *livekit.IngressInfo, extraParams any) bool {
+	switch info.InputType {
+	case livekit.IngressInput_RTMP_INPUT:
+		return s.rtmpSrv != nil && (s.rtmpSrv.IsPublishing(info.StreamKey) || s.rtmpSrv.IsPublishing(info.StreamKey+types.BackupStreamKeySuffix))
+	case livekit.IngressInput_WHIP_INPUT:
+		ep, ok := extraParams.(*params.WhipExtraParams)
+		return ok && s.whipSrv != nil && s.whipSrv.IsPublishing(ep.ResourceId)
+	default:
+		return false
+	}
+}
+
+// isDecodingBackup returns true while both the primary and the backup publishers of an RTMP ingress are connected
+func (s *Service) isDecodingBackup(info *livekit.IngressInfo) bool {
+	return info.InputType == livekit.IngressInput_RTMP_INPUT && s.rtmpSrv != nil &&
+		s.rtmpSrv.IsPublishing(info.StreamKey) && s.rtmpSrv.IsPublishing(info.StreamKey+types.BackupStreamKeySuffix)
 }
 
 func (s *Service) Run() error {
@@ -248,6 +430,21 @@ func (s *Service) Run() error {
 		return err
 	}
 
+	if s.whipSrv != nil {
+		go s.updateRTPMetrics()
+	}
+
+	if s.conf.CGroups.Enabled {
+		c, err := cgroup.NewController(s.conf.CGroups.Root)
+		if err != nil {
+			logger.Errorw("could not set up handler cgroups", err)
+			return err
+		}
+		s.manager.limitResources(c)
+
+		go s.updateResourceMetrics()
+	}
+
 	logger.Debugw("service ready")
 
 	for {
@@ -263,8 +460,8 @@ func (s *Service) Run() error {
 				ctx, span := tracer.Start(context.Background(), "Service.HandleRequest")
 				defer span.End()
 
-				resp, err := s.handleNewPublisher(ctx, req.streamKey, req.inputType)
-				if resp != nil && resp.Info != nil {
+				resp, h, attached, err := s.handleNewPublisher(ctx, req.streamKey, req.inputType, req.resourceId, req.pull)
+				if resp != nil && resp.Info != nil && !attached {
 					s.sendUpdate(ctx, resp.Info, err)
 				}
 				if err != nil {
@@ -272,14 +469,39 @@ func (s *Service) Run() error {
 				}
 				// Result channel should be buffered
 				req.result <- publishResponse{
-					resp: resp,
-					err:  err,
+					resp:     resp,
+					process:  h,
+					attached: attached,
+					err:      err,
 				}
 			}()
 		}
 	}
 }
 
+// updateRTPMetrics periodically exports the reception statistics of the WHIP sessions, terminated by this process
+func (s *Service) updateRTPMetrics() {
+	// Sessions keep running while the service shuts down gracefully
+	ticker := time.NewTicker(rtpMetricsTimer)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		for ingressID, rtpStats := range s.whipSrv.GetRTPStats() {
+			s.monitor.UpdateRTPMetrics(ingressID, rtpStats)
+		}
+	}
+}
+
+// updateResourceMetrics periodically exports the resources used by the handler processes
+func (s *Service) updateResourceMetrics() {
+	ticker := time.NewTicker(resourceMetricsTimer)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		s.manager.updateResourceMetrics()
+	}
+}
+
 func (s *Service) isIdle() bool {
 	whipIdle := true
 	if s.whipSrv != nil {
@@ -310,7 +532,7 @@ func (s *Service) sendUpdate(ctx context.Context, info *livekit.IngressInfo, err
 }
 
 func (s *Service) CanAccept() bool {
-	return !s.shutdown.IsBroken() && s.monitor.CanAcceptIngress()
+	return !s.shutdown.IsBroken() && !s.unhealthy.IsBroken() && s.monitor.CanAcceptIngress()
 }
 
 func (s *Service) Stop(kill bool) {
@@ -347,7 +569,66 @@ func (s *Service) AvailabilityHandler(w http.ResponseWriter, r *http.Request) {
 	_, _ = w.Write([]byte("Available"))
 }
 
+// PullHandler starts a pull ingress, authenticated the same way as the admin API. The stream key of the ingress is the last
+// element of the path. The media is pulled from the URL of the ingress, and the optional body describes how:
+//
+//	{"rtsp_transport": "tcp"}
+//	{"loop": true}
+func (s *Service) PullHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := s.authenticateAdmin(r); err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+
+	info, err := s.handlePullHTTPRequest(r)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+
+	b, err := protojson.Marshal(info)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_, _ = w.Write(b)
+}
+
+func (s *Service) handlePullHTTPRequest(r *http.Request) (*livekit.IngressInfo, error) {
+	streamKey := path.Base(r.URL.Path)
+	if streamKey == "" || streamKey == "pull" || streamKey == "/" {
+		return nil, errors.ErrMissingStreamKey
+	}
+
+	ep := &params.PullExtraParams{}
+	if err := json.NewDecoder(r.Body).Decode(ep); err != nil && err != io.EOF {
+		return nil, psrpc.NewErrorf(psrpc.MalformedRequest, "invalid pull request: %v", err)
+	}
+
+	switch ep.RTSPTransport {
+	case "", "tcp", "udp":
+	default:
+		return nil, psrpc.NewErrorf(psrpc.InvalidArgument, "unsupported RTSP transport %q", ep.RTSPTransport)
+	}
+
+	return s.HandlePullRequest(streamKey, ep)
+}
+
 func (s *Service) HealthHandler(w http.ResponseWriter, r *http.Request) {
+	if s.unhealthy.IsBroken() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write([]byte("Unhealthy"))
+		return
+	}
+
 	_, _ = w.Write([]byte("Healthy"))
 }
 
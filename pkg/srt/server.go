@@ -0,0 +1,266 @@
+package srt
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"sync"
+
+	srt "github.com/datarhei/gosrt"
+
+	"github.com/livekit/ingress/pkg/config"
+	"github.com/livekit/ingress/pkg/errors"
+	"github.com/livekit/ingress/pkg/utils"
+	"github.com/livekit/protocol/logger"
+	"github.com/livekit/psrpc"
+)
+
+const (
+	// SRT Access Control stream ID prefix, https://github.com/Haivision/srt/blob/master/docs/features/access-control.md
+	accessControlPrefix = "#!::"
+)
+
+type SRTServer struct {
+	listener srt.Listener
+	handlers sync.Map
+}
+
+func NewSRTServer() *SRTServer {
+	return &SRTServer{}
+}
+
+func (s *SRTServer) Start(conf *config.Config, onPublish func(streamKey, remoteAddr string) error) error {
+	port := conf.SRTPort
+
+	listener, err := srt.Listen("srt", fmt.Sprintf(":%d", port), srt.DefaultConfig())
+	if err != nil {
+		logger.Errorw("failed to start SRT listener", err, "port", port)
+		return err
+	}
+	s.listener = listener
+
+	go func() {
+		for {
+			req, err := listener.Accept2()
+			if err != nil {
+				if err != srt.ErrListenerClosed {
+					logger.Errorw("failed to accept SRT connection", err)
+				}
+				return
+			}
+
+			// Admission requires a round trip to the control plane, don't block the accept loop
+			go s.handleConnRequest(req, onPublish)
+		}
+	}()
+
+	return nil
+}
+
+func (s *SRTServer) handleConnRequest(req srt.ConnRequest, onPublish func(streamKey, remoteAddr string) error) {
+	log := logger.GetLogger().WithValues("remoteAddr", req.RemoteAddr().String())
+
+	streamKey, err := parseStreamID(req.StreamId())
+	if err != nil {
+		log.Infow("rejecting SRT connection", "streamID", req.StreamId(), "error", err)
+		req.Reject(getRejectionReason(err))
+		return
+	}
+	log = log.WithValues("streamKey", streamKey)
+
+	if req.IsEncrypted() {
+		log.Infow("rejecting encrypted SRT connection")
+		req.Reject(srt.REJ_UNSECURE)
+		return
+	}
+
+	h := NewSRTHandler(streamKey)
+	h.OnCloseCallback(func(streamKey string) {
+		s.handlers.Delete(streamKey)
+	})
+
+	if onPublish != nil {
+		err = onPublish(streamKey, req.RemoteAddr().String())
+		if err != nil {
+			log.Infow("rejecting SRT connection", "error", err)
+			req.Reject(getRejectionReason(err))
+			return
+		}
+	}
+
+	// Store the handler before accepting the connection to make sure the relay can find it
+	s.handlers.Store(streamKey, h)
+
+	conn, err := req.Accept()
+	if err != nil {
+		log.Errorw("failed to accept SRT connection", err)
+		h.OnClose()
+		return
+	}
+
+	log.Infow("Received a new published stream")
+
+	h.Run(conn)
+}
+
+func (s *SRTServer) AssociateRelay(streamKey string, w io.WriteCloser) error {
+	h, ok := s.handlers.Load(streamKey)
+	if ok && h != nil {
+		err := h.(*SRTHandler).SetWriter(w)
+		if err != nil {
+			return err
+		}
+	} else {
+		return errors.ErrIngressNotFound
+	}
+
+	return nil
+}
+
+func (s *SRTServer) DissociateRelay(streamKey string) error {
+	h, ok := s.handlers.Load(streamKey)
+	if ok && h != nil {
+		err := h.(*SRTHandler).SetWriter(nil)
+		if err != nil {
+			return err
+		}
+	} else {
+		return errors.ErrIngressNotFound
+	}
+
+	return nil
+}
+
+func (s *SRTServer) Stop() error {
+	if s.listener != nil {
+		s.listener.Close()
+	}
+
+	return nil
+}
+
+type SRTHandler struct {
+	streamKey   string
+	mediaBuffer *utils.PrerollBuffer
+
+	log logger.Logger
+
+	onClose func(streamKey string)
+}
+
+func NewSRTHandler(streamKey string) *SRTHandler {
+	h := &SRTHandler{
+		streamKey: streamKey,
+		log:       logger.GetLogger().WithValues("streamKey", streamKey),
+	}
+
+	h.mediaBuffer = utils.NewPrerollBuffer(func() error {
+		// MPEG-TS is self synchronizing, the demuxer will pick up at the next PAT/PMT
+		h.log.Infow("preroll buffer reset event")
+
+		return nil
+	})
+
+	return h
+}
+
+func (h *SRTHandler) OnCloseCallback(cb func(streamKey string)) {
+	h.onClose = cb
+}
+
+func (h *SRTHandler) Run(conn srt.Conn) {
+	defer func() {
+		conn.Close()
+		h.OnClose()
+	}()
+
+	buf := make([]byte, srt.MAX_PAYLOAD_SIZE)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			if err != io.EOF {
+				h.log.Infow("SRT connection read failed", "error", err)
+			}
+			return
+		}
+
+		if _, err := h.mediaBuffer.Write(buf[:n]); err != nil {
+			// log and continue, or fail and let sender reconnect?
+			h.log.Errorw("failed to write media", err)
+		}
+	}
+}
+
+func (h *SRTHandler) OnClose() {
+	h.log.Infow("closing ingress SRT session")
+
+	h.mediaBuffer.Close()
+
+	if h.onClose != nil {
+		h.onClose(h.streamKey)
+	}
+}
+
+func (h *SRTHandler) SetWriter(w io.WriteCloser) error {
+	return h.mediaBuffer.SetWriter(w)
+}
+
+// parseStreamID supports both plain stream IDs and the SRT Access Control syntax,
+// e.g. "#!::r=live/<stream key>,m=publish". In both cases, the stream key is the last path element.
+func parseStreamID(streamID string) (string, error) {
+	resource := streamID
+
+	if strings.HasPrefix(streamID, accessControlPrefix) {
+		resource = ""
+
+		for _, kv := range strings.Split(strings.TrimPrefix(streamID, accessControlPrefix), ",") {
+			k, v, ok := strings.Cut(kv, "=")
+			if !ok {
+				return "", psrpc.NewErrorf(psrpc.InvalidArgument, "invalid stream ID %q", streamID)
+			}
+
+			switch k {
+			case "r":
+				resource = v
+			case "m":
+				if v != "publish" {
+					return "", psrpc.NewErrorf(psrpc.Unimplemented, "unsupported mode %q", v)
+				}
+			}
+		}
+	}
+
+	_, streamKey := path.Split(resource)
+	if streamKey == "" {
+		return "", errors.ErrMissingStreamKey
+	}
+
+	return streamKey, nil
+}
+
+func getRejectionReason(err error) srt.RejectionReason {
+	var psrpcErr psrpc.Error
+	if !errors.As(err, &psrpcErr) {
+		return srt.REJX_ISE
+	}
+
+	switch psrpcErr.Code() {
+	case psrpc.InvalidArgument, psrpc.MalformedRequest:
+		return srt.REJX_BAD_REQUEST
+	case psrpc.NotFound:
+		return srt.REJX_NOTFOUND
+	case psrpc.PermissionDenied, psrpc.Unauthenticated:
+		return srt.REJX_UNAUTHORIZED
+	case psrpc.ResourceExhausted:
+		return srt.REJX_OVERLOAD
+	case psrpc.Unavailable:
+		return srt.REJX_DOWN
+	case psrpc.Unimplemented:
+		return srt.REJX_BAD_MODE
+	case psrpc.AlreadyExists:
+		return srt.REJX_CONFLICT
+	default:
+		return srt.REJ_PEER
+	}
+}
@@ -0,0 +1,135 @@
+package standalone
+
+import (
+	"context"
+	"time"
+
+	google_protobuf2 "google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/livekit/ingress/pkg/config"
+	"github.com/livekit/protocol/auth"
+	"github.com/livekit/protocol/livekit"
+	"github.com/livekit/protocol/logger"
+	"github.com/livekit/protocol/rpc"
+	"github.com/livekit/protocol/utils"
+	"github.com/livekit/protocol/webhook"
+	"github.com/livekit/psrpc"
+)
+
+const (
+	tokenValidity = 24 * time.Hour
+)
+
+// IOInfoClient stands in for the LiveKit server in standalone mode. Ingresses are read from the local store,
+// participant tokens are signed with the configured API key, and state updates are logged and sent to the
+// configured webhooks.
+type IOInfoClient struct {
+	conf     *config.Config
+	store    *IngressStore
+	notifier *webhook.DefaultNotifier
+}
+
+func NewIOInfoClient(conf *config.Config) *IOInfoClient {
+	c := &IOInfoClient{
+		conf:  conf,
+		store: NewIngressStore(conf.Standalone.IngressesFile),
+	}
+	if len(conf.Standalone.WebhookUrls) > 0 {
+		c.notifier = webhook.NewDefaultNotifier(conf.ApiKey, conf.ApiSecret, conf.Standalone.WebhookUrls).(*webhook.DefaultNotifier)
+	}
+
+	return c
+}
+
+func (c *IOInfoClient) UpdateEgressInfo(_ context.Context, _ *livekit.EgressInfo, _ ...psrpc.RequestOption) (*google_protobuf2.Empty, error) {
+	return nil, psrpc.NewErrorf(psrpc.Unimplemented, "egress is not supported in standalone mode")
+}
+
+func (c *IOInfoClient) GetIngressInfo(_ context.Context, req *rpc.GetIngressInfoRequest, _ ...psrpc.RequestOption) (*rpc.GetIngressInfoResponse, error) {
+	var info *livekit.IngressInfo
+	var err error
+	if req.IngressId != "" {
+		info, err = c.store.GetByID(req.IngressId)
+	} else {
+		info, err = c.store.GetByStreamKey(req.StreamKey)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := c.getToken(info)
+	if err != nil {
+		return nil, err
+	}
+
+	return &rpc.GetIngressInfoResponse{
+		Info:  info,
+		Token: token,
+		WsUrl: c.conf.WsUrl,
+	}, nil
+}
+
+func (c *IOInfoClient) UpdateIngressState(ctx context.Context, req *rpc.UpdateIngressStateRequest, _ ...psrpc.RequestOption) (*google_protobuf2.Empty, error) {
+	logger.Infow("ingress state updated",
+		"ingressID", req.IngressId,
+		"status", req.State.Status,
+		"error", req.State.Error,
+	)
+
+	if c.notifier == nil {
+		return &google_protobuf2.Empty{}, nil
+	}
+
+	var event string
+	switch req.State.Status {
+	case livekit.IngressState_ENDPOINT_PUBLISHING:
+		event = webhook.EventIngressStarted
+	case livekit.IngressState_ENDPOINT_INACTIVE, livekit.IngressState_ENDPOINT_ERROR:
+		event = webhook.EventIngressEnded
+	default:
+		return &google_protobuf2.Empty{}, nil
+	}
+
+	info, err := c.store.GetByID(req.IngressId)
+	if err != nil {
+		// Removed from the store since it started
+		info = &livekit.IngressInfo{IngressId: req.IngressId}
+	}
+	info.State = req.State
+
+	err = c.notifier.QueueNotify(ctx, &livekit.WebhookEvent{
+		Event:       event,
+		IngressInfo: info,
+		Id:          utils.NewGuid("EV_"),
+		CreatedAt:   time.Now().Unix(),
+	})
+	if err != nil {
+		logger.Warnw("failed to send webhook", err, "ingressID", req.IngressId)
+	}
+
+	return &google_protobuf2.Empty{}, nil
+}
+
+// Stop sends the pending webhooks
+func (c *IOInfoClient) Stop() {
+	if c.notifier != nil {
+		c.notifier.Stop(false)
+	}
+}
+
+func (c *IOInfoClient) getToken(info *livekit.IngressInfo) (string, error) {
+	canPublish := true
+	canSubscribe := false
+
+	return auth.NewAccessToken(c.conf.ApiKey, c.conf.ApiSecret).
+		AddGrant(&auth.VideoGrant{
+			RoomJoin:     true,
+			Room:         info.RoomName,
+			CanPublish:   &canPublish,
+			CanSubscribe: &canSubscribe,
+		}).
+		SetIdentity(info.ParticipantIdentity).
+		SetName(info.ParticipantName).
+		SetValidFor(tokenValidity).
+		ToJWT()
+}
@@ -0,0 +1,157 @@
+package standalone
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"gopkg.in/yaml.v3"
+
+	"github.com/livekit/ingress/pkg/errors"
+	"github.com/livekit/protocol/livekit"
+	"github.com/livekit/protocol/utils"
+	"github.com/livekit/psrpc"
+)
+
+// IngressStore provides the ingresses defined in a local YAML or JSON file, in place of the LiveKit server.
+// Each entry of the file uses the IngressInfo JSON format, e.g.
+//
+//	ingresses:
+//	  - stream_key: my-stream-key
+//	    input_type: RTMP_INPUT
+//	    room_name: my-room
+//	    participant_identity: my-ingress
+//
+// The file is reloaded when it changes.
+type IngressStore struct {
+	path string
+
+	lock      sync.Mutex
+	modTime   time.Time
+	ingresses []*livekit.IngressInfo
+}
+
+type ingressFile struct {
+	Ingresses []map[string]any `yaml:"ingresses"`
+}
+
+func NewIngressStore(path string) *IngressStore {
+	return &IngressStore{
+		path: path,
+	}
+}
+
+func (s *IngressStore) GetByStreamKey(streamKey string) (*livekit.IngressInfo, error) {
+	return s.find(func(info *livekit.IngressInfo) bool {
+		return info.StreamKey == streamKey
+	})
+}
+
+func (s *IngressStore) GetByID(ingressID string) (*livekit.IngressInfo, error) {
+	return s.find(func(info *livekit.IngressInfo) bool {
+		return info.IngressId == ingressID
+	})
+}
+
+func (s *IngressStore) List() ([]*livekit.IngressInfo, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+
+	res := make([]*livekit.IngressInfo, 0, len(s.ingresses))
+	for _, info := range s.ingresses {
+		res = append(res, proto.Clone(info).(*livekit.IngressInfo))
+	}
+
+	return res, nil
+}
+
+func (s *IngressStore) find(match func(info *livekit.IngressInfo) bool) (*livekit.IngressInfo, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+
+	for _, info := range s.ingresses {
+		if match(info) {
+			return proto.Clone(info).(*livekit.IngressInfo), nil
+		}
+	}
+
+	return nil, errors.ErrIngressNotFound
+}
+
+func (s *IngressStore) load() error {
+	stat, err := os.Stat(s.path)
+	if err != nil {
+		return err
+	}
+	if s.ingresses != nil && stat.ModTime().Equal(s.modTime) {
+		return nil
+	}
+
+	b, err := os.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+
+	ingresses, err := parseIngresses(b)
+	if err != nil {
+		return err
+	}
+
+	s.ingresses = ingresses
+	s.modTime = stat.ModTime()
+
+	return nil
+}
+
+func parseIngresses(b []byte) ([]*livekit.IngressInfo, error) {
+	// JSON is valid YAML
+	f := &ingressFile{}
+	if err := yaml.Unmarshal(b, f); err != nil {
+		return nil, psrpc.NewErrorf(psrpc.InvalidArgument, "invalid ingresses file: %v", err)
+	}
+
+	streamKeys := make(map[string]bool)
+	ingresses := make([]*livekit.IngressInfo, 0, len(f.Ingresses))
+	for i, entry := range f.Ingresses {
+		j, err := json.Marshal(entry)
+		if err != nil {
+			return nil, psrpc.NewErrorf(psrpc.InvalidArgument, "invalid ingress %d: %v", i, err)
+		}
+
+		info := &livekit.IngressInfo{}
+		if err = protojson.Unmarshal(j, info); err != nil {
+			return nil, psrpc.NewErrorf(psrpc.InvalidArgument, "invalid ingress %d: %v", i, err)
+		}
+
+		if info.StreamKey == "" {
+			return nil, psrpc.NewErrorf(psrpc.InvalidArgument, "invalid ingress %d: missing stream key", i)
+		}
+		if streamKeys[info.StreamKey] {
+			return nil, psrpc.NewErrorf(psrpc.InvalidArgument, "invalid ingress %d: duplicate stream key", i)
+		}
+		streamKeys[info.StreamKey] = true
+
+		if info.IngressId == "" {
+			// Stable across reloads, without leaking the stream key
+			h := sha256.Sum256([]byte(info.StreamKey))
+			info.IngressId = utils.IngressPrefix + hex.EncodeToString(h[:6])
+		}
+
+		ingresses = append(ingresses, info)
+	}
+
+	return ingresses, nil
+}
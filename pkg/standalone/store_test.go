@@ -0,0 +1,63 @@
+package standalone
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/protocol/livekit"
+)
+
+func TestParseIngresses(t *testing.T) {
+	ingresses, err := parseIngresses([]byte(`
+ingresses:
+  - stream_key: key1
+    input_type: RTMP_INPUT
+    room_name: room1
+    participant_identity: ingress1
+    video:
+      preset: H264_720P_30FPS_3_LAYERS
+  - ingress_id: IN_custom
+    stream_key: key2
+    input_type: 1
+    room_name: room2
+`))
+	require.NoError(t, err)
+	require.Len(t, ingresses, 2)
+
+	require.Equal(t, "key1", ingresses[0].StreamKey)
+	require.Equal(t, livekit.IngressInput_RTMP_INPUT, ingresses[0].InputType)
+	require.Equal(t, "room1", ingresses[0].RoomName)
+	require.Equal(t, livekit.IngressVideoEncodingPreset_H264_720P_30FPS_3_LAYERS, ingresses[0].Video.GetPreset())
+	require.Regexp(t, "^IN_[0-9a-f]{12}$", ingresses[0].IngressId)
+
+	require.Equal(t, "IN_custom", ingresses[1].IngressId)
+	require.Equal(t, livekit.IngressInput_WHIP_INPUT, ingresses[1].InputType)
+
+	// The generated ID is stable
+	again, err := parseIngresses([]byte(`{"ingresses": [{"streamKey": "key1"}]}`))
+	require.NoError(t, err)
+	require.Equal(t, ingresses[0].IngressId, again[0].IngressId)
+}
+
+func TestParseIngressesErrors(t *testing.T) {
+	_, err := parseIngresses([]byte(`
+ingresses:
+  - room_name: room1
+`))
+	require.Error(t, err)
+
+	_, err = parseIngresses([]byte(`
+ingresses:
+  - stream_key: key1
+  - stream_key: key1
+`))
+	require.Error(t, err)
+
+	_, err = parseIngresses([]byte(`
+ingresses:
+  - stream_key: key1
+    unknown_field: true
+`))
+	require.Error(t, err)
+}
@@ -0,0 +1,237 @@
+package stats
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/livekit/ingress/pkg/types"
+	"github.com/livekit/protocol/livekit"
+)
+
+const (
+	cpuSampleInterval = 5 * time.Second
+	// samples kept for each cost key, about an hour of a single ingress
+	maxCPUSamples = 720
+	// USER_HZ, the unit of the CPU times of /proc/<pid>/stat
+	clockTicks = 100
+)
+
+// costKey identifies the ingresses expected to use the same amount of CPU
+type costKey struct {
+	inputType   string
+	transcoding bool
+	preset      string
+}
+
+func getCostKey(info *livekit.IngressInfo) costKey {
+	k := costKey{
+		inputType:   types.InputTypeName(info.InputType),
+		transcoding: !info.BypassTranscoding,
+		preset:      "none",
+	}
+	if k.transcoding {
+		switch o := info.GetVideo().GetEncodingOptions().(type) {
+		case nil:
+			k.preset = "default"
+		case *livekit.IngressVideoOptions_Preset:
+			k.preset = o.Preset.String()
+		case *livekit.IngressVideoOptions_Options:
+			k.preset = "custom"
+		}
+	}
+
+	return k
+}
+
+// cpuSamples is a rolling window of the CPU usage sampled for the handlers of a cost key, in CPUs
+type cpuSamples struct {
+	values []float64
+	next   int
+}
+
+func (s *cpuSamples) add(v float64) {
+	if len(s.values) < maxCPUSamples {
+		s.values = append(s.values, v)
+		return
+	}
+
+	s.values[s.next] = v
+	s.next = (s.next + 1) % maxCPUSamples
+}
+
+func (s *cpuSamples) percentile(p float64) float64 {
+	if len(s.values) == 0 {
+		return 0
+	}
+
+	sorted := make([]float64, len(s.values))
+	copy(sorted, s.values)
+	sort.Float64s(sorted)
+
+	i := int(p * float64(len(sorted)-1))
+	return sorted[i]
+}
+
+func (s *cpuSamples) mean() float64 {
+	if len(s.values) == 0 {
+		return 0
+	}
+
+	var sum float64
+	for _, v := range s.values {
+		sum += v
+	}
+	return sum / float64(len(s.values))
+}
+
+// handlerCPU is the last CPU time sampled for a handler process
+type handlerCPU struct {
+	info      *livekit.IngressInfo
+	key       costKey
+	pid       int
+	cpuTime   time.Duration
+	sampledAt time.Time
+	// a backup publisher was decoded as well when sampled
+	backup bool
+}
+
+// cpuAccounting measures the CPU used by the handler processes, per cost key
+type cpuAccounting struct {
+	lock     sync.Mutex
+	handlers map[string]*handlerCPU
+	samples  map[costKey]*cpuSamples
+
+	promCostP95  *prometheus.GaugeVec
+	promCostMean *prometheus.GaugeVec
+}
+
+func newCPUAccounting(nodeID string) *cpuAccounting {
+	newGaugeVec := func(name string, help string) *prometheus.GaugeVec {
+		return prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace:   "livekit",
+			Subsystem:   "ingress",
+			Name:        name,
+			Help:        help,
+			ConstLabels: prometheus.Labels{"node_id": nodeID},
+		}, []string{"type", "transcoding", "preset"})
+	}
+
+	return &cpuAccounting{
+		handlers:     make(map[string]*handlerCPU),
+		samples:      make(map[costKey]*cpuSamples),
+		promCostP95:  newGaugeVec("measured_cpu_cost_p95", "95th percentile of the CPUs used by the handlers of an ingress type"),
+		promCostMean: newGaugeVec("measured_cpu_cost_mean", "Average CPUs used by the handlers of an ingress type"),
+	}
+}
+
+func (a *cpuAccounting) collectors() []prometheus.Collector {
+	return []prometheus.Collector{a.promCostP95, a.promCostMean}
+}
+
+func (a *cpuAccounting) addHandler(info *livekit.IngressInfo, pid int) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	a.handlers[info.IngressId] = &handlerCPU{
+		info: info,
+		key:  getCostKey(info),
+		pid:  pid,
+	}
+}
+
+func (a *cpuAccounting) removeHandler(ingressID string) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	delete(a.handlers, ingressID)
+}
+
+// sample records the CPU used by each handler since the previous sample. The periods during which a handler decodes a
+// backup publisher as well, according to isDecodingBackup, are left out: the backup is charged with its own configured cost
+func (a *cpuAccounting) sample(now time.Time, isDecodingBackup func(info *livekit.IngressInfo) bool) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	for _, h := range a.handlers {
+		cpuTime, err := ReadProcessCPUTime(h.pid)
+		if err != nil {
+			// Exited, and not restarted yet
+			continue
+		}
+
+		backup := isDecodingBackup != nil && isDecodingBackup(h.info)
+		if !h.sampledAt.IsZero() && !h.backup && !backup && cpuTime >= h.cpuTime {
+			s := a.samples[h.key]
+			if s == nil {
+				s = &cpuSamples{}
+				a.samples[h.key] = s
+			}
+			s.add((cpuTime - h.cpuTime).Seconds() / now.Sub(h.sampledAt).Seconds())
+		}
+		h.cpuTime = cpuTime
+		h.sampledAt = now
+		h.backup = backup
+	}
+
+	for k, s := range a.samples {
+		labels := prometheus.Labels{"type": k.inputType, "transcoding": fmt.Sprintf("%v", k.transcoding), "preset": k.preset}
+		a.promCostP95.With(labels).Set(s.percentile(0.95))
+		a.promCostMean.With(labels).Set(s.mean())
+	}
+}
+
+// getCost returns the 95th percentile of the CPU usage measured for a cost key, if enough samples were collected
+func (a *cpuAccounting) getCost(key costKey, minSamples int) (float64, bool) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	s := a.samples[key]
+	if s == nil || len(s.values) < minSamples {
+		return 0, false
+	}
+
+	return s.percentile(0.95), true
+}
+
+// ReadProcessCPUTime returns the CPU time used by a process so far, in user and system mode
+func ReadProcessCPUTime(pid int) (time.Duration, error) {
+	b, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return 0, err
+	}
+
+	return parseProcStat(b)
+}
+
+// parseProcStat returns the user and system CPU time found in the content of /proc/<pid>/stat
+func parseProcStat(b []byte) (time.Duration, error) {
+	// The command name can contain spaces and parentheses
+	i := bytes.LastIndexByte(b, ')')
+	if i < 0 {
+		return 0, fmt.Errorf("invalid stat content")
+	}
+
+	// Fields following the command name, starting with the state (field 3). utime and stime are fields 14 and 15
+	fields := bytes.Fields(b[i+1:])
+	if len(fields) < 13 {
+		return 0, fmt.Errorf("invalid stat content")
+	}
+
+	utime, err := strconv.ParseUint(string(fields[11]), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	stime, err := strconv.ParseUint(string(fields[12]), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	return time.Duration(utime+stime) * time.Second / clockTicks, nil
+}
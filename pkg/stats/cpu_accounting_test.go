@@ -0,0 +1,101 @@
+package stats
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/protocol/livekit"
+)
+
+func TestParseProcStat(t *testing.T) {
+	stat := "4242 (ingress (run)) S 1 4242 4242 0 -1 4194560 58036 0 0 0 1234 567 0 0 20 0 42 0 1337 2384289792 61953 18446744073709551615"
+
+	cpuTime, err := parseProcStat([]byte(stat))
+	require.NoError(t, err)
+	require.Equal(t, 18010*time.Millisecond, cpuTime)
+
+	_, err = parseProcStat([]byte("4242 (ingress) S 1"))
+	require.Error(t, err)
+}
+
+func TestCPUSamples(t *testing.T) {
+	s := &cpuSamples{}
+	for i := 1; i <= 100; i++ {
+		s.add(float64(i) / 100)
+	}
+	require.Equal(t, 0.95, s.percentile(0.95))
+	require.InDelta(t, 0.505, s.mean(), 1e-9)
+
+	// The oldest samples are replaced
+	for i := 0; i < maxCPUSamples; i++ {
+		s.add(2)
+	}
+	require.Len(t, s.values, maxCPUSamples)
+	require.Equal(t, 2.0, s.percentile(0.95))
+	require.Equal(t, 2.0, s.mean())
+}
+
+func TestCPUAccountingCost(t *testing.T) {
+	a := newCPUAccounting("NE_test")
+	info := &livekit.IngressInfo{
+		InputType: livekit.IngressInput_RTMP_INPUT,
+		Video: &livekit.IngressVideoOptions{
+			EncodingOptions: &livekit.IngressVideoOptions_Preset{
+				Preset: livekit.IngressVideoEncodingPreset_H264_1080P_30FPS_3_LAYERS,
+			},
+		},
+	}
+	key := getCostKey(info)
+	require.Equal(t, costKey{inputType: "rtmp", transcoding: true, preset: "H264_1080P_30FPS_3_LAYERS"}, key)
+	require.Equal(t, costKey{inputType: "whip", transcoding: false, preset: "none"}, getCostKey(&livekit.IngressInfo{
+		InputType:         livekit.IngressInput_WHIP_INPUT,
+		BypassTranscoding: true,
+	}))
+
+	a.samples[key] = &cpuSamples{}
+	for i := 0; i < 10; i++ {
+		a.samples[key].add(1.5)
+	}
+
+	_, ok := a.getCost(key, 20)
+	require.False(t, ok)
+
+	cost, ok := a.getCost(key, 10)
+	require.True(t, ok)
+	require.Equal(t, 1.5, cost)
+}
+
+func TestCPUAccountingBackup(t *testing.T) {
+	a := newCPUAccounting("NE_test")
+	info := &livekit.IngressInfo{IngressId: "IN_test", StreamKey: "key", InputType: livekit.IngressInput_RTMP_INPUT}
+	key := getCostKey(info)
+	a.addHandler(info, os.Getpid())
+
+	backup := false
+	isDecodingBackup := func(*livekit.IngressInfo) bool { return backup }
+	count := func() int {
+		if a.samples[key] == nil {
+			return 0
+		}
+		return len(a.samples[key].values)
+	}
+
+	now := time.Now()
+	a.sample(now, isDecodingBackup)
+	a.sample(now.Add(cpuSampleInterval), isDecodingBackup)
+	require.Equal(t, 1, count())
+
+	// Not sampled while the backup publisher is decoded as well, nor over the period it disconnected in
+	backup = true
+	a.sample(now.Add(2*cpuSampleInterval), isDecodingBackup)
+	a.sample(now.Add(3*cpuSampleInterval), isDecodingBackup)
+	backup = false
+	a.sample(now.Add(4*cpuSampleInterval), isDecodingBackup)
+	require.Equal(t, 1, count())
+
+	a.sample(now.Add(5*cpuSampleInterval), isDecodingBackup)
+	require.Equal(t, 2, count())
+}
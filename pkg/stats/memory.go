@@ -0,0 +1,67 @@
+package stats
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/livekit/ingress/pkg/cgroup"
+)
+
+// readAvailableMemory returns the memory that can be used by new handlers without swapping, or reaching the memory limit
+// of the cgroup of the service
+func readAvailableMemory() (uint64, error) {
+	b, err := os.ReadFile("/proc/meminfo")
+	if err != nil {
+		return 0, err
+	}
+	available, err := parseMemAvailable(b)
+	if err != nil {
+		return 0, err
+	}
+
+	// Not running in a cgroup v2 hierarchy otherwise
+	if headroom, err := cgroup.MemoryHeadroom(); err == nil && headroom < available {
+		available = headroom
+	}
+
+	return available, nil
+}
+
+// ReadProcessMemory returns the resident memory of a process
+func ReadProcessMemory(pid int) (uint64, error) {
+	b, err := os.ReadFile(fmt.Sprintf("/proc/%d/status", pid))
+	if err != nil {
+		return 0, err
+	}
+
+	return parseKBValue(b, "VmRSS:")
+}
+
+// parseMemAvailable returns the MemAvailable value found in the content of /proc/meminfo, in bytes
+func parseMemAvailable(b []byte) (uint64, error) {
+	return parseKBValue(b, "MemAvailable:")
+}
+
+// parseKBValue returns the value of the "<key> <value> kB" line of files like /proc/meminfo and /proc/<pid>/status,
+// in bytes
+func parseKBValue(b []byte, key string) (uint64, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for scanner.Scan() {
+		value, ok := strings.CutPrefix(scanner.Text(), key)
+		if !ok {
+			continue
+		}
+
+		kB, err := strconv.ParseUint(strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(value), "kB")), 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		return kB << 10, nil
+	}
+
+	return 0, fmt.Errorf("no %s value found", strings.TrimSuffix(key, ":"))
+}
@@ -0,0 +1,29 @@
+package stats
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMemAvailable(t *testing.T) {
+	meminfo := "MemTotal:       16316412 kB\nMemFree:         1245432 kB\nMemAvailable:    9872344 kB\nBuffers:          402048 kB\n"
+
+	available, err := parseMemAvailable([]byte(meminfo))
+	require.NoError(t, err)
+	require.Equal(t, uint64(9872344*1024), available)
+
+	_, err = parseMemAvailable([]byte("MemTotal:       16316412 kB\n"))
+	require.Error(t, err)
+}
+
+func TestParseKBValue(t *testing.T) {
+	status := "Name:\tingress\nState:\tS (sleeping)\nVmPeak:\t 2384289 kB\nVmRSS:\t  247812 kB\nThreads:\t42\n"
+
+	rss, err := parseKBValue([]byte(status), "VmRSS:")
+	require.NoError(t, err)
+	require.Equal(t, uint64(247812*1024), rss)
+
+	_, err = parseKBValue([]byte(status), "VmSwap:")
+	require.Error(t, err)
+}
@@ -5,30 +5,50 @@ import (
 	"sort"
 	"time"
 
+	"github.com/frostbyte73/core"
 	"github.com/prometheus/client_golang/prometheus"
 	"go.uber.org/atomic"
 
 	"github.com/livekit/ingress/pkg/config"
 	"github.com/livekit/ingress/pkg/errors"
+	"github.com/livekit/ingress/pkg/types"
 	"github.com/livekit/protocol/livekit"
 	"github.com/livekit/protocol/logger"
 	"github.com/livekit/protocol/utils"
 )
 
+const (
+	// time for the memory used by a new handler to show in the available memory
+	memoryHoldDuration = 10 * time.Second
+)
+
 type Monitor struct {
-	cpuCostConfig config.CPUCostConfig
-	maxCost       float64
+	cpuCostConfig    config.CPUCostConfig
+	rtmpBackup       bool
+	maxCost          float64
+	memoryCostConfig config.MemoryCostConfig
+	maxMemoryCost    uint64
 
 	promCPULoad  prometheus.Gauge
 	requestGauge *prometheus.GaugeVec
+	handlerExits *prometheus.CounterVec
+	sessions     *sessionMetrics
 
-	cpuStats *utils.CPUStats
+	cpuStats         *utils.CPUStats
+	cpuAccounting    *cpuAccounting
+	isDecodingBackup func(info *livekit.IngressInfo) bool
 
 	pendingCPUs atomic.Float64
+	// bytes, 0 if it could not be sampled
+	availableMemory atomic.Uint64
+	pendingMemory   atomic.Uint64
+	done            core.Fuse
 }
 
 func NewMonitor() *Monitor {
-	return &Monitor{}
+	return &Monitor{
+		done: core.NewFuse(),
+	}
 }
 
 func (m *Monitor) Start(conf *config.Config) error {
@@ -39,10 +59,15 @@ func (m *Monitor) Start(conf *config.Config) error {
 		return err
 	}
 	m.cpuStats = cpuStats
+	m.cpuCostConfig = conf.CPUCost
+	m.rtmpBackup = conf.RTMPBackup
+	m.memoryCostConfig = conf.MemoryCost
 
-	if err := m.checkCPUConfig(conf.CPUCost); err != nil {
+	if err := m.checkCPUConfig(conf); err != nil {
 		return err
 	}
+	m.sampleMemory()
+	m.checkMemoryConfig()
 
 	m.promCPULoad = prometheus.NewGauge(prometheus.GaugeOpts{
 		Namespace:   "livekit",
@@ -69,7 +94,33 @@ func (m *Monitor) Start(conf *config.Config) error {
 		ConstLabels: prometheus.Labels{"node_id": conf.NodeID},
 	}, []string{"type", "transcoding"})
 
-	prometheus.MustRegister(m.promCPULoad, promNodeAvailable, m.requestGauge)
+	promMemoryAvailable := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace:   "livekit",
+		Subsystem:   "ingress",
+		Name:        "memory_available_bytes",
+		Help:        "Memory available for new ingresses, minus the memory cost of the ones just admitted",
+		ConstLabels: prometheus.Labels{"node_id": conf.NodeID},
+	}, func() float64 {
+		available, _ := m.getAvailableMemory()
+		return float64(available)
+	})
+
+	m.handlerExits = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   "livekit",
+		Subsystem:   "ingress",
+		Name:        "handler_exits",
+		Help:        "Handler processes ended, by exit classification",
+		ConstLabels: prometheus.Labels{"node_id": conf.NodeID},
+	}, []string{"exit"})
+
+	m.sessions = newSessionMetrics(conf.NodeID)
+	m.cpuAccounting = newCPUAccounting(conf.NodeID)
+
+	prometheus.MustRegister(m.promCPULoad, promNodeAvailable, promMemoryAvailable, m.requestGauge, m.handlerExits)
+	prometheus.MustRegister(m.sessions.collectors()...)
+	prometheus.MustRegister(m.cpuAccounting.collectors()...)
+
+	go m.sample()
 
 	return nil
 }
@@ -78,9 +129,80 @@ func (m *Monitor) Stop() {
 	if m.cpuStats != nil {
 		m.cpuStats.Stop()
 	}
+	m.done.Break()
+}
+
+func (m *Monitor) sample() {
+	ticker := time.NewTicker(cpuSampleInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.done.Watch():
+			return
+		case now := <-ticker.C:
+			m.cpuAccounting.sample(now, m.isDecodingBackup)
+			m.sampleMemory()
+		}
+	}
+}
+
+func (m *Monitor) sampleMemory() {
+	available, err := readAvailableMemory()
+	if err != nil {
+		logger.Debugw("could not read available memory", "error", err)
+		return
+	}
+
+	m.availableMemory.Store(available)
+}
+
+// getAvailableMemory returns the memory available for new ingresses, if known
+func (m *Monitor) getAvailableMemory() (uint64, bool) {
+	available := m.availableMemory.Load()
+	if available == 0 {
+		return 0, false
+	}
+
+	pending := m.pendingMemory.Load()
+	if pending > available {
+		return 0, true
+	}
+	return available - pending, true
 }
 
-func (m *Monitor) checkCPUConfig(costConfig config.CPUCostConfig) error {
+func (m *Monitor) checkMemoryConfig() {
+	c := m.memoryCostConfig
+	costs := []uint64{
+		c.RTMPMemoryCost,
+		c.RTMPBypassTranscodingMemoryCost,
+		c.WHIPMemoryCost,
+		c.WHIPBypassTranscodingMemoryCost,
+		c.SRTMemoryCost,
+		c.UDPMemoryCost,
+		c.WebSocketMemoryCost,
+		c.RTSPMemoryCost,
+		c.URLMemoryCost,
+	}
+
+	m.maxMemoryCost = 0
+	for _, cost := range costs {
+		if cost<<20 > m.maxMemoryCost {
+			m.maxMemoryCost = cost << 20
+		}
+	}
+
+	if available, ok := m.getAvailableMemory(); ok && available < m.maxMemoryCost {
+		logger.Warnw("not enough memory for some ingress types", nil,
+			"minimum memory", m.maxMemoryCost,
+			"available", available,
+		)
+	}
+}
+
+func (m *Monitor) checkCPUConfig(conf *config.Config) error {
+	costConfig := conf.CPUCost
+
 	if costConfig.RTMPCpuCost < 1 {
 		logger.Warnw("rtmp input requirement too low", nil,
 			"config value", costConfig.RTMPCpuCost,
@@ -89,6 +211,14 @@ func (m *Monitor) checkCPUConfig(costConfig config.CPUCostConfig) error {
 		)
 	}
 
+	if costConfig.RTMPBypassTranscodingCpuCost < 0.2 {
+		logger.Warnw("rtmp input with transcoding bypassed requirement too low", nil,
+			"config value", costConfig.RTMPBypassTranscodingCpuCost,
+			"minimum value", 0.2,
+			"recommended value", 0.4,
+		)
+	}
+
 	if costConfig.WHIPCpuCost < 1 {
 		logger.Warnw("whip input requirement too low", nil,
 			"config value", costConfig.WHIPCpuCost,
@@ -107,9 +237,81 @@ func (m *Monitor) checkCPUConfig(costConfig config.CPUCostConfig) error {
 
 	requirements := []float64{
 		costConfig.RTMPCpuCost,
+		costConfig.RTMPBypassTranscodingCpuCost,
 		costConfig.WHIPCpuCost,
 		costConfig.WHIPBypassTranscodingCpuCost,
 	}
+
+	if conf.RTMPBackup {
+		if costConfig.RTMPBackupCpuCost < 0.5 {
+			logger.Warnw("rtmp backup requirement too low", nil,
+				"config value", costConfig.RTMPBackupCpuCost,
+				"minimum value", 0.5,
+				"recommended value", 1,
+			)
+		}
+
+		requirements = append(requirements, costConfig.RTMPCpuCost+costConfig.RTMPBackupCpuCost)
+	}
+
+	if conf.SRTPort > 0 {
+		if costConfig.SRTCpuCost < 1 {
+			logger.Warnw("srt input requirement too low", nil,
+				"config value", costConfig.SRTCpuCost,
+				"minimum value", 1,
+				"recommended value", 2,
+			)
+		}
+
+		requirements = append(requirements, costConfig.SRTCpuCost)
+	}
+
+	if conf.WHIPPort > 0 {
+		// WebSocket ingest is served by the WHIP server
+		if costConfig.WebSocketCpuCost < 1 {
+			logger.Warnw("websocket input requirement too low", nil,
+				"config value", costConfig.WebSocketCpuCost,
+				"minimum value", 1,
+				"recommended value", 2,
+			)
+		}
+
+		requirements = append(requirements, costConfig.WebSocketCpuCost)
+	}
+
+	if len(conf.UDPInputs) > 0 {
+		if costConfig.UDPCpuCost < 1 {
+			logger.Warnw("udp input requirement too low", nil,
+				"config value", costConfig.UDPCpuCost,
+				"minimum value", 1,
+				"recommended value", 2,
+			)
+		}
+
+		requirements = append(requirements, costConfig.UDPCpuCost)
+	}
+
+	if conf.HealthPort > 0 {
+		// Pull ingresses are started through the health port
+		if costConfig.RTSPCpuCost < 1 {
+			logger.Warnw("rtsp input requirement too low", nil,
+				"config value", costConfig.RTSPCpuCost,
+				"minimum value", 1,
+				"recommended value", 2,
+			)
+		}
+
+		if costConfig.URLCpuCost < 1 {
+			logger.Warnw("url input requirement too low", nil,
+				"config value", costConfig.URLCpuCost,
+				"minimum value", 1,
+				"recommended value", 2,
+			)
+		}
+
+		requirements = append(requirements, costConfig.RTSPCpuCost, costConfig.URLCpuCost)
+	}
+
 	sort.Float64s(requirements)
 	m.maxCost = requirements[len(requirements)-1]
 
@@ -146,57 +348,206 @@ func (m *Monitor) GetCPULoad() float64 {
 
 func (m *Monitor) CanAcceptIngress() bool {
 	available := m.cpuStats.GetCPUIdle() - m.pendingCPUs.Load()
+	if available <= m.maxCost {
+		return false
+	}
+
+	if availableMemory, ok := m.getAvailableMemory(); ok && availableMemory <= m.maxMemoryCost {
+		return false
+	}
 
-	return available > m.maxCost
+	return true
 }
 
 func (m *Monitor) AcceptIngress(info *livekit.IngressInfo) bool {
-	var cpuHold float64
-	var accept bool
 	available := m.cpuStats.GetCPUIdle() - m.pendingCPUs.Load()
 
-	switch info.InputType {
-	case livekit.IngressInput_RTMP_INPUT:
-		accept = available > m.cpuCostConfig.RTMPCpuCost
-		cpuHold = m.cpuCostConfig.RTMPCpuCost
-	case livekit.IngressInput_WHIP_INPUT:
-		if info.BypassTranscoding {
-			accept = available > m.cpuCostConfig.WHIPBypassTranscodingCpuCost
-			cpuHold = m.cpuCostConfig.WHIPBypassTranscodingCpuCost
-		} else {
-			accept = available > m.cpuCostConfig.WHIPCpuCost
-			cpuHold = m.cpuCostConfig.WHIPCpuCost
-		}
-
-	default:
+	cpuHold, ok := m.getAdmissionCost(info)
+	if !ok {
 		logger.Errorw("unsupported request type", errors.New("invalid parameter"))
 	}
+	accept := ok && available > cpuHold
+
+	memoryHold := m.GetMemoryCost(info)
+	availableMemory, memoryKnown := m.getAvailableMemory()
+	if memoryKnown && availableMemory <= memoryHold {
+		logger.Debugw("not enough memory", "availableMemory", availableMemory, "memoryCost", memoryHold)
+		accept = false
+	}
 
 	if accept {
 		m.pendingCPUs.Add(cpuHold)
 		time.AfterFunc(time.Second, func() { m.pendingCPUs.Sub(cpuHold) })
+
+		m.pendingMemory.Add(memoryHold)
+		time.AfterFunc(memoryHoldDuration, func() { m.pendingMemory.Sub(memoryHold) })
 	}
 
 	logger.Debugw("cpu request", "accepted", accept, "availableCPUs", available, "numCPUs", m.cpuStats.NumCPU())
 	return accept
 }
 
-func (m *Monitor) IngressStarted(info *livekit.IngressInfo) {
+// AcceptRTMPBackup charges the decoding of a backup publisher joining a running RTMP ingress, whose primary publisher is
+// still connected. The decoding of the backup is only charged once it connects, not when the ingress is admitted
+func (m *Monitor) AcceptRTMPBackup() bool {
+	available := m.cpuStats.GetCPUIdle() - m.pendingCPUs.Load()
+
+	cpuHold := m.cpuCostConfig.RTMPBackupCpuCost
+	accept := available > cpuHold
+	if accept {
+		m.pendingCPUs.Add(cpuHold)
+		time.AfterFunc(time.Second, func() { m.pendingCPUs.Sub(cpuHold) })
+	}
+
+	logger.Debugw("rtmp backup cpu request", "accepted", accept, "availableCPUs", available, "numCPUs", m.cpuStats.NumCPU())
+	return accept
+}
+
+// OnBackupDecodingCheck registers the function telling whether the handler of an ingress decodes a backup publisher
+// as well. The CPU measured meanwhile is not included in the measured cost of the ingress
+func (m *Monitor) OnBackupDecodingCheck(f func(info *livekit.IngressInfo) bool) {
+	m.isDecodingBackup = f
+}
+
+// GetMemoryCost returns the memory in bytes an ingress is expected to use, as configured for its input type
+func (m *Monitor) GetMemoryCost(info *livekit.IngressInfo) uint64 {
+	c := m.memoryCostConfig
+
+	var cost uint64
 	switch info.InputType {
 	case livekit.IngressInput_RTMP_INPUT:
-		m.requestGauge.With(prometheus.Labels{"type": "rtmp", "transcoding": fmt.Sprintf("%v", !info.BypassTranscoding)}).Add(1)
+		if info.BypassTranscoding {
+			cost = c.RTMPBypassTranscodingMemoryCost
+		} else {
+			cost = c.RTMPMemoryCost
+		}
 	case livekit.IngressInput_WHIP_INPUT:
-		m.requestGauge.With(prometheus.Labels{"type": "whip", "transcoding": fmt.Sprintf("%v", !info.BypassTranscoding)}).Add(1)
+		if info.BypassTranscoding {
+			cost = c.WHIPBypassTranscodingMemoryCost
+		} else {
+			cost = c.WHIPMemoryCost
+		}
+	case types.SRTInput:
+		cost = c.SRTMemoryCost
+	case types.UDPInput:
+		cost = c.UDPMemoryCost
+	case types.WebSocketInput:
+		cost = c.WebSocketMemoryCost
+	case types.RTSPInput:
+		cost = c.RTSPMemoryCost
+	case types.URLInput:
+		cost = c.URLMemoryCost
 	}
 
+	return cost << 20
 }
 
-func (m *Monitor) IngressEnded(info *livekit.IngressInfo) {
+// getAdmissionCost returns the measured CPU cost of an ingress if enabled and known, its configured cost otherwise. With
+// cgroups, the measured cost is capped by the CPU quota of the handlers, which includes the configured headroom
+func (m *Monitor) getAdmissionCost(info *livekit.IngressInfo) (float64, bool) {
+	if m.cpuCostConfig.UseMeasuredCost && m.cpuAccounting != nil {
+		if cost, ok := m.cpuAccounting.getCost(getCostKey(info), m.cpuCostConfig.MinMeasuredSamples); ok {
+			return cost, true
+		}
+	}
+
+	return m.GetCPUCost(info)
+}
+
+// GetCPULimit returns the CPUs an ingress can use at most: its cost, plus the decoding of a backup publisher for RTMP
+func (m *Monitor) GetCPULimit(info *livekit.IngressInfo) (float64, bool) {
+	cost, ok := m.GetCPUCost(info)
+	if ok && info.InputType == livekit.IngressInput_RTMP_INPUT && m.rtmpBackup {
+		// The media of the backup publisher is decoded as well while connected, for the failover to be immediate
+		cost += m.cpuCostConfig.RTMPBackupCpuCost
+	}
+
+	return cost, ok
+}
+
+// GetCPUCost returns the CPUs an ingress is expected to use, as configured for its input type
+func (m *Monitor) GetCPUCost(info *livekit.IngressInfo) (float64, bool) {
 	switch info.InputType {
 	case livekit.IngressInput_RTMP_INPUT:
-		m.requestGauge.With(prometheus.Labels{"type": "rtmp", "transcoding": fmt.Sprintf("%v", !info.BypassTranscoding)}).Sub(1)
+		if info.BypassTranscoding {
+			return m.cpuCostConfig.RTMPBypassTranscodingCpuCost, true
+		}
+		return m.cpuCostConfig.RTMPCpuCost, true
 	case livekit.IngressInput_WHIP_INPUT:
-		m.requestGauge.With(prometheus.Labels{"type": "whip", "transcoding": fmt.Sprintf("%v", !info.BypassTranscoding)}).Sub(1)
+		if info.BypassTranscoding {
+			return m.cpuCostConfig.WHIPBypassTranscodingCpuCost, true
+		}
+		return m.cpuCostConfig.WHIPCpuCost, true
+	case types.SRTInput:
+		return m.cpuCostConfig.SRTCpuCost, true
+	case types.UDPInput:
+		return m.cpuCostConfig.UDPCpuCost, true
+	case types.WebSocketInput:
+		return m.cpuCostConfig.WebSocketCpuCost, true
+	case types.RTSPInput:
+		return m.cpuCostConfig.RTSPCpuCost, true
+	case types.URLInput:
+		return m.cpuCostConfig.URLCpuCost, true
+	default:
+		return 0, false
+	}
+}
+
+func (m *Monitor) IngressStarted(info *livekit.IngressInfo) {
+	m.requestGauge.With(requestLabels(info)).Add(1)
+}
+
+// UpdateSessionMetrics exports the media quality metrics reported by the handler of an ingress
+func (m *Monitor) UpdateSessionMetrics(ingressID string, report *HandlerReport) {
+	if m.sessions != nil {
+		m.sessions.update(ingressID, report)
+	}
+}
+
+// UpdateHandlerResources exports the limits and usage of the cgroup of the handler of an ingress
+func (m *Monitor) UpdateHandlerResources(ingressID string, r *HandlerResources) {
+	if m.sessions != nil {
+		m.sessions.updateHandler(ingressID, r)
+	}
+}
+
+// UpdateRTPMetrics exports the RTP reception statistics of a WHIP ingress
+func (m *Monitor) UpdateRTPMetrics(ingressID string, rtpStats map[types.StreamKind]*RTPStats) {
+	if m.sessions == nil {
+		return
+	}
+
+	for kind, st := range rtpStats {
+		m.sessions.updateRTP(ingressID, string(kind), st)
+	}
+}
+
+// HandlerStarted starts measuring the CPU used by the handler process of an ingress, replacing the previous one if restarted
+func (m *Monitor) HandlerStarted(info *livekit.IngressInfo, pid int) {
+	if m.cpuAccounting != nil {
+		m.cpuAccounting.addHandler(info, pid)
+	}
+}
+
+func (m *Monitor) IngressEnded(info *livekit.IngressInfo) {
+	if m.sessions != nil {
+		m.sessions.delete(info.IngressId)
+	}
+	if m.cpuAccounting != nil {
+		m.cpuAccounting.removeHandler(info.IngressId)
+	}
+
+	m.requestGauge.With(requestLabels(info)).Sub(1)
+}
+
+// requestLabels returns the labels of the ingresses counted in the request gauge
+func requestLabels(info *livekit.IngressInfo) prometheus.Labels {
+	return prometheus.Labels{"type": types.InputTypeName(info.InputType), "transcoding": fmt.Sprintf("%v", !info.BypassTranscoding)}
+}
 
+// HandlerExited counts the handler processes ended, by exit classification
+func (m *Monitor) HandlerExited(exit string) {
+	if m.handlerExits != nil {
+		m.handlerExits.With(prometheus.Labels{"exit": exit}).Inc()
 	}
 }
@@ -0,0 +1,69 @@
+package stats
+
+import (
+	"encoding/json"
+)
+
+// HandlerReport is sent by the handler process of an ingress to the service, when its state changes and periodically
+// while it runs
+type HandlerReport struct {
+	// protojson encoded livekit.IngressState
+	State json.RawMessage `json:"state"`
+	// bits per second received from the publisher or source, all tracks included
+	InputBitrate uint64 `json:"input_bitrate"`
+	// waiting for the publisher or source to reconnect, the state is buffering meanwhile
+	Reconnecting bool `json:"reconnecting,omitempty"`
+
+	Inputs  []*InputReport  `json:"inputs,omitempty"`
+	Outputs []*OutputReport `json:"outputs,omitempty"`
+}
+
+// InputReport describes the media of a kind received by the handler, before decoding
+type InputReport struct {
+	Kind string `json:"kind"`
+	// bits per second
+	Bitrate uint64 `json:"bitrate"`
+	// only for video
+	Width     uint32  `json:"width,omitempty"`
+	Height    uint32  `json:"height,omitempty"`
+	FrameRate float64 `json:"frame_rate,omitempty"`
+	// seconds between the last two key frames, only for video
+	KeyFrameInterval float64 `json:"key_frame_interval,omitempty"`
+	// discontinuities in the FLV tag timestamps since the ingress started, only for RTMP
+	TimestampGaps uint64 `json:"timestamp_gaps,omitempty"`
+}
+
+// OutputReport describes the media sent to the room for a track, or a simulcast layer of the video track
+type OutputReport struct {
+	Kind  string `json:"kind"`
+	Layer string `json:"layer,omitempty"`
+	// bits per second
+	Bitrate uint64 `json:"bitrate"`
+	// samples that had to wait for the room output to catch up since the ingress started
+	SamplesDelayed uint64 `json:"samples_delayed"`
+	// seconds spent in the encoder, moving average. Not set when transcoding is bypassed
+	EncoderLatency float64 `json:"encoder_latency,omitempty"`
+	// RMS level in dBFS over the report interval, from -127 (silence) to 0, only for audio
+	AudioLevel float64 `json:"audio_level,omitempty"`
+}
+
+// RTPStats are the reception statistics of the RTP streams of a kind, all simulcast layers included
+type RTPStats struct {
+	PacketsReceived uint64
+	PacketsLost     int64
+	NACKs           uint64
+	// seconds, highest of the streams
+	Jitter float64
+}
+
+// HandlerResources are the limits and usage of the cgroup of a handler process
+type HandlerResources struct {
+	// CPUs, 0 if unlimited
+	CPULimit float64
+	// bytes, 0 if unlimited
+	MemoryLimit uint64
+	// CPUs used on average since the previous sample
+	CPUUsage    float64
+	MemoryUsage uint64
+	OOMKills    uint64
+}
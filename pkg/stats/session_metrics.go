@@ -0,0 +1,143 @@
+package stats
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// sessionMetrics are the media quality metrics of the ingresses running on the node, labeled by ingress ID
+type sessionMetrics struct {
+	inputBitrate          *prometheus.GaugeVec
+	inputFrameRate        *prometheus.GaugeVec
+	inputKeyFrameInterval *prometheus.GaugeVec
+	inputTimestampGaps    *prometheus.GaugeVec
+	outputBitrate         *prometheus.GaugeVec
+	outputSamplesDelayed  *prometheus.GaugeVec
+	outputEncoderLatency  *prometheus.GaugeVec
+	outputAudioLevel      *prometheus.GaugeVec
+	rtpPacketsReceived    *prometheus.GaugeVec
+	rtpPacketsLost        *prometheus.GaugeVec
+	rtpJitter             *prometheus.GaugeVec
+	rtpNACKs              *prometheus.GaugeVec
+	handlerCPULimit       *prometheus.GaugeVec
+	handlerCPUUsage       *prometheus.GaugeVec
+	handlerMemoryLimit    *prometheus.GaugeVec
+	handlerMemoryUsage    *prometheus.GaugeVec
+	handlerOOMKills       *prometheus.GaugeVec
+}
+
+func newSessionMetrics(nodeID string) *sessionMetrics {
+	newHandlerGaugeVec := func(name string, help string, labels ...string) *prometheus.GaugeVec {
+		return prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace:   "livekit",
+			Subsystem:   "ingress",
+			Name:        name,
+			Help:        help,
+			ConstLabels: prometheus.Labels{"node_id": nodeID},
+		}, append([]string{"ingress_id"}, labels...))
+	}
+	newGaugeVec := func(name string, help string, labels ...string) *prometheus.GaugeVec {
+		return newHandlerGaugeVec(name, help, append([]string{"kind"}, labels...)...)
+	}
+
+	return &sessionMetrics{
+		inputBitrate:          newGaugeVec("input_bitrate", "Bits per second received from the publisher or source"),
+		inputFrameRate:        newGaugeVec("input_frame_rate", "Frames per second received from the publisher or source"),
+		inputKeyFrameInterval: newGaugeVec("input_key_frame_interval_seconds", "Time between the last two key frames received"),
+		inputTimestampGaps:    newGaugeVec("input_timestamp_gaps", "Discontinuities in the FLV tag timestamps received over RTMP"),
+		outputBitrate:         newGaugeVec("output_bitrate", "Bits per second sent to the room, per simulcast layer", "layer"),
+		outputSamplesDelayed:  newGaugeVec("output_samples_delayed", "Samples that had to wait for the room output to catch up", "layer"),
+		outputEncoderLatency:  newGaugeVec("output_encoder_latency_seconds", "Time spent in the encoder", "layer"),
+		outputAudioLevel:      newGaugeVec("output_audio_level_dbfs", "RMS level of the audio published"),
+		rtpPacketsReceived:    newGaugeVec("rtp_packets_received", "RTP packets received over WHIP"),
+		rtpPacketsLost:        newGaugeVec("rtp_packets_lost", "RTP packets lost over WHIP"),
+		rtpJitter:             newGaugeVec("rtp_jitter_seconds", "Interarrival jitter of the RTP packets received over WHIP"),
+		rtpNACKs:              newGaugeVec("rtp_nacks", "NACKs sent to the WHIP publisher"),
+		handlerCPULimit:       newHandlerGaugeVec("handler_cpu_limit", "CPUs the handler process is limited to"),
+		handlerCPUUsage:       newHandlerGaugeVec("handler_cpu_usage", "CPUs used by the handler process"),
+		handlerMemoryLimit:    newHandlerGaugeVec("handler_memory_limit_bytes", "Memory the handler process is limited to"),
+		handlerMemoryUsage:    newHandlerGaugeVec("handler_memory_usage_bytes", "Memory used by the handler process"),
+		handlerOOMKills:       newHandlerGaugeVec("handler_oom_kills", "Handler processes killed for reaching the memory limit"),
+	}
+}
+
+func (s *sessionMetrics) collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		s.inputBitrate,
+		s.inputFrameRate,
+		s.inputKeyFrameInterval,
+		s.inputTimestampGaps,
+		s.outputBitrate,
+		s.outputSamplesDelayed,
+		s.outputEncoderLatency,
+		s.outputAudioLevel,
+		s.rtpPacketsReceived,
+		s.rtpPacketsLost,
+		s.rtpJitter,
+		s.rtpNACKs,
+		s.handlerCPULimit,
+		s.handlerCPUUsage,
+		s.handlerMemoryLimit,
+		s.handlerMemoryUsage,
+		s.handlerOOMKills,
+	}
+}
+
+func (s *sessionMetrics) update(ingressID string, report *HandlerReport) {
+	for _, in := range report.Inputs {
+		labels := prometheus.Labels{"ingress_id": ingressID, "kind": in.Kind}
+
+		s.inputBitrate.With(labels).Set(float64(in.Bitrate))
+		if in.FrameRate > 0 {
+			s.inputFrameRate.With(labels).Set(in.FrameRate)
+		}
+		if in.KeyFrameInterval > 0 {
+			s.inputKeyFrameInterval.With(labels).Set(in.KeyFrameInterval)
+		}
+		if in.TimestampGaps > 0 {
+			s.inputTimestampGaps.With(labels).Set(float64(in.TimestampGaps))
+		}
+	}
+
+	for _, out := range report.Outputs {
+		labels := prometheus.Labels{"ingress_id": ingressID, "kind": out.Kind, "layer": out.Layer}
+
+		s.outputBitrate.With(labels).Set(float64(out.Bitrate))
+		s.outputSamplesDelayed.With(labels).Set(float64(out.SamplesDelayed))
+		if out.EncoderLatency > 0 {
+			s.outputEncoderLatency.With(labels).Set(out.EncoderLatency)
+		}
+		if out.AudioLevel < 0 {
+			s.outputAudioLevel.With(prometheus.Labels{"ingress_id": ingressID, "kind": out.Kind}).Set(out.AudioLevel)
+		}
+	}
+}
+
+func (s *sessionMetrics) updateRTP(ingressID string, kind string, st *RTPStats) {
+	labels := prometheus.Labels{"ingress_id": ingressID, "kind": kind}
+
+	s.rtpPacketsReceived.With(labels).Set(float64(st.PacketsReceived))
+	s.rtpPacketsLost.With(labels).Set(float64(st.PacketsLost))
+	s.rtpJitter.With(labels).Set(st.Jitter)
+	s.rtpNACKs.With(labels).Set(float64(st.NACKs))
+}
+
+func (s *sessionMetrics) updateHandler(ingressID string, r *HandlerResources) {
+	labels := prometheus.Labels{"ingress_id": ingressID}
+
+	if r.CPULimit > 0 {
+		s.handlerCPULimit.With(labels).Set(r.CPULimit)
+	}
+	if r.MemoryLimit > 0 {
+		s.handlerMemoryLimit.With(labels).Set(float64(r.MemoryLimit))
+	}
+	s.handlerCPUUsage.With(labels).Set(r.CPUUsage)
+	s.handlerMemoryUsage.With(labels).Set(float64(r.MemoryUsage))
+	s.handlerOOMKills.With(labels).Set(float64(r.OOMKills))
+}
+
+func (s *sessionMetrics) delete(ingressID string) {
+	labels := prometheus.Labels{"ingress_id": ingressID}
+	for _, c := range s.collectors() {
+		c.(*prometheus.GaugeVec).DeletePartialMatch(labels)
+	}
+}
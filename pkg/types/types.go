@@ -1,5 +1,9 @@
 package types
 
+import (
+	"github.com/livekit/protocol/livekit"
+)
+
 type StreamKind string
 
 const (
@@ -8,3 +12,45 @@ const (
 	Interleaved            = "interleaved"
 	Unknown                = "unknown"
 )
+
+// Suffix appended to the stream key of an RTMP ingress by its backup publisher
+const BackupStreamKeySuffix = "_backup"
+
+// Input types supported by this service but not (yet) part of the livekit.IngressInput enum.
+// Values are kept well clear of the protocol range to avoid collisions when it grows. The LiveKit server does not know
+// them, so ingresses with these types can only be defined in the ingresses file of standalone mode.
+const (
+	SRTInput livekit.IngressInput = 100 + iota
+	RTSPInput
+	URLInput
+	UDPInput
+	WebSocketInput
+)
+
+// IsCustomInput returns true for the input types not part of the protocol. The control plane cannot create ingresses
+// with these types, so they are only available in standalone mode
+func IsCustomInput(inputType livekit.IngressInput) bool {
+	return inputType >= SRTInput
+}
+
+// InputTypeName returns a short name for the input type, including the ones not part of the protocol
+func InputTypeName(inputType livekit.IngressInput) string {
+	switch inputType {
+	case livekit.IngressInput_RTMP_INPUT:
+		return "rtmp"
+	case livekit.IngressInput_WHIP_INPUT:
+		return "whip"
+	case SRTInput:
+		return "srt"
+	case RTSPInput:
+		return "rtsp"
+	case URLInput:
+		return "url"
+	case UDPInput:
+		return "udp"
+	case WebSocketInput:
+		return "websocket"
+	default:
+		return "unknown"
+	}
+}
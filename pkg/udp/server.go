@@ -0,0 +1,306 @@
+package udp
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/frostbyte73/core"
+	"go.uber.org/atomic"
+	"golang.org/x/net/ipv4"
+
+	"github.com/livekit/ingress/pkg/config"
+	"github.com/livekit/ingress/pkg/errors"
+	"github.com/livekit/ingress/pkg/utils"
+	"github.com/livekit/protocol/logger"
+)
+
+const (
+	maxDatagramSize = 65536
+
+	// A session ends when no packet is received for this long
+	idleTimeout = 5 * time.Second
+	// Time to wait before submitting the stream again after it was rejected
+	admissionRetryInterval = 10 * time.Second
+)
+
+// UDPServer listens to the MPEG-TS streams sent over UDP, and relays them to the handlers of the ingresses they are mapped to.
+// A session starts with the first packet received on a listener, and ends when the stream stops.
+type UDPServer struct {
+	listeners []*udpListener
+	handlers  sync.Map
+}
+
+func NewUDPServer() *UDPServer {
+	return &UDPServer{}
+}
+
+func (s *UDPServer) Start(conf *config.Config, onPublish func(streamKey, remoteAddr string) error) error {
+	for _, c := range conf.UDPInputs {
+		l, err := newUDPListener(c)
+		if err != nil {
+			logger.Errorw("failed to start UDP listener", err, "port", c.Port, "streamKey", c.StreamKey)
+			s.Stop()
+			return err
+		}
+		s.listeners = append(s.listeners, l)
+
+		go l.run(s, onPublish)
+	}
+
+	return nil
+}
+
+func (s *UDPServer) AssociateRelay(streamKey string, w io.WriteCloser) error {
+	h, ok := s.handlers.Load(streamKey)
+	if ok && h != nil {
+		err := h.(*UDPHandler).SetWriter(w)
+		if err != nil {
+			return err
+		}
+	} else {
+		return errors.ErrIngressNotFound
+	}
+
+	return nil
+}
+
+func (s *UDPServer) DissociateRelay(streamKey string) error {
+	h, ok := s.handlers.Load(streamKey)
+	if ok && h != nil {
+		// The handler is gone, the next packets start a new session
+		h.(*UDPHandler).OnClose()
+	} else {
+		return errors.ErrIngressNotFound
+	}
+
+	return nil
+}
+
+func (s *UDPServer) Stop() error {
+	for _, l := range s.listeners {
+		l.conn.Close()
+	}
+
+	return nil
+}
+
+type udpListener struct {
+	conf   config.UDPInputConfig
+	conn   *net.UDPConn
+	source net.IP
+	log    logger.Logger
+
+	// set when the stream is rejected, admission is not retried for admissionRetryInterval
+	rejectedAt atomic.Time
+}
+
+func newUDPListener(conf config.UDPInputConfig) (*udpListener, error) {
+	l := &udpListener{
+		conf:   conf,
+		source: net.ParseIP(conf.SourceAddress),
+		log:    logger.GetLogger().WithValues("streamKey", conf.StreamKey, "port", conf.Port),
+	}
+
+	if conf.MulticastGroup == "" {
+		conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP(conf.BindAddress), Port: conf.Port})
+		if err != nil {
+			return nil, err
+		}
+		l.conn = conn
+
+		return l, nil
+	}
+
+	group := &net.UDPAddr{IP: net.ParseIP(conf.MulticastGroup)}
+
+	// Binding to the group address keeps the traffic of other groups on the same port out
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: group.IP, Port: conf.Port})
+	if err != nil {
+		return nil, err
+	}
+
+	ifi, err := interfaceByAddress(conf.BindAddress)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	p := ipv4.NewPacketConn(conn)
+	if l.source != nil {
+		err = p.JoinSourceSpecificGroup(ifi, group, &net.UDPAddr{IP: l.source})
+	} else {
+		err = p.JoinGroup(ifi, group)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	l.conn = conn
+
+	return l, nil
+}
+
+// interfaceByAddress returns the network interface with the given address, or nil to let the system pick one
+func interfaceByAddress(address string) (*net.Interface, error) {
+	if address == "" {
+		return nil, nil
+	}
+	ip := net.ParseIP(address)
+
+	ifis, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	for _, ifi := range ifis {
+		addrs, err := ifi.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.Equal(ip) {
+				ifi := ifi
+				return &ifi, nil
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("no network interface with address %s", address)
+}
+
+func (l *udpListener) run(s *UDPServer, onPublish func(streamKey, remoteAddr string) error) {
+	var h *UDPHandler
+
+	defer func() {
+		if h != nil {
+			h.OnClose()
+		}
+	}()
+
+	buf := make([]byte, maxDatagramSize)
+	for {
+		if err := l.conn.SetReadDeadline(time.Now().Add(idleTimeout)); err != nil {
+			l.log.Errorw("failed to set UDP read deadline", err)
+			return
+		}
+
+		n, addr, err := l.conn.ReadFromUDP(buf)
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				if h != nil {
+					l.log.Infow("UDP stream stopped")
+					h.OnClose()
+					h = nil
+				}
+				continue
+			}
+			if !errors.Is(err, net.ErrClosed) {
+				l.log.Errorw("failed to read UDP packet", err)
+			}
+			return
+		}
+
+		if l.source != nil && !addr.IP.Equal(l.source) {
+			continue
+		}
+
+		if h != nil && h.closed.IsBroken() {
+			h = nil
+		}
+		if h == nil {
+			if time.Since(l.rejectedAt.Load()) < admissionRetryInterval {
+				continue
+			}
+
+			newHandler := NewUDPHandler(l.conf, l.log.WithValues("remoteAddr", addr.String()))
+			newHandler.OnCloseCallback(func(streamKey string) {
+				s.handlers.CompareAndDelete(streamKey, newHandler)
+			})
+			h = newHandler
+
+			// The packets are buffered until the handler connects, admission runs in the background
+			// so that the PAT/PMT and the first keyframe are not dropped in the meantime
+			s.handlers.Store(l.conf.StreamKey, h)
+			l.log.Infow("Received a new published stream", "remoteAddr", addr.String())
+
+			if onPublish != nil {
+				remoteAddr := addr.String()
+				go func() {
+					if err := onPublish(l.conf.StreamKey, remoteAddr); err != nil {
+						l.log.Infow("rejecting UDP stream", "error", err)
+						l.rejectedAt.Store(time.Now())
+						newHandler.OnClose()
+					}
+				}()
+			}
+		}
+
+		h.Write(buf[:n])
+	}
+}
+
+type UDPHandler struct {
+	streamKey   string
+	filter      *tsFilter
+	mediaBuffer *utils.PrerollBuffer
+	out         []byte
+
+	log logger.Logger
+
+	closed  core.Fuse
+	onClose func(streamKey string)
+}
+
+func NewUDPHandler(conf config.UDPInputConfig, log logger.Logger) *UDPHandler {
+	h := &UDPHandler{
+		streamKey: conf.StreamKey,
+		filter:    newTSFilter(log, conf.ProgramNumber, conf.PIDs),
+		log:       log,
+		closed:    core.NewFuse(),
+	}
+
+	h.mediaBuffer = utils.NewPrerollBuffer(func() error {
+		// MPEG-TS is self synchronizing, the demuxer will pick up at the next PAT/PMT
+		h.log.Infow("preroll buffer reset event")
+
+		return nil
+	})
+
+	return h
+}
+
+func (h *UDPHandler) OnCloseCallback(cb func(streamKey string)) {
+	h.onClose = cb
+}
+
+func (h *UDPHandler) Write(datagram []byte) {
+	h.out = h.filter.Filter(h.out[:0], datagram)
+	if len(h.out) == 0 {
+		return
+	}
+
+	if _, err := h.mediaBuffer.Write(h.out); err != nil {
+		h.log.Errorw("failed to write media", err)
+	}
+}
+
+func (h *UDPHandler) OnClose() {
+	h.closed.Once(func() {
+		h.log.Infow("closing ingress UDP session",
+			"ccErrors", h.filter.ccErrors,
+			"pcrDiscontinuities", h.filter.pcrDiscontinuities,
+		)
+
+		h.mediaBuffer.Close()
+
+		if h.onClose != nil {
+			h.onClose(h.streamKey)
+		}
+	})
+}
+
+func (h *UDPHandler) SetWriter(w io.WriteCloser) error {
+	return h.mediaBuffer.SetWriter(w)
+}
@@ -0,0 +1,295 @@
+package udp
+
+import (
+	"github.com/livekit/protocol/logger"
+)
+
+const (
+	tsPacketSize = 188
+	tsSyncByte   = 0x47
+
+	patPID  = 0x0000
+	nullPID = 0x1fff
+	// PIDs below this one carry the PAT, CAT and other service information tables
+	firstElementaryPID = 0x0020
+
+	patTableID = 0x00
+	pmtTableID = 0x02
+
+	// 33 bit PCR base, in 90kHz units
+	pcrBaseModulo = 1 << 33
+	// PCR are sent at least every 100ms. Larger jumps are the sign of a new time base, e.g. after an encoder restart
+	maxPCRJump = 10 * 90000
+)
+
+// tsFilter processes the MPEG-TS packets received from a UDP source before they are relayed to the handler.
+// It keeps the selected program and elementary streams only, tracks continuity counter gaps, and flags PCR
+// discontinuities that the source did not signal, so that the demuxer of the handler resets its clock instead of
+// stalling on timestamps far in the past or in the future.
+type tsFilter struct {
+	logger logger.Logger
+
+	program int             // 0 for all programs
+	pids    map[uint16]bool // empty for all the streams of the selected programs
+
+	pmtPIDs map[uint16]bool
+	esPIDs  map[uint16]bool
+	pcrPIDs map[uint16]bool
+
+	continuity map[uint16]byte
+	lastPCR    map[uint16]uint64
+
+	ccErrors           int
+	pcrDiscontinuities int
+}
+
+func newTSFilter(l logger.Logger, program int, pids []uint16) *tsFilter {
+	f := &tsFilter{
+		logger:     l,
+		program:    program,
+		pids:       make(map[uint16]bool),
+		pmtPIDs:    make(map[uint16]bool),
+		esPIDs:     make(map[uint16]bool),
+		pcrPIDs:    make(map[uint16]bool),
+		continuity: make(map[uint16]byte),
+		lastPCR:    make(map[uint16]uint64),
+	}
+	for _, pid := range pids {
+		f.pids[pid] = true
+	}
+
+	return f
+}
+
+// Filter processes a datagram, and appends the packets to forward to out.
+// Datagrams may carry any number of packets, possibly after an encapsulation header.
+func (f *tsFilter) Filter(out []byte, datagram []byte) []byte {
+	for i := 0; i+tsPacketSize <= len(datagram); {
+		if datagram[i] != tsSyncByte || (i+tsPacketSize < len(datagram) && datagram[i+tsPacketSize] != tsSyncByte) {
+			// Not aligned on a packet
+			i++
+			continue
+		}
+
+		pkt := datagram[i : i+tsPacketSize]
+		if f.processPacket(pkt) {
+			out = append(out, pkt...)
+		}
+		i += tsPacketSize
+	}
+
+	return out
+}
+
+// processPacket updates the filter state with the packet, possibly rewriting it, and returns true if it should be forwarded
+func (f *tsFilter) processPacket(pkt []byte) bool {
+	pid := uint16(pkt[1]&0x1f)<<8 | uint16(pkt[2])
+	if pid == nullPID {
+		return false
+	}
+
+	if !f.isSelected(pid) {
+		return false
+	}
+
+	payloadStart := 4
+	adaptationFieldControl := pkt[3] >> 4 & 0x03
+	if adaptationFieldControl&0x02 != 0 {
+		payloadStart += 1 + int(pkt[4])
+		f.checkPCR(pid, pkt)
+	}
+	if adaptationFieldControl&0x01 != 0 {
+		f.checkContinuity(pid, pkt[3]&0x0f)
+	}
+
+	if pkt[1]&0x40 != 0 && payloadStart < tsPacketSize && (pid == patPID || f.pmtPIDs[pid]) {
+		// Start of a PSI section
+		f.processSection(pkt[payloadStart:])
+	}
+
+	return true
+}
+
+func (f *tsFilter) isSelected(pid uint16) bool {
+	switch {
+	case pid < firstElementaryPID:
+		return true
+	case f.program == 0 && len(f.pids) == 0:
+		return true
+	case f.pmtPIDs[pid], f.pcrPIDs[pid]:
+		return true
+	case len(f.pids) > 0:
+		return f.pids[pid]
+	default:
+		return f.esPIDs[pid]
+	}
+}
+
+func (f *tsFilter) checkContinuity(pid uint16, cc byte) {
+	last, ok := f.continuity[pid]
+	f.continuity[pid] = cc
+	if !ok || cc == last {
+		// First packet or duplicate
+		return
+	}
+
+	if cc != (last+1)&0x0f {
+		f.ccErrors++
+		f.logger.Debugw("MPEG-TS continuity counter gap", "pid", pid, "expected", (last+1)&0x0f, "received", cc)
+	}
+}
+
+func (f *tsFilter) checkPCR(pid uint16, pkt []byte) {
+	// Adaptation field length, flags, then a 6 byte PCR if the PCR flag is set
+	if pkt[4] < 7 || pkt[5]&0x10 == 0 {
+		return
+	}
+
+	pcr := uint64(pkt[6])<<25 | uint64(pkt[7])<<17 | uint64(pkt[8])<<9 | uint64(pkt[9])<<1 | uint64(pkt[10])>>7
+
+	last, ok := f.lastPCR[pid]
+	f.lastPCR[pid] = pcr
+	if !ok || pkt[5]&0x80 != 0 {
+		// First PCR, or discontinuity signaled by the source
+		return
+	}
+
+	delta := (pcr - last) % pcrBaseModulo
+	if delta > maxPCRJump {
+		// Backwards, or too far forward
+		f.pcrDiscontinuities++
+		f.logger.Infow("unsignaled MPEG-TS PCR discontinuity", "pid", pid, "last", last, "pcr", pcr)
+		pkt[5] |= 0x80
+	}
+}
+
+// processSection learns the PMT and elementary stream PIDs from the PAT and PMT, and removes the unselected
+// programs and streams from them. Only sections contained in a single packet are rewritten.
+func (f *tsFilter) processSection(payload []byte) {
+	pointer := int(payload[0])
+	if 1+pointer+3 > len(payload) {
+		return
+	}
+	section := payload[1+pointer:]
+
+	sectionLength := int(section[1]&0x0f)<<8 | int(section[2])
+	if 3+sectionLength > len(section) || sectionLength < 9 {
+		return
+	}
+	section = section[:3+sectionLength]
+
+	var rewritten []byte
+	switch section[0] {
+	case patTableID:
+		rewritten = f.processPAT(section)
+	case pmtTableID:
+		rewritten = f.processPMT(section)
+	default:
+		return
+	}
+
+	if rewritten != nil {
+		// The rewritten section is never longer than the original one
+		n := copy(payload[1+pointer:], rewritten)
+		for i := 1 + pointer + n; i < len(payload); i++ {
+			payload[i] = 0xff
+		}
+	}
+}
+
+func (f *tsFilter) processPAT(section []byte) []byte {
+	header := section[:8]
+	entries := section[8 : len(section)-4]
+
+	pmtPIDs := make(map[uint16]bool)
+	var kept []byte
+	for i := 0; i+4 <= len(entries); i += 4 {
+		program := int(entries[i])<<8 | int(entries[i+1])
+		if program == 0 {
+			// Network information table
+			kept = append(kept, entries[i:i+4]...)
+			continue
+		}
+		if f.program != 0 && program != f.program {
+			continue
+		}
+
+		pmtPIDs[uint16(entries[i+2]&0x1f)<<8|uint16(entries[i+3])] = true
+		kept = append(kept, entries[i:i+4]...)
+	}
+	f.pmtPIDs = pmtPIDs
+
+	if f.program == 0 || len(kept) == len(entries) {
+		return nil
+	}
+
+	return newSection(header, kept)
+}
+
+func (f *tsFilter) processPMT(section []byte) []byte {
+	if len(section) < 16 {
+		return nil
+	}
+
+	f.pcrPIDs[uint16(section[8]&0x1f)<<8|uint16(section[9])] = true
+
+	programInfoLength := int(section[10]&0x0f)<<8 | int(section[11])
+	if 12+programInfoLength > len(section)-4 {
+		return nil
+	}
+	header := section[:12+programInfoLength]
+	streams := section[12+programInfoLength : len(section)-4]
+
+	var kept []byte
+	for i := 0; i+5 <= len(streams); {
+		esInfoLength := int(streams[i+3]&0x0f)<<8 | int(streams[i+4])
+		end := i + 5 + esInfoLength
+		if end > len(streams) {
+			return nil
+		}
+
+		pid := uint16(streams[i+1]&0x1f)<<8 | uint16(streams[i+2])
+		if len(f.pids) == 0 || f.pids[pid] {
+			f.esPIDs[pid] = true
+			kept = append(kept, streams[i:end]...)
+		}
+		i = end
+	}
+
+	if len(f.pids) == 0 || len(kept) == len(streams) {
+		return nil
+	}
+
+	return newSection(header, kept)
+}
+
+// newSection builds a PSI section from the header of an existing one and a new body, with updated length and CRC
+func newSection(header []byte, body []byte) []byte {
+	section := make([]byte, 0, len(header)+len(body)+4)
+	section = append(section, header...)
+	section = append(section, body...)
+
+	sectionLength := len(section) - 3 + 4
+	section[1] = section[1]&0xf0 | byte(sectionLength>>8)&0x0f
+	section[2] = byte(sectionLength)
+
+	crc := crc32MPEG2(section)
+
+	return append(section, byte(crc>>24), byte(crc>>16), byte(crc>>8), byte(crc))
+}
+
+func crc32MPEG2(data []byte) uint32 {
+	crc := uint32(0xffffffff)
+	for _, b := range data {
+		crc ^= uint32(b) << 24
+		for i := 0; i < 8; i++ {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ 0x04c11db7
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+
+	return crc
+}
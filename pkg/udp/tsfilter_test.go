@@ -0,0 +1,162 @@
+package udp
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/protocol/logger"
+)
+
+func newTestPacket(pid uint16, cc byte, payloadStart bool, payload []byte) []byte {
+	pkt := make([]byte, tsPacketSize)
+	for i := range pkt {
+		pkt[i] = 0xff
+	}
+
+	pkt[0] = tsSyncByte
+	pkt[1] = byte(pid>>8) & 0x1f
+	if payloadStart {
+		pkt[1] |= 0x40
+	}
+	pkt[2] = byte(pid)
+	pkt[3] = 0x10 | cc&0x0f
+	copy(pkt[4:], payload)
+
+	return pkt
+}
+
+func newTestPCRPacket(pid uint16, cc byte, pcr uint64) []byte {
+	pkt := newTestPacket(pid, cc, false, nil)
+	pkt[3] = 0x30 | cc&0x0f
+	pkt[4] = 7
+	pkt[5] = 0x10
+	pkt[6] = byte(pcr >> 25)
+	pkt[7] = byte(pcr >> 17)
+	pkt[8] = byte(pcr >> 9)
+	pkt[9] = byte(pcr >> 1)
+	pkt[10] = byte(pcr<<7) | 0x7e
+
+	return pkt
+}
+
+func newTestPSIPacket(pid uint16, tableID byte, header []byte, body []byte) []byte {
+	h := append([]byte{tableID, 0xb0, 0x00, 0x00, 0x01, 0xc1, 0x00, 0x00}, header...)
+	return newTestPacket(pid, 0, true, append([]byte{0x00}, newSection(h, body)...))
+}
+
+func newTestPAT(programs map[uint16]uint16) []byte {
+	var body []byte
+	for program, pmtPID := range programs {
+		body = binary.BigEndian.AppendUint16(body, program)
+		body = binary.BigEndian.AppendUint16(body, 0xe000|pmtPID)
+	}
+
+	return newTestPSIPacket(patPID, patTableID, nil, body)
+}
+
+func newTestPMT(pmtPID uint16, pcrPID uint16, streams map[uint16]byte) []byte {
+	header := []byte{0xe0 | byte(pcrPID>>8), byte(pcrPID), 0xf0, 0x00}
+
+	var body []byte
+	for pid, streamType := range streams {
+		body = append(body, streamType)
+		body = binary.BigEndian.AppendUint16(body, 0xe000|pid)
+		body = append(body, 0xf0, 0x00)
+	}
+
+	return newTestPSIPacket(pmtPID, pmtTableID, header, body)
+}
+
+func parseTestSection(t *testing.T, pkt []byte) []byte {
+	section := pkt[5+pkt[4]:]
+	sectionLength := int(section[1]&0x0f)<<8 | int(section[2])
+	section = section[:3+sectionLength]
+
+	crc := crc32MPEG2(section[:len(section)-4])
+	require.Equal(t, crc, binary.BigEndian.Uint32(section[len(section)-4:]))
+
+	return section
+}
+
+func TestProgramSelection(t *testing.T) {
+	f := newTSFilter(logger.GetLogger(), 2, nil)
+
+	out := f.Filter(nil, newTestPAT(map[uint16]uint16{1: 0x100, 2: 0x200}))
+	require.Len(t, out, tsPacketSize)
+
+	// Only the selected program is left in the PAT
+	pat := parseTestSection(t, out)
+	require.Equal(t, []byte{0x00, 0x02, 0xe2, 0x00}, pat[8:len(pat)-4])
+
+	require.Empty(t, f.Filter(nil, newTestPMT(0x100, 0x101, map[uint16]byte{0x101: 0x1b})))
+	require.Len(t, f.Filter(nil, newTestPMT(0x200, 0x201, map[uint16]byte{0x201: 0x1b, 0x202: 0x0f})), tsPacketSize)
+
+	require.Empty(t, f.Filter(nil, newTestPacket(0x101, 0, true, nil)))
+	require.Len(t, f.Filter(nil, newTestPacket(0x201, 0, true, nil)), tsPacketSize)
+	require.Len(t, f.Filter(nil, newTestPacket(0x202, 0, true, nil)), tsPacketSize)
+}
+
+func TestPIDSelection(t *testing.T) {
+	f := newTSFilter(logger.GetLogger(), 0, []uint16{0x102})
+
+	require.Len(t, f.Filter(nil, newTestPAT(map[uint16]uint16{1: 0x100})), tsPacketSize)
+
+	out := f.Filter(nil, newTestPMT(0x100, 0x101, map[uint16]byte{0x101: 0x1b, 0x102: 0x0f}))
+	require.Len(t, out, tsPacketSize)
+
+	// Only the selected stream is left in the PMT
+	pmt := parseTestSection(t, out)
+	require.Equal(t, []byte{0x0f, 0xe1, 0x02, 0xf0, 0x00}, pmt[12:len(pmt)-4])
+
+	// The PCR PID is kept
+	require.Len(t, f.Filter(nil, newTestPacket(0x101, 0, true, nil)), tsPacketSize)
+	require.Len(t, f.Filter(nil, newTestPacket(0x102, 0, true, nil)), tsPacketSize)
+	require.Empty(t, f.Filter(nil, newTestPacket(0x103, 0, true, nil)))
+	require.Empty(t, f.Filter(nil, newTestPacket(nullPID, 0, true, nil)))
+}
+
+func TestContinuityCounterGaps(t *testing.T) {
+	f := newTSFilter(logger.GetLogger(), 0, nil)
+
+	var datagram []byte
+	for _, cc := range []byte{14, 15, 0, 0, 1, 3} {
+		datagram = append(datagram, newTestPacket(0x100, cc, false, nil)...)
+	}
+
+	// Packets are forwarded regardless of the gaps
+	out := f.Filter(nil, datagram)
+	require.Len(t, out, 6*tsPacketSize)
+	require.Equal(t, 1, f.ccErrors)
+}
+
+func TestPCRDiscontinuity(t *testing.T) {
+	f := newTSFilter(logger.GetLogger(), 0, nil)
+
+	f.Filter(nil, newTestPCRPacket(0x100, 0, 900000))
+	out := f.Filter(nil, newTestPCRPacket(0x100, 1, 909000))
+	require.Zero(t, out[5]&0x80)
+
+	// Encoder restart
+	out = f.Filter(nil, newTestPCRPacket(0x100, 2, 1000))
+	require.NotZero(t, out[5]&0x80)
+	require.Equal(t, 1, f.pcrDiscontinuities)
+
+	// Wrap around
+	f.Filter(nil, newTestPCRPacket(0x100, 3, pcrBaseModulo-1000))
+	out = f.Filter(nil, newTestPCRPacket(0x100, 4, 2000))
+	require.Zero(t, out[5]&0x80)
+	require.Equal(t, 2, f.pcrDiscontinuities)
+}
+
+func TestUnalignedDatagram(t *testing.T) {
+	f := newTSFilter(logger.GetLogger(), 0, nil)
+
+	// e.g. RTP header
+	datagram := append(make([]byte, 12), newTestPacket(0x100, 0, false, nil)...)
+	datagram = append(datagram, newTestPacket(0x100, 1, false, nil)...)
+
+	out := f.Filter(nil, datagram)
+	require.Equal(t, datagram[12:], out)
+}
@@ -0,0 +1,82 @@
+package utils
+
+import (
+	"crypto/tls"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/livekit/protocol/logger"
+)
+
+// CertificateReloader provides a TLS certificate loaded from disk, and reloads it
+// whenever the certificate or key file is updated, without requiring a restart.
+type CertificateReloader struct {
+	certFile string
+	keyFile  string
+
+	lock        sync.Mutex
+	cert        *tls.Certificate
+	certModTime time.Time
+	keyModTime  time.Time
+}
+
+func NewCertificateReloader(certFile, keyFile string) (*CertificateReloader, error) {
+	r := &CertificateReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+
+	if err := r.maybeReload(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// GetCertificate is meant to be used as tls.Config.GetCertificate
+func (r *CertificateReloader) GetCertificate(_ *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if err := r.maybeReload(); err != nil {
+		if r.cert == nil {
+			return nil, err
+		}
+
+		// The files may be in the process of being replaced. Keep using the previous certificate and retry on the next handshake
+		logger.Warnw("failed to reload certificate", err, "certFile", r.certFile, "keyFile", r.keyFile)
+	}
+
+	return r.cert, nil
+}
+
+func (r *CertificateReloader) maybeReload() error {
+	certInfo, err := os.Stat(r.certFile)
+	if err != nil {
+		return err
+	}
+	keyInfo, err := os.Stat(r.keyFile)
+	if err != nil {
+		return err
+	}
+
+	if r.cert != nil && certInfo.ModTime().Equal(r.certModTime) && keyInfo.ModTime().Equal(r.keyModTime) {
+		return nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+
+	if r.cert != nil {
+		logger.Infow("reloaded certificate", "certFile", r.certFile, "keyFile", r.keyFile)
+	}
+
+	r.cert = &cert
+	r.certModTime = certInfo.ModTime()
+	r.keyModTime = keyInfo.ModTime()
+
+	return nil
+}
@@ -0,0 +1,75 @@
+package utils
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func writeCertificate(t *testing.T, certFile, keyFile string, commonName string, modTime time.Time) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+	require.NoError(t, os.Chtimes(certFile, modTime, modTime))
+	require.NoError(t, os.Chtimes(keyFile, modTime, modTime))
+}
+
+func getCommonName(t *testing.T, r *CertificateReloader) string {
+	cert, err := r.GetCertificate(nil)
+	require.NoError(t, err)
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	require.NoError(t, err)
+
+	return leaf.Subject.CommonName
+}
+
+func TestCertificateReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+
+	_, err := NewCertificateReloader(certFile, keyFile)
+	require.Error(t, err)
+
+	start := time.Now().Add(-time.Minute)
+	writeCertificate(t, certFile, keyFile, "first", start)
+
+	r, err := NewCertificateReloader(certFile, keyFile)
+	require.NoError(t, err)
+	require.Equal(t, "first", getCommonName(t, r))
+
+	// Reloaded when the files change
+	writeCertificate(t, certFile, keyFile, "second", start.Add(time.Second))
+	require.Equal(t, "second", getCommonName(t, r))
+
+	// The previous certificate is kept while the files are invalid
+	require.NoError(t, os.WriteFile(keyFile, []byte("partial"), 0600))
+	require.Equal(t, "second", getCommonName(t, r))
+
+	require.NoError(t, os.Remove(certFile))
+	require.Equal(t, "second", getCommonName(t, r))
+}
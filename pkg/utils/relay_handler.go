@@ -0,0 +1,82 @@
+package utils
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/livekit/protocol/logger"
+	"github.com/livekit/psrpc"
+)
+
+// StreamRelay is implemented by the servers whose publishers, identified by their stream key, are relayed to the
+// handler processes
+type StreamRelay interface {
+	AssociateRelay(streamKey string, w io.WriteCloser) error
+	DissociateRelay(streamKey string) error
+}
+
+// StreamRelayHandler relays the media of the publisher whose stream key follows the prefix in the request path
+type StreamRelayHandler struct {
+	prefix string
+	relay  StreamRelay
+}
+
+func NewStreamRelayHandler(prefix string, relay StreamRelay) *StreamRelayHandler {
+	return &StreamRelayHandler{
+		prefix: prefix,
+		relay:  relay,
+	}
+}
+
+func (h *StreamRelayHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	streamKey := strings.TrimPrefix(r.URL.Path, h.prefix)
+
+	log := logger.Logger(logger.GetLogger().WithValues("streamKey", streamKey))
+
+	ServeRelay(w, func(pw io.WriteCloser) error {
+		if err := h.relay.AssociateRelay(streamKey, pw); err != nil {
+			return err
+		}
+
+		log.Infow("relaying ingress")
+		return nil
+	}, func() {
+		_ = h.relay.DissociateRelay(streamKey)
+	})
+}
+
+// ServeRelay copies the media of a publisher to the response, until the publisher closes the writer it is given by
+// associate or the handler process disconnects. dissociate, if set, is called once the copy has ended
+func ServeRelay(w http.ResponseWriter, associate func(w io.WriteCloser) error, dissociate func()) {
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+
+	go func() {
+		_, err := io.Copy(w, pr)
+		done <- err
+	}()
+
+	if err := associate(pw); err != nil {
+		// Ends the copy before replying. The handlers poll for backup publishers, the association fails often
+		_ = pw.Close()
+		<-done
+
+		var psrpcErr psrpc.Error
+		if errors.As(err, &psrpcErr) {
+			w.WriteHeader(psrpcErr.ToHttp())
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		return
+	}
+
+	<-done
+
+	// Unblocks the publisher if the handler process disconnected
+	_ = pw.Close()
+	if dissociate != nil {
+		dissociate()
+	}
+}
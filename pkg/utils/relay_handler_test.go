@@ -0,0 +1,58 @@
+package utils
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/atomic"
+
+	"github.com/livekit/psrpc"
+)
+
+type testStreamRelay struct {
+	streamKey    string
+	dissociated  atomic.Bool
+	associations atomic.Int32
+}
+
+func (r *testStreamRelay) AssociateRelay(streamKey string, w io.WriteCloser) error {
+	if streamKey != r.streamKey {
+		return psrpc.NewErrorf(psrpc.NotFound, "ingress not found")
+	}
+	r.associations.Inc()
+
+	go func() {
+		_, _ = w.Write([]byte("media"))
+		_ = w.Close()
+	}()
+
+	return nil
+}
+
+func (r *testStreamRelay) DissociateRelay(_ string) error {
+	r.dissociated.Store(true)
+	return nil
+}
+
+func TestStreamRelayHandler(t *testing.T) {
+	relay := &testStreamRelay{streamKey: "key"}
+	h := NewStreamRelayHandler("/rtmp/", relay)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/rtmp/key", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "media", w.Body.String())
+	require.Equal(t, int32(1), relay.associations.Load())
+	require.True(t, relay.dissociated.Load())
+
+	// Unknown stream keys, like the ones of the backup publishers polled by the handlers, are rejected
+	relay.dissociated.Store(false)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/rtmp/key_backup", nil))
+	require.Equal(t, http.StatusNotFound, w.Code)
+	require.Empty(t, w.Body.String())
+	require.False(t, relay.dissociated.Load())
+}
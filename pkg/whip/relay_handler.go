@@ -1,12 +1,12 @@
 package whip
 
 import (
-	"errors"
 	"io"
 	"net/http"
 	"strings"
 
 	"github.com/livekit/ingress/pkg/types"
+	"github.com/livekit/ingress/pkg/utils"
 	"github.com/livekit/protocol/logger"
 	"github.com/livekit/psrpc"
 )
@@ -22,24 +22,10 @@ func NewWHIPRelayHandler(whipServer *WHIPServer) *WHIPRelayHandler {
 }
 
 func (h *WHIPRelayHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	var err error
-	defer func() {
-		var psrpcErr psrpc.Error
-
-		switch {
-		case errors.As(err, &psrpcErr):
-			w.WriteHeader(psrpcErr.ToHttp())
-		case err == nil:
-			// Nothing, we already responded
-		default:
-			w.WriteHeader(http.StatusInternalServerError)
-		}
-	}()
-
-	path := strings.TrimLeft(r.URL.Path, "/whip/")
+	path := strings.TrimPrefix(r.URL.Path, "/whip/")
 	v := strings.Split(path, "/")
 	if len(v) != 2 {
-		err = psrpc.NewErrorf(psrpc.NotFound, "invalid path")
+		w.WriteHeader(psrpc.NewErrorf(psrpc.NotFound, "invalid path").ToHttp())
 		return
 	}
 	resourceId := v[0]
@@ -48,23 +34,24 @@ func (h *WHIPRelayHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	log := logger.Logger(logger.GetLogger().WithValues("resourceId", resourceId, "kind", kind))
 	log.Infow("relaying whip ingress")
 
-	pr, pw := io.Pipe()
-	done := make(chan error)
+	utils.ServeRelay(w, func(pw io.WriteCloser) error {
+		return h.whipServer.AssociateRelay(resourceId, kind, pw)
+	}, nil)
+}
 
-	go func() {
-		_, err = io.Copy(w, pr)
-		done <- err
-		close(done)
-	}()
+// webSocketRelay relays the WebSocket publishers of the server, by stream key
+type webSocketRelay struct {
+	whipServer *WHIPServer
+}
 
-	err = h.whipServer.AssociateRelay(resourceId, kind, pw)
-	if err != nil {
-		return
-	}
+func (r *webSocketRelay) AssociateRelay(streamKey string, w io.WriteCloser) error {
+	return r.whipServer.AssociateWebSocketRelay(streamKey, w)
+}
 
-	defer func() {
-		pw.Close()
-	}()
+func (r *webSocketRelay) DissociateRelay(streamKey string) error {
+	return r.whipServer.DissociateWebSocketRelay(streamKey)
+}
 
-	err = <-done
+func NewWebSocketRelayHandler(whipServer *WHIPServer) *utils.StreamRelayHandler {
+	return utils.NewStreamRelayHandler("/websocket/", &webSocketRelay{whipServer: whipServer})
 }
@@ -3,7 +3,9 @@ package whip
 import (
 	"bytes"
 	"io"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/Eyevinn/mp4ff/avc"
@@ -14,12 +16,18 @@ import (
 
 	"github.com/livekit/ingress/pkg/errors"
 	"github.com/livekit/ingress/pkg/lksdk_output"
+	"github.com/livekit/ingress/pkg/recorder"
 	"github.com/livekit/ingress/pkg/types"
 	"github.com/livekit/protocol/livekit"
 	"github.com/livekit/protocol/logger"
 	"github.com/livekit/psrpc"
 )
 
+const (
+	// time given to all the simulcast layers to start after the first one
+	simulcastLayerTimeout = 3 * time.Second
+)
+
 var (
 	ErrParamsUnavailable = psrpc.NewErrorf(psrpc.InvalidArgument, "codec parameters unavailable in sample")
 )
@@ -29,18 +37,27 @@ type SDKMediaSink struct {
 	writePLI  func()
 	track     *webrtc.TrackRemote
 	sdkOutput *lksdk_output.LKSDKOutput
+	layers    *videoLayerGroup        // only for video
+	recorder  *recorder.TrackRecorder // only for audio, video is recorded by the layer group
 
 	readySamples     chan *media.Sample
 	fuse             core.Fuse
 	trackInitialized bool
 }
 
-func NewSDKMediaSink(l logger.Logger, sdkOutput *lksdk_output.LKSDKOutput, track *webrtc.TrackRemote, writePLI func()) *SDKMediaSink {
+func NewSDKMediaSink(l logger.Logger, sdkOutput *lksdk_output.LKSDKOutput, track *webrtc.TrackRemote, layers *videoLayerGroup, rec *recorder.TrackRecorder, writePLI func()) *SDKMediaSink {
+	if layers == nil && track.Kind() == webrtc.RTPCodecTypeVideo {
+		layers = newVideoLayerGroup(l, sdkOutput, track.Codec().MimeType, 1, rec)
+		rec = nil
+	}
+
 	s := &SDKMediaSink{
 		logger:       l,
 		writePLI:     writePLI,
 		track:        track,
 		sdkOutput:    sdkOutput,
+		layers:       layers,
+		recorder:     rec,
 		readySamples: make(chan *media.Sample, 1),
 		fuse:         core.NewFuse(),
 	}
@@ -62,6 +79,12 @@ func (sp *SDKMediaSink) PushSample(s *media.Sample, ts time.Duration) error {
 		return nil
 	}
 
+	if sp.layers != nil {
+		sp.layers.record(sp, s, ts)
+	} else if sp.recorder != nil {
+		sp.recorder.WriteSample(s, ts)
+	}
+
 	select {
 	case <-sp.fuse.Watch():
 		return io.EOF
@@ -119,26 +142,32 @@ func (sp *SDKMediaSink) ensureTrackInitialized(s *media.Sample) error {
 		sp.logger.Infow("adding audio track", "stereo", stereo, "codec", mimeType)
 		sp.sdkOutput.AddAudioTrack(sp, mimeType, false, stereo)
 	case types.Video:
-		w, h, err := getVideoParams(mimeType, s)
-		switch err {
-		case nil:
-			// continue
-		case ErrParamsUnavailable:
-			return nil
-		default:
-			return err
+		if !sp.layers.hasLayer(sp) {
+			if sp.layers.isPublished() {
+				// The layer started after the deadline, it isn't part of the published track
+				return nil
+			}
+
+			w, h, err := getVideoParams(mimeType, s)
+			switch err {
+			case nil:
+				// continue
+			case ErrParamsUnavailable:
+				return nil
+			default:
+				return err
+			}
+
+			err = sp.layers.addLayer(sp, w, h)
+			if err != nil {
+				return err
+			}
 		}
 
-		// TODO extract proper dimensions from stream
-		layers := []*livekit.VideoLayer{
-			&livekit.VideoLayer{Width: uint32(w), Height: uint32(h), Quality: livekit.VideoQuality_HIGH},
-		}
-		s := []lksdk_output.VideoSampleProvider{
-			sp,
+		if !sp.layers.isPublished() {
+			// Wait for the dimensions of all simulcast layers to be known, or for the deadline
+			return nil
 		}
-
-		sp.logger.Infow("adding video track", "width", w, "height", h, "codec", mimeType)
-		sp.sdkOutput.AddVideoTrack(s, layers, mimeType)
 	}
 
 	sp.trackInitialized = true
@@ -187,3 +216,135 @@ func getVP8VideoParams(s *media.Sample) (uint, uint, error) {
 
 	return uint(fh.Width), uint(fh.Height), nil
 }
+
+// videoLayerGroup collects the simulcast layers of a video track, and publishes them
+// as a single simulcast track once the dimensions of all layers are known. Senders may never start,
+// or pause, some layers: the layers received so far are published simulcastLayerTimeout after the first one.
+type videoLayerGroup struct {
+	logger     logger.Logger
+	sdkOutput  *lksdk_output.LKSDKOutput
+	mimeType   string
+	layerCount int
+	recorder   *recorder.TrackRecorder // may be nil
+
+	lock         sync.Mutex
+	layers       map[*SDKMediaSink]*livekit.VideoLayer
+	deadline     *time.Timer
+	published    bool
+	recordedSink *SDKMediaSink
+}
+
+func newVideoLayerGroup(l logger.Logger, sdkOutput *lksdk_output.LKSDKOutput, mimeType string, layerCount int, rec *recorder.TrackRecorder) *videoLayerGroup {
+	return &videoLayerGroup{
+		logger:     l,
+		sdkOutput:  sdkOutput,
+		mimeType:   mimeType,
+		layerCount: layerCount,
+		recorder:   rec,
+		layers:     make(map[*SDKMediaSink]*livekit.VideoLayer),
+	}
+}
+
+func (g *videoLayerGroup) hasLayer(sink *SDKMediaSink) bool {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	_, ok := g.layers[sink]
+	return ok
+}
+
+func (g *videoLayerGroup) isPublished() bool {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	return g.published
+}
+
+// record writes the sample to the recorder if it belongs to the highest quality layer
+func (g *videoLayerGroup) record(sink *SDKMediaSink, s *media.Sample, ts time.Duration) {
+	if g.recorder == nil {
+		return
+	}
+
+	g.lock.Lock()
+	recorded := g.recordedSink == sink
+	g.lock.Unlock()
+
+	if recorded {
+		g.recorder.WriteSample(s, ts)
+	}
+}
+
+func (g *videoLayerGroup) addLayer(sink *SDKMediaSink, w uint, h uint) error {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	if g.published {
+		return nil
+	}
+
+	g.layers[sink] = &livekit.VideoLayer{Width: uint32(w), Height: uint32(h)}
+	if len(g.layers) < g.layerCount {
+		if g.deadline == nil {
+			g.deadline = time.AfterFunc(simulcastLayerTimeout, g.onDeadline)
+		}
+		return nil
+	}
+
+	return g.publishLocked()
+}
+
+func (g *videoLayerGroup) onDeadline() {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	if g.published {
+		return
+	}
+
+	g.logger.Infow("publishing the simulcast layers received before the deadline", "received", len(g.layers), "expected", g.layerCount)
+	if err := g.publishLocked(); err != nil {
+		g.logger.Warnw("could not publish video track", err)
+	}
+}
+
+func (g *videoLayerGroup) publishLocked() error {
+	if g.deadline != nil {
+		g.deadline.Stop()
+	}
+
+	sinks := make([]*SDKMediaSink, 0, len(g.layers))
+	for s := range g.layers {
+		sinks = append(sinks, s)
+	}
+	// Highest resolution first
+	sort.Slice(sinks, func(i, j int) bool {
+		li, lj := g.layers[sinks[i]], g.layers[sinks[j]]
+		return li.Width*li.Height > lj.Width*lj.Height
+	})
+
+	layers := make([]*livekit.VideoLayer, 0, len(sinks))
+	outputs := make([]lksdk_output.VideoSampleProvider, 0, len(sinks))
+	for i, s := range sinks {
+		layer := g.layers[s]
+		if i == 0 {
+			layer.Quality = livekit.VideoQuality_HIGH
+		} else {
+			layer.Quality = livekit.VideoQuality(len(sinks) - 1 - i)
+		}
+
+		g.logger.Infow("adding video layer", "width", layer.Width, "height", layer.Height, "quality", layer.Quality, "rid", s.track.RID(), "codec", g.mimeType)
+
+		layers = append(layers, layer)
+		outputs = append(outputs, s)
+	}
+
+	err := g.sdkOutput.AddVideoTrack(outputs, layers, g.mimeType)
+	if err != nil {
+		return err
+	}
+	g.published = true
+	g.recordedSink = sinks[0]
+
+	return nil
+}
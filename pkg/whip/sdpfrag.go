@@ -0,0 +1,211 @@
+package whip
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pion/sdp/v3"
+	"github.com/pion/webrtc/v3"
+
+	"github.com/livekit/ingress/pkg/errors"
+)
+
+// Trickle ICE SDP fragments, https://www.rfc-editor.org/rfc/rfc8840
+// The format is a subset of SDP, containing only the ICE related attributes:
+//
+//	a=ice-ufrag:EsAw
+//	a=ice-pwd:P2uYro0UCOQ4zxjKXaWCBui1
+//	m=audio 9 RTP/AVP 0
+//	a=mid:0
+//	a=candidate:1387637174 1 udp 2122260223 192.0.2.1 61764 typ host generation 0 ufrag EsAw network-id 1
+//	a=end-of-candidates
+
+const (
+	sdpFragmentMimeType = "application/trickle-ice-sdpfrag"
+)
+
+type sdpFragment struct {
+	iceUfrag string
+	icePwd   string
+
+	media []*sdpFragmentMedia
+}
+
+type sdpFragmentMedia struct {
+	mediaLine       string
+	mid             string
+	iceUfrag        string
+	icePwd          string
+	candidates      []string
+	endOfCandidates bool
+}
+
+func parseSDPFragment(frag string) (*sdpFragment, error) {
+	f := &sdpFragment{}
+
+	var m *sdpFragmentMedia
+	for _, line := range strings.Split(frag, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		typ, value, ok := strings.Cut(line, "=")
+		if !ok || len(typ) != 1 {
+			return nil, errors.ErrInvalidSDPFragment
+		}
+
+		switch typ {
+		case "m":
+			m = &sdpFragmentMedia{
+				mediaLine: value,
+			}
+			f.media = append(f.media, m)
+		case "a":
+			attr, attrValue, _ := strings.Cut(value, ":")
+
+			switch attr {
+			case "ice-ufrag":
+				if m != nil {
+					m.iceUfrag = attrValue
+				} else {
+					f.iceUfrag = attrValue
+				}
+			case "ice-pwd":
+				if m != nil {
+					m.icePwd = attrValue
+				} else {
+					f.icePwd = attrValue
+				}
+			case "mid":
+				if m == nil {
+					return nil, errors.ErrInvalidSDPFragment
+				}
+				m.mid = attrValue
+			case "candidate":
+				if m == nil {
+					return nil, errors.ErrInvalidSDPFragment
+				}
+				m.candidates = append(m.candidates, value)
+			case "end-of-candidates":
+				if m != nil {
+					m.endOfCandidates = true
+				} else {
+					// Session level end-of-candidates applies to all media sections
+					for _, m := range f.media {
+						m.endOfCandidates = true
+					}
+				}
+			}
+		}
+	}
+
+	return f, nil
+}
+
+// credentials returns the ICE ufrag and password of the fragment. Media level attributes take precedence over session level ones.
+func (f *sdpFragment) credentials() (string, string) {
+	ufrag, pwd := f.iceUfrag, f.icePwd
+	for _, m := range f.media {
+		if m.iceUfrag != "" {
+			ufrag = m.iceUfrag
+		}
+		if m.icePwd != "" {
+			pwd = m.icePwd
+		}
+	}
+
+	return ufrag, pwd
+}
+
+// iceCandidates returns the candidates in the fragment. End of candidates is signaled with an empty candidate.
+func (f *sdpFragment) iceCandidates() []webrtc.ICECandidateInit {
+	var candidates []webrtc.ICECandidateInit
+
+	for i, m := range f.media {
+		mid := m.mid
+		mLineIndex := uint16(i)
+
+		for _, c := range m.candidates {
+			candidates = append(candidates, webrtc.ICECandidateInit{
+				Candidate:     c,
+				SDPMid:        &mid,
+				SDPMLineIndex: &mLineIndex,
+			})
+		}
+
+		if m.endOfCandidates {
+			candidates = append(candidates, webrtc.ICECandidateInit{
+				SDPMid:        &mid,
+				SDPMLineIndex: &mLineIndex,
+			})
+		}
+	}
+
+	return candidates
+}
+
+// newSDPFragmentFromSessionDescription extracts the ICE credentials and candidates of a full session description
+func newSDPFragmentFromSessionDescription(sd *sdp.SessionDescription) *sdpFragment {
+	f := &sdpFragment{}
+	f.iceUfrag, _ = sd.Attribute("ice-ufrag")
+	f.icePwd, _ = sd.Attribute("ice-pwd")
+
+	for _, md := range sd.MediaDescriptions {
+		m := &sdpFragmentMedia{
+			mediaLine: md.MediaName.String(),
+		}
+		m.mid, _ = md.Attribute("mid")
+
+		for _, a := range md.Attributes {
+			switch a.Key {
+			case "ice-ufrag":
+				if f.iceUfrag == "" {
+					f.iceUfrag = a.Value
+				}
+			case "ice-pwd":
+				if f.icePwd == "" {
+					f.icePwd = a.Value
+				}
+			case "candidate":
+				m.candidates = append(m.candidates, a.String())
+			case "end-of-candidates":
+				m.endOfCandidates = true
+			}
+		}
+
+		f.media = append(f.media, m)
+	}
+
+	return f
+}
+
+func (f *sdpFragment) marshal() string {
+	sb := &strings.Builder{}
+
+	if f.iceUfrag != "" {
+		fmt.Fprintf(sb, "a=ice-ufrag:%s\r\n", f.iceUfrag)
+	}
+	if f.icePwd != "" {
+		fmt.Fprintf(sb, "a=ice-pwd:%s\r\n", f.icePwd)
+	}
+
+	for _, m := range f.media {
+		fmt.Fprintf(sb, "m=%s\r\n", m.mediaLine)
+		fmt.Fprintf(sb, "a=mid:%s\r\n", m.mid)
+		if m.iceUfrag != "" {
+			fmt.Fprintf(sb, "a=ice-ufrag:%s\r\n", m.iceUfrag)
+		}
+		if m.icePwd != "" {
+			fmt.Fprintf(sb, "a=ice-pwd:%s\r\n", m.icePwd)
+		}
+		for _, c := range m.candidates {
+			fmt.Fprintf(sb, "a=%s\r\n", c)
+		}
+		if m.endOfCandidates {
+			sb.WriteString("a=end-of-candidates\r\n")
+		}
+	}
+
+	return sb.String()
+}
@@ -0,0 +1,45 @@
+package whip
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testSDPFragment = "a=ice-ufrag:EsAw\r\n" +
+	"a=ice-pwd:P2uYro0UCOQ4zxjKXaWCBui1\r\n" +
+	"m=audio 9 RTP/AVP 0\r\n" +
+	"a=mid:0\r\n" +
+	"a=candidate:1387637174 1 udp 2122260223 192.0.2.1 61764 typ host generation 0 ufrag EsAw network-id 1\r\n" +
+	"a=candidate:3471623853 1 udp 2122194687 198.51.100.2 61765 typ host generation 0 ufrag EsAw network-id 2\r\n" +
+	"a=end-of-candidates\r\n"
+
+func TestParseSDPFragment(t *testing.T) {
+	f, err := parseSDPFragment(testSDPFragment)
+	require.NoError(t, err)
+
+	ufrag, pwd := f.credentials()
+	require.Equal(t, "EsAw", ufrag)
+	require.Equal(t, "P2uYro0UCOQ4zxjKXaWCBui1", pwd)
+
+	candidates := f.iceCandidates()
+	require.Len(t, candidates, 3)
+	require.Equal(t, "candidate:1387637174 1 udp 2122260223 192.0.2.1 61764 typ host generation 0 ufrag EsAw network-id 1", candidates[0].Candidate)
+	require.Equal(t, "0", *candidates[1].SDPMid)
+	require.Equal(t, uint16(0), *candidates[1].SDPMLineIndex)
+	// end-of-candidates
+	require.Equal(t, "", candidates[2].Candidate)
+
+	_, err = parseSDPFragment("a=candidate:1387637174 1 udp 2122260223 192.0.2.1 61764 typ host\r\n")
+	require.Error(t, err)
+
+	_, err = parseSDPFragment("not an sdp fragment")
+	require.Error(t, err)
+}
+
+func TestMarshalSDPFragment(t *testing.T) {
+	f, err := parseSDPFragment(testSDPFragment)
+	require.NoError(t, err)
+
+	require.Equal(t, testSDPFragment, f.marshal())
+}
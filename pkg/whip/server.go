@@ -3,9 +3,9 @@ package whip
 import (
 	"bytes"
 	"context"
-	"encoding/json"
 	"fmt"
 	"io"
+	"mime"
 	"net/http"
 	"strings"
 	"sync"
@@ -15,13 +15,13 @@ import (
 	"github.com/livekit/ingress/pkg/config"
 	"github.com/livekit/ingress/pkg/errors"
 	"github.com/livekit/ingress/pkg/params"
+	"github.com/livekit/ingress/pkg/stats"
 	"github.com/livekit/ingress/pkg/types"
 	"github.com/livekit/mediatransportutil/pkg/rtcconfig"
 	"github.com/livekit/protocol/logger"
 	"github.com/livekit/protocol/rpc"
 	"github.com/livekit/protocol/utils"
 	"github.com/livekit/psrpc"
-	"github.com/pion/webrtc/v3"
 )
 
 const (
@@ -41,34 +41,43 @@ type WHIPServer struct {
 
 	conf         *config.Config
 	webRTCConfig *rtcconfig.WebRTCConfig
-	onPublish    func(streamKey, resourceId string, ihs rpc.IngressHandlerServerImpl) (*params.Params, func(mimeTypes map[types.StreamKind]string, err error), func(error), error)
+	onPublish    func(streamKey, resourceId, remoteAddr string, ihs rpc.IngressHandlerServerImpl) (*params.Params, func(mimeTypes map[types.StreamKind]string, err error), func(error), error)
 	rpcClient    rpc.IngressHandlerClient
 
+	onWebSocketPublish func(streamKey, remoteAddr string) error
+
 	handlersLock sync.Mutex
 	handlers     map[string]*whipHandler
+	// resource IDs of the transcoded sessions waiting for their publisher to reconnect, by stream key
+	resumableResources map[string]string
+	webSocketSessions  map[string]*webSocketSession
 }
 
 func NewWHIPServer(rpcClient rpc.IngressHandlerClient) *WHIPServer {
 	return &WHIPServer{
-		rpcClient: rpcClient,
-		handlers:  make(map[string]*whipHandler),
+		rpcClient:          rpcClient,
+		handlers:           make(map[string]*whipHandler),
+		resumableResources: make(map[string]string),
+		webSocketSessions:  make(map[string]*webSocketSession),
 	}
 }
 
 func (s *WHIPServer) Start(
 	conf *config.Config,
-	onPublish func(streamKey, resourceId string, ihs rpc.IngressHandlerServerImpl) (*params.Params, func(mimeTypes map[types.StreamKind]string, err error), func(error), error),
+	onPublish func(streamKey, resourceId, remoteAddr string, ihs rpc.IngressHandlerServerImpl) (*params.Params, func(mimeTypes map[types.StreamKind]string, err error), func(error), error),
+	onWebSocketPublish func(streamKey, remoteAddr string) error,
 	healthHandler HealthHandler,
 ) error {
 	s.ctx, s.cancel = context.WithCancel(context.Background())
 
 	logger.Infow("starting WHIP server")
 
-	if onPublish == nil {
+	if onPublish == nil || onWebSocketPublish == nil {
 		return psrpc.NewErrorf(psrpc.Internal, "no onPublish callback provided")
 	}
 
 	s.onPublish = onPublish
+	s.onWebSocketPublish = onWebSocketPublish
 	s.conf = conf
 
 	var err error
@@ -79,6 +88,18 @@ func (s *WHIPServer) Start(
 
 	r := mux.NewRouter()
 
+	// WebSocket media chunks. Browsers cannot set the Authorization header on WebSocket connections
+	r.HandleFunc("/ws/{stream_key}", func(w http.ResponseWriter, r *http.Request) {
+		var err error
+		defer func() {
+			s.handleError(err, w)
+		}()
+
+		streamKey := mux.Vars(r)["stream_key"]
+
+		err = s.handleNewWebSocketClient(w, r, streamKey)
+	}).Methods("GET")
+
 	r.HandleFunc("/{app}", func(w http.ResponseWriter, r *http.Request) {
 		var err error
 		defer func() {
@@ -131,9 +152,23 @@ func (s *WHIPServer) Start(
 
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 
+		s.handlersLock.Lock()
+		h, ok := s.handlers[resourceID]
+		if ok {
+			// Explicitly ended, the session must not be resumed
+			h.SetDeleted()
+		}
+		s.handlersLock.Unlock()
+
 		_, err = s.rpcClient.DeleteWHIPResource(s.ctx, resourceID, req, psrpc.WithRequestTimeout(5*time.Second))
 		if err == psrpc.ErrNoResponse {
-			err = errors.ErrIngressNotFound
+			if ok {
+				// The handler cannot be reached, e.g. in standalone mode. Closing the session ends the ingress as well
+				h.Close()
+				err = nil
+			} else {
+				err = errors.ErrIngressNotFound
+			}
 		}
 	}).Methods("DELETE")
 
@@ -147,27 +182,16 @@ func (s *WHIPServer) Start(
 		vars := mux.Vars(r)
 		resourceId := vars["resource_id"]
 
-		var candidate webrtc.ICECandidateInit
-		err = json.NewDecoder(r.Body).Decode(&candidate)
-		if err != nil {
-			return
-		}
-
-		s.handlersLock.Lock()
-		h, ok := s.handlers[resourceId]
-		s.handlersLock.Unlock()
-
-		if !ok {
-			return
-		}
+		w.Header().Set("Access-Control-Allow-Origin", "*")
 
-		err = h.pc.AddICECandidate(candidate)
-		if err != nil {
+		mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil || mediaType != sdpFragmentMimeType {
+			err = nil
+			w.WriteHeader(http.StatusUnsupportedMediaType)
 			return
 		}
 
-		w.Header().Set("Content-Type", "application/trickle-ice-sdpfrag")
-		w.WriteHeader(http.StatusCreated)
+		err = s.handleICEUpdate(w, r, resourceId)
 	}).Methods("PATCH")
 
 	r.HandleFunc("/{app}/{stream_key}/{resource_id}", func(w http.ResponseWriter, r *http.Request) {
@@ -216,11 +240,39 @@ func (s *WHIPServer) AssociateRelay(resourceId string, kind types.StreamKind, w
 	return nil
 }
 
+// IsPublishing returns true if the session of the resource is still running
+func (s *WHIPServer) IsPublishing(resourceId string) bool {
+	s.handlersLock.Lock()
+	h, ok := s.handlers[resourceId]
+	s.handlersLock.Unlock()
+
+	return ok && !h.IsDeleted()
+}
+
+// GetRTPStats returns the reception statistics of the RTP streams of the running sessions, by ingress ID
+func (s *WHIPServer) GetRTPStats() map[string]map[types.StreamKind]*stats.RTPStats {
+	s.handlersLock.Lock()
+	handlers := make([]*whipHandler, 0, len(s.handlers))
+	for _, h := range s.handlers {
+		handlers = append(handlers, h)
+	}
+	s.handlersLock.Unlock()
+
+	rtpStats := make(map[string]map[types.StreamKind]*stats.RTPStats)
+	for _, h := range handlers {
+		if st := h.RTPStats(); len(st) > 0 {
+			rtpStats[h.params.IngressId] = st
+		}
+	}
+
+	return rtpStats
+}
+
 func (s *WHIPServer) IsIdle() bool {
 	s.handlersLock.Lock()
 	defer s.handlersLock.Unlock()
 
-	return len(s.handlers) == 0
+	return len(s.handlers) == 0 && len(s.webSocketSessions) == 0
 }
 
 func (s *WHIPServer) handleError(err error, w http.ResponseWriter) {
@@ -238,8 +290,6 @@ func (s *WHIPServer) handleError(err error, w http.ResponseWriter) {
 }
 
 func (s *WHIPServer) handleNewWhipClient(w http.ResponseWriter, r *http.Request, streamKey string) error {
-	// TODO return ETAG header
-
 	vars := mux.Vars(r)
 	app := vars["app"]
 
@@ -252,38 +302,114 @@ func (s *WHIPServer) handleNewWhipClient(w http.ResponseWriter, r *http.Request,
 
 	logger.Debugw("new whip request", "streamKey", streamKey, "sdpOffer", string(sdpOffer.Bytes()))
 
-	resourceId, sdp, err := s.createStream(streamKey, string(sdpOffer.Bytes()))
+	resourceId, etag, sdp, err := s.createStream(streamKey, r.RemoteAddr, string(sdpOffer.Bytes()))
 	if err != nil {
 		return err
 	}
 	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Expose-Headers", "Location")
+	w.Header().Set("Access-Control-Expose-Headers", "Location, ETag")
 	w.Header().Set("Content-Type", "application/sdp")
 	w.Header().Set("Location", fmt.Sprintf("/%s/%s/%s", app, streamKey, resourceId))
+	w.Header().Set("ETag", etag)
 	w.WriteHeader(http.StatusCreated)
 	w.Write([]byte(sdp))
 
 	return nil
 }
 
-func (s *WHIPServer) createStream(streamKey string, sdpOffer string) (string, string, error) {
+func (s *WHIPServer) handleICEUpdate(w http.ResponseWriter, r *http.Request, resourceId string) error {
+	s.handlersLock.Lock()
+	h, ok := s.handlers[resourceId]
+	s.handlersLock.Unlock()
+
+	if !ok {
+		return errors.ErrIngressNotFound
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+
+	frag, err := parseSDPFragment(string(body))
+	if err != nil {
+		return err
+	}
+
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch == "*" && h.IsICERestart(frag) {
+		logger.Debugw("handling WHIP ICE restart", "resourceID", resourceId, "sdpFragment", string(body))
+
+		ctx, done := context.WithTimeout(s.ctx, sdpResponseTimeout)
+		defer done()
+
+		answer, etag, err := h.RestartICE(ctx, frag)
+		if err != nil {
+			return err
+		}
+
+		w.Header().Set("Access-Control-Expose-Headers", "ETag")
+		w.Header().Set("Content-Type", sdpFragmentMimeType)
+		w.Header().Set("ETag", etag)
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(answer))
+
+		return nil
+	}
+
+	if ifMatch != "" && ifMatch != "*" && ifMatch != h.ETag() {
+		return errors.ErrETagMismatch
+	}
+
+	logger.Debugw("handling WHIP ICE update", "resourceID", resourceId, "sdpFragment", string(body))
+
+	err = h.AddICECandidates(frag)
+	if err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+
+	return nil
+}
+
+func (s *WHIPServer) createStream(streamKey, remoteAddr, sdpOffer string) (string, string, string, error) {
 	ctx, done := context.WithTimeout(s.ctx, sdpResponseTimeout)
 	defer done()
 
-	resourceId := utils.NewGuid(utils.WHIPResourcePrefix)
+	resourceId := s.takeResumableResource(streamKey)
+	resumed := resourceId != ""
+	if !resumed {
+		resourceId = utils.NewGuid(utils.WHIPResourcePrefix)
+	} else {
+		logger.Infow("resuming WHIP session", "streamKey", streamKey, "resourceID", resourceId)
+	}
 
 	h := NewWHIPHandler(s.webRTCConfig)
 
-	p, ready, ended, err := s.onPublish(streamKey, resourceId, h)
+	p, ready, ended, err := s.onPublish(streamKey, resourceId, remoteAddr, h)
 	if err != nil {
-		return "", "", err
+		return "", "", "", err
 	}
 
 	sdpResponse, err := h.Init(ctx, p, sdpOffer)
 	if err != nil {
-		return "", "", err
+		// Releases what was reserved for the session
+		if ready != nil {
+			ready(nil, err)
+		}
+		if resumed {
+			// Let the publisher try again
+			s.addResumableResource(streamKey, resourceId)
+		}
+		return "", "", "", err
 	}
 
+	// Register the handler right away so that trickled candidates can be added
+	s.handlersLock.Lock()
+	s.handlers[resourceId] = h
+	s.handlersLock.Unlock()
+
 	go func() {
 		ctx, done := context.WithTimeout(s.ctx, sessionStartTimeout)
 		defer done()
@@ -297,14 +423,15 @@ func (s *WHIPServer) createStream(streamKey string, sdpOffer string) (string, st
 					s.handlersLock.Lock()
 					delete(s.handlers, resourceId)
 					s.handlersLock.Unlock()
+
+					if resumed {
+						// Let the publisher try again
+						s.addResumableResource(streamKey, resourceId)
+					}
 				}
 			}()
 		}
 
-		s.handlersLock.Lock()
-		s.handlers[resourceId] = h
-		s.handlersLock.Unlock()
-
 		mimeTypes, err = h.Start(ctx)
 		if err != nil {
 			return
@@ -323,6 +450,10 @@ func (s *WHIPServer) createStream(streamKey string, sdpOffer string) (string, st
 					logger.Warnw("WHIP session failed", err, "streamKey", streamKey, "resourceID", resourceId)
 				}
 
+				if !p.BypassTranscoding && !h.IsDeleted() {
+					s.addResumableResource(streamKey, resourceId)
+				}
+
 				if ended != nil {
 					ended(err)
 				}
@@ -332,14 +463,47 @@ func (s *WHIPServer) createStream(streamKey string, sdpOffer string) (string, st
 		}()
 	}()
 
-	return resourceId, sdpResponse, nil
+	return resourceId, h.ETag(), sdpResponse, nil
+}
+
+// addResumableResource lets a publisher reconnecting within the grace period feed the handler of its previous session
+func (s *WHIPServer) addResumableResource(streamKey string, resourceId string) {
+	gracePeriod := time.Duration(s.conf.ReconnectGracePeriodMs) * time.Millisecond
+	if gracePeriod <= 0 {
+		return
+	}
+
+	s.handlersLock.Lock()
+	s.resumableResources[streamKey] = resourceId
+	s.handlersLock.Unlock()
+
+	time.AfterFunc(gracePeriod, func() {
+		s.handlersLock.Lock()
+		defer s.handlersLock.Unlock()
+
+		if s.resumableResources[streamKey] == resourceId {
+			delete(s.resumableResources, streamKey)
+		}
+	})
+}
+
+func (s *WHIPServer) takeResumableResource(streamKey string) string {
+	s.handlersLock.Lock()
+	defer s.handlersLock.Unlock()
+
+	resourceId := s.resumableResources[streamKey]
+	delete(s.resumableResources, streamKey)
+
+	return resourceId
 }
 
 func setCORSHeaders(w http.ResponseWriter, r *http.Request, resourceEndpoint bool) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Headers", "*")
 	if resourceEndpoint {
+		w.Header().Set("Accept-Patch", sdpFragmentMimeType)
 		w.Header().Set("Access-Control-Allow-Methods", "PATCH, OPTIONS, DELETE")
+		w.Header().Set("Access-Control-Expose-Headers", "ETag")
 	} else {
 		w.Header().Set("Accept-Post", "application/sdp")
 		w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
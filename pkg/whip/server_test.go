@@ -0,0 +1,42 @@
+package whip
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/ingress/pkg/config"
+	"github.com/livekit/ingress/pkg/errors"
+	"github.com/livekit/ingress/pkg/params"
+	"github.com/livekit/ingress/pkg/types"
+	"github.com/livekit/protocol/livekit"
+	"github.com/livekit/protocol/rpc"
+)
+
+func TestCreateStreamInitFailure(t *testing.T) {
+	s := NewWHIPServer(nil)
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	defer s.cancel()
+	s.conf = &config.Config{}
+
+	var readyErrs []error
+	s.onPublish = func(streamKey, resourceId, remoteAddr string, _ rpc.IngressHandlerServerImpl) (*params.Params, func(map[types.StreamKind]string, error), func(error), error) {
+		p := &params.Params{
+			IngressInfo: &livekit.IngressInfo{IngressId: "IN_test", StreamKey: streamKey},
+			ExtraParams: &params.WhipExtraParams{ResourceId: resourceId},
+		}
+		ready := func(_ map[types.StreamKind]string, err error) {
+			readyErrs = append(readyErrs, err)
+		}
+
+		return p, ready, nil, nil
+	}
+
+	// Rejected by Init, the reservation made by onPublish must be released
+	_, _, _, err := s.createStream("stream_key", "127.0.0.1:1234", newTestOffer(testVideoSection, testVideoSection).SDP)
+	require.ErrorIs(t, err, errors.ErrDuplicateTrack)
+	require.Len(t, readyErrs, 1)
+	require.ErrorIs(t, readyErrs[0], errors.ErrDuplicateTrack)
+	require.Empty(t, s.handlers)
+}
@@ -0,0 +1,171 @@
+package whip
+
+import (
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/frostbyte73/core"
+	"github.com/gorilla/websocket"
+
+	"github.com/livekit/ingress/pkg/errors"
+	"github.com/livekit/ingress/pkg/utils"
+	"github.com/livekit/protocol/logger"
+)
+
+const (
+	// MediaRecorder chunks are usually a few hundred KB at most
+	maxWebSocketMessageSize = 8 << 20
+	webSocketPingInterval   = 10 * time.Second
+	webSocketPongTimeout    = 30 * time.Second
+)
+
+var upgrader = websocket.Upgrader{
+	// Browser clients can be served from any origin, the stream key authenticates the publisher
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// webSocketSession relays the media chunks received on a WebSocket, e.g. from a MediaRecorder producing WebM or
+// fragmented MP4, to the handler of the ingress. The chunks are decoded as a single stream by the handler.
+type webSocketSession struct {
+	streamKey   string
+	mediaBuffer *utils.PrerollBuffer
+	log         logger.Logger
+	closed      core.Fuse
+}
+
+func (s *WHIPServer) handleNewWebSocketClient(w http.ResponseWriter, r *http.Request, streamKey string) error {
+	log := logger.GetLogger().WithValues("streamKey", streamKey, "remoteAddr", r.RemoteAddr)
+
+	session := &webSocketSession{
+		streamKey: streamKey,
+		log:       log,
+		closed:    core.NewFuse(),
+	}
+	session.mediaBuffer = utils.NewPrerollBuffer(func() error {
+		// The container headers are in the first chunks, the stream cannot be decoded anymore
+		return errors.ErrPrerollBufferReset
+	})
+
+	// Store the session before admitting the stream and upgrading the connection, to keep concurrent clients
+	// with the same stream key out and to make sure the relay can find it
+	s.handlersLock.Lock()
+	if _, ok := s.webSocketSessions[streamKey]; ok {
+		s.handlersLock.Unlock()
+		return errors.ErrStreamKeyInUse
+	}
+	s.webSocketSessions[streamKey] = session
+	s.handlersLock.Unlock()
+
+	defer func() {
+		s.handlersLock.Lock()
+		if s.webSocketSessions[streamKey] == session {
+			delete(s.webSocketSessions, streamKey)
+		}
+		s.handlersLock.Unlock()
+	}()
+
+	if err := s.onWebSocketPublish(streamKey, r.RemoteAddr); err != nil {
+		log.Infow("rejecting WebSocket connection", "error", err)
+		session.Close()
+		return err
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader already responded
+		log.Infow("failed to upgrade WebSocket connection", "error", err)
+		session.Close()
+		return nil
+	}
+
+	log.Infow("Received a new published stream")
+
+	session.Run(conn)
+
+	return nil
+}
+
+func (s *WHIPServer) AssociateWebSocketRelay(streamKey string, w io.WriteCloser) error {
+	s.handlersLock.Lock()
+	session, ok := s.webSocketSessions[streamKey]
+	s.handlersLock.Unlock()
+	if !ok || session == nil {
+		return errors.ErrIngressNotFound
+	}
+
+	return session.mediaBuffer.SetWriter(w)
+}
+
+func (s *WHIPServer) DissociateWebSocketRelay(streamKey string) error {
+	s.handlersLock.Lock()
+	session, ok := s.webSocketSessions[streamKey]
+	s.handlersLock.Unlock()
+	if !ok || session == nil {
+		return errors.ErrIngressNotFound
+	}
+
+	// The handler is gone, disconnect the publisher
+	session.Close()
+
+	return nil
+}
+
+func (s *webSocketSession) Run(conn *websocket.Conn) {
+	defer func() {
+		conn.Close()
+		s.Close()
+	}()
+
+	conn.SetReadLimit(maxWebSocketMessageSize)
+	_ = conn.SetReadDeadline(time.Now().Add(webSocketPongTimeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(webSocketPongTimeout))
+	})
+
+	go func() {
+		ticker := time.NewTicker(webSocketPingInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-s.closed.Watch():
+				_ = conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+				conn.Close()
+				return
+			case <-ticker.C:
+				if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(time.Second)); err != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	for {
+		messageType, data, err := conn.ReadMessage()
+		if err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) && !s.closed.IsBroken() {
+				s.log.Infow("WebSocket connection read failed", "error", err)
+			}
+			return
+		}
+		_ = conn.SetReadDeadline(time.Now().Add(webSocketPongTimeout))
+
+		if messageType != websocket.BinaryMessage {
+			continue
+		}
+
+		if _, err = s.mediaBuffer.Write(data); err != nil {
+			s.log.Errorw("failed to write media", err)
+			return
+		}
+	}
+}
+
+func (s *webSocketSession) Close() {
+	s.closed.Once(func() {
+		s.log.Infow("closing ingress WebSocket session")
+
+		s.mediaBuffer.Close()
+	})
+}
@@ -2,17 +2,24 @@ package whip
 
 import (
 	"context"
+	"fmt"
 	"io"
+	"strings"
 	"sync"
 
+	"github.com/frostbyte73/core"
 	"github.com/pion/interceptor"
+	pionstats "github.com/pion/interceptor/pkg/stats"
 	"github.com/pion/rtcp"
+	"github.com/pion/sdp/v3"
 	"github.com/pion/webrtc/v3"
 	google_protobuf2 "google.golang.org/protobuf/types/known/emptypb"
 
 	"github.com/livekit/ingress/pkg/errors"
 	"github.com/livekit/ingress/pkg/lksdk_output"
 	"github.com/livekit/ingress/pkg/params"
+	"github.com/livekit/ingress/pkg/recorder"
+	"github.com/livekit/ingress/pkg/stats"
 	"github.com/livekit/ingress/pkg/types"
 	"github.com/livekit/mediatransportutil/pkg/rtcconfig"
 	"github.com/livekit/protocol/livekit"
@@ -30,6 +37,12 @@ const (
 
 // TODO log ingress id / resource ID
 
+// trackKey identifies a remote track. Simulcast layers of a video track are told apart by their RID.
+type trackKey struct {
+	kind types.StreamKind
+	rid  string
+}
+
 type whipHandler struct {
 	logger logger.Logger
 	params *params.Params
@@ -39,27 +52,50 @@ type whipHandler struct {
 	sync               *synchronizer.Synchronizer
 	sdkOutput          *lksdk_output.LKSDKOutput // only for passthrough
 	expectedTrackCount int
+	videoLayerCount    int
+	videoLayerGroup    *videoLayerGroup          // only for passthrough
+	recorders          []*recorder.TrackRecorder // only for passthrough
+	recordingTimeline  *recorder.Timeline        // only for passthrough
 	result             chan error
 	closeOnce          sync.Once
+	deleted            core.Fuse
+
+	iceLock     sync.Mutex
+	etag        string
+	remoteUfrag string
+	remotePwd   string
 
 	trackLock           sync.Mutex
-	tracks              map[string]*webrtc.TrackRemote
-	trackHandlers       map[types.StreamKind]*whipTrackHandler
+	tracks              map[trackKey]*webrtc.TrackRemote
+	trackHandlers       map[trackKey]*whipTrackHandler
 	trackRelayMediaSink map[types.StreamKind]*RelayMediaSink // only for transcoding mode
 	trackAddedChan      chan *webrtc.TrackRemote
+	statsGetter         pionstats.Getter
 }
 
 func NewWHIPHandler(webRTCConfig *rtcconfig.WebRTCConfig) *whipHandler {
 	return &whipHandler{
 		rtcConfig:           webRTCConfig,
+		etag:                newETag(),
 		sync:                synchronizer.NewSynchronizer(nil),
+		recordingTimeline:   recorder.NewTimeline(),
 		result:              make(chan error, 1),
-		tracks:              make(map[string]*webrtc.TrackRemote),
-		trackHandlers:       make(map[types.StreamKind]*whipTrackHandler),
+		deleted:             core.NewFuse(),
+		tracks:              make(map[trackKey]*webrtc.TrackRemote),
+		trackHandlers:       make(map[trackKey]*whipTrackHandler),
 		trackRelayMediaSink: make(map[types.StreamKind]*RelayMediaSink),
 	}
 }
 
+// SetDeleted records that the publisher explicitly ended the session
+func (h *whipHandler) SetDeleted() {
+	h.deleted.Break()
+}
+
+func (h *whipHandler) IsDeleted() bool {
+	return h.deleted.IsBroken()
+}
+
 func (h *whipHandler) Init(ctx context.Context, p *params.Params, sdpOffer string) (string, error) {
 	var err error
 
@@ -77,13 +113,20 @@ func (h *whipHandler) Init(ctx context.Context, p *params.Params, sdpOffer strin
 		Type: webrtc.SDPTypeOffer,
 		SDP:  sdpOffer,
 	}
-	h.expectedTrackCount, err = validateOfferAndGetExpectedTrackCount(offer)
+	// Simulcast layers are only forwarded as is. When transcoding, a single layer is negotiated
+	simulcast := p.IngressInfo.BypassTranscoding
+	h.expectedTrackCount, h.videoLayerCount, err = validateOfferAndGetExpectedTrackCount(offer, simulcast)
 	h.trackAddedChan = make(chan *webrtc.TrackRemote, h.expectedTrackCount)
 	if err != nil {
 		return "", err
 	}
 
-	m, err := newMediaEngine()
+	h.remoteUfrag, h.remotePwd, err = getICECredentials(offer)
+	if err != nil {
+		return "", err
+	}
+
+	m, err := newMediaEngine(simulcast)
 	if err != nil {
 		return "", err
 	}
@@ -99,6 +142,18 @@ func (h *whipHandler) Init(ctx context.Context, p *params.Params, sdpOffer strin
 		return "", err
 	}
 
+	// Collect the reception statistics of the RTP streams
+	statsInterceptor, err := pionstats.NewInterceptor()
+	if err != nil {
+		return "", err
+	}
+	statsInterceptor.OnNewPeerConnection(func(_ string, g pionstats.Getter) {
+		h.trackLock.Lock()
+		h.statsGetter = g
+		h.trackLock.Unlock()
+	})
+	i.Add(statsInterceptor)
+
 	// Create the API object with the MediaEngine
 	api := webrtc.NewAPI(webrtc.WithMediaEngine(m), webrtc.WithSettingEngine(h.rtcConfig.SettingEngine), webrtc.WithInterceptorRegistry(i))
 	h.pc, err = h.createPeerConnection(api)
@@ -152,6 +207,42 @@ loop:
 	return mimeTypes, nil
 }
 
+// RTPStats returns the reception statistics of the RTP streams of the session, per media kind
+func (h *whipHandler) RTPStats() map[types.StreamKind]*stats.RTPStats {
+	h.trackLock.Lock()
+	defer h.trackLock.Unlock()
+
+	if h.statsGetter == nil {
+		return nil
+	}
+
+	rtpStats := make(map[types.StreamKind]*stats.RTPStats)
+	for key, track := range h.tracks {
+		s := h.statsGetter.Get(uint32(track.SSRC()))
+		if s == nil {
+			continue
+		}
+
+		st, ok := rtpStats[key.kind]
+		if !ok {
+			st = &stats.RTPStats{}
+			rtpStats[key.kind] = st
+		}
+		st.PacketsReceived += s.InboundRTPStreamStats.PacketsReceived
+		st.PacketsLost += s.InboundRTPStreamStats.PacketsLost
+		st.NACKs += uint64(s.InboundRTPStreamStats.NACKCount)
+
+		// The jitter is measured in RTP timestamp units
+		if clockRate := track.Codec().ClockRate; clockRate > 0 {
+			if jitter := s.InboundRTPStreamStats.Jitter / float64(clockRate); jitter > st.Jitter {
+				st.Jitter = jitter
+			}
+		}
+	}
+
+	return rtpStats
+}
+
 func (h *whipHandler) Close() {
 	if h.pc != nil {
 		h.pc.Close()
@@ -165,6 +256,9 @@ func (h *whipHandler) WaitForSessionEnd(ctx context.Context) error {
 		if h.sdkOutput != nil {
 			h.sdkOutput.Close()
 		}
+		for _, r := range h.recorders {
+			r.Close()
+		}
 	}()
 
 	var trackDoneCount int
@@ -186,6 +280,112 @@ func (h *whipHandler) WaitForSessionEnd(ctx context.Context) error {
 	}
 }
 
+func (h *whipHandler) ETag() string {
+	h.iceLock.Lock()
+	defer h.iceLock.Unlock()
+
+	return h.etag
+}
+
+// AddICECandidates adds the candidates trickled by the client to the current ICE session
+func (h *whipHandler) AddICECandidates(frag *sdpFragment) error {
+	h.iceLock.Lock()
+	defer h.iceLock.Unlock()
+
+	ufrag, pwd := frag.credentials()
+	if (ufrag != "" && ufrag != h.remoteUfrag) || (pwd != "" && pwd != h.remotePwd) {
+		return errors.ErrICECredentialsMismatch
+	}
+
+	for _, c := range frag.iceCandidates() {
+		h.logger.Debugw("adding remote ICE candidate", "candidate", c.Candidate)
+
+		err := h.pc.AddICECandidate(c)
+		if err != nil {
+			return psrpc.NewError(psrpc.InvalidArgument, err)
+		}
+	}
+
+	return nil
+}
+
+// IsICERestart returns true if the fragment carries new ICE credentials
+func (h *whipHandler) IsICERestart(frag *sdpFragment) bool {
+	h.iceLock.Lock()
+	defer h.iceLock.Unlock()
+
+	ufrag, _ := frag.credentials()
+
+	return ufrag != "" && ufrag != h.remoteUfrag
+}
+
+// RestartICE restarts the ICE session with the credentials and candidates provided by the client.
+// It returns the answer SDP fragment with the new local credentials and candidates, and the new resource ETag.
+// Tracks and the room participant are kept as is.
+func (h *whipHandler) RestartICE(ctx context.Context, frag *sdpFragment) (string, string, error) {
+	h.iceLock.Lock()
+	defer h.iceLock.Unlock()
+
+	ufrag, pwd := frag.credentials()
+	if ufrag == "" || pwd == "" {
+		return "", "", psrpc.NewErrorf(psrpc.InvalidArgument, "missing ICE credentials in SDP fragment")
+	}
+
+	h.logger.Infow("restarting ICE")
+
+	remoteDescription := h.pc.RemoteDescription()
+	if remoteDescription == nil {
+		return "", "", errors.ErrSourceNotReady
+	}
+
+	offer, err := replaceICECredentials(remoteDescription, ufrag, pwd)
+	if err != nil {
+		return "", "", err
+	}
+
+	// Pion restarts the ICE agent when the remote credentials change in a new offer
+	err = h.pc.SetRemoteDescription(*offer)
+	if err != nil {
+		return "", "", err
+	}
+
+	answer, err := h.pc.CreateAnswer(nil)
+	if err != nil {
+		return "", "", err
+	}
+
+	gatherComplete := webrtc.GatheringCompletePromise(h.pc)
+
+	if err = h.pc.SetLocalDescription(answer); err != nil {
+		return "", "", err
+	}
+
+	select {
+	case <-gatherComplete:
+		// success
+	case <-ctx.Done():
+		return "", "", psrpc.NewErrorf(psrpc.DeadlineExceeded, "timed out while waiting for ICE candidate gathering")
+	}
+
+	h.remoteUfrag = ufrag
+	h.remotePwd = pwd
+	h.etag = newETag()
+
+	for _, c := range frag.iceCandidates() {
+		err := h.pc.AddICECandidate(c)
+		if err != nil {
+			h.logger.Infow("failed adding remote ICE candidate", "error", err, "candidate", c.Candidate)
+		}
+	}
+
+	parsedAnswer, err := h.pc.LocalDescription().Unmarshal()
+	if err != nil {
+		return "", "", err
+	}
+
+	return newSDPFragmentFromSessionDescription(parsedAnswer).marshal(), h.etag, nil
+}
+
 func (h *whipHandler) AssociateRelay(kind types.StreamKind, w io.WriteCloser) error {
 	h.trackLock.Lock()
 	defer h.trackLock.Unlock()
@@ -223,8 +423,8 @@ func (h *whipHandler) createPeerConnection(api *webrtc.API) (*webrtc.PeerConnect
 	pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
 		h.logger.Infow("Peer Connection State changed", "state", state.String())
 
-		// TODO support ICE Restart
-		if state >= webrtc.PeerConnectionStateDisconnected {
+		// Disconnected sessions may recover, either by themselves or after an ICE restart
+		if state >= webrtc.PeerConnectionStateFailed {
 			h.closeOnce.Do(func() {
 				h.sync.End()
 
@@ -268,11 +468,15 @@ func (h *whipHandler) getSDPAnswer(ctx context.Context, offer *webrtc.SessionDes
 		return "", psrpc.NewErrorf(psrpc.DeadlineExceeded, "timed out while waiting for ICE candidate gathering")
 	}
 
+	parsedOffer, err := offer.Unmarshal()
+	if err != nil {
+		return "", err
+	}
 	parsedAnswer, err := h.pc.LocalDescription().Unmarshal()
 	if err != nil {
 		return "", err
 	}
-	if len(parsedAnswer.MediaDescriptions) != h.expectedTrackCount {
+	if len(parsedAnswer.MediaDescriptions) != len(parsedOffer.MediaDescriptions) {
 		return "", errors.ErrUnsupportedDecodeFormat
 	}
 	for _, m := range parsedAnswer.MediaDescriptions {
@@ -290,13 +494,15 @@ func (h *whipHandler) getSDPAnswer(ctx context.Context, offer *webrtc.SessionDes
 
 func (h *whipHandler) addTrack(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
 	kind := streamKindFromCodecType(track.Kind())
-	logger := h.logger.WithValues("trackID", track.ID(), "kind", kind)
+	logger := h.logger.WithValues("trackID", track.ID(), "kind", kind, "rid", track.RID())
 
 	logger.Infow("track has started", "type", track.PayloadType(), "codec", track.Codec().MimeType)
 
+	key := trackKey{kind: kind, rid: track.RID()}
+
 	h.trackLock.Lock()
 	defer h.trackLock.Unlock()
-	h.tracks[track.ID()] = track
+	h.tracks[key] = track
 
 	sync := h.sync.AddTrack(track, whipIdentity)
 
@@ -311,7 +517,7 @@ func (h *whipHandler) addTrack(track *webrtc.TrackRemote, receiver *webrtc.RTPRe
 		logger.Warnw("failed creating whip track handler", err)
 		return
 	}
-	h.trackHandlers[kind] = th
+	h.trackHandlers[key] = th
 
 	select {
 	case h.trackAddedChan <- track:
@@ -323,7 +529,19 @@ func (h *whipHandler) addTrack(track *webrtc.TrackRemote, receiver *webrtc.RTPRe
 func (h *whipHandler) newMediaSink(track *webrtc.TrackRemote) (MediaSink, error) {
 	if h.sdkOutput != nil {
 		// pasthrough
-		return NewSDKMediaSink(h.logger, h.sdkOutput, track, func() {
+		var layerGroup *videoLayerGroup
+		var rec *recorder.TrackRecorder
+		if track.Kind() == webrtc.RTPCodecTypeVideo {
+			// All simulcast layers are published together as a single track
+			if h.videoLayerGroup == nil {
+				h.videoLayerGroup = newVideoLayerGroup(h.logger, h.sdkOutput, track.Codec().MimeType, h.videoLayerCount, h.newRecorder(track))
+			}
+			layerGroup = h.videoLayerGroup
+		} else {
+			rec = h.newRecorder(track)
+		}
+
+		return NewSDKMediaSink(h.logger.WithValues("rid", track.RID()), h.sdkOutput, track, layerGroup, rec, func() {
 			h.writePLI(track.SSRC())
 		}), nil
 	} else {
@@ -336,6 +554,23 @@ func (h *whipHandler) newMediaSink(track *webrtc.TrackRemote) (MediaSink, error)
 	}
 }
 
+// newRecorder returns nil if recording is disabled. Recording failures do not affect the ingress
+func (h *whipHandler) newRecorder(track *webrtc.TrackRemote) *recorder.TrackRecorder {
+	if !h.params.Recording.Enabled {
+		return nil
+	}
+
+	kind := streamKindFromCodecType(track.Kind())
+	r, err := recorder.NewTrackRecorder(&h.params.Recording, h.recordingTimeline, h.params.IngressId, kind, track.Codec().MimeType)
+	if err != nil {
+		h.logger.Errorw("could not create recorder", err, "kind", kind)
+		return nil
+	}
+	h.recorders = append(h.recorders, r)
+
+	return r
+}
+
 func (h *whipHandler) writePLI(ssrc webrtc.SSRC) {
 	h.logger.Debugw("sending PLI request", "ssrc", ssrc)
 	pli := []rtcp.Packet{
@@ -358,27 +593,141 @@ func streamKindFromCodecType(typ webrtc.RTPCodecType) types.StreamKind {
 	}
 }
 
-func validateOfferAndGetExpectedTrackCount(offer *webrtc.SessionDescription) (int, error) {
+func validateOfferAndGetExpectedTrackCount(offer *webrtc.SessionDescription, simulcast bool) (int, int, error) {
 	parsed, err := offer.Unmarshal()
 	if err != nil {
-		return 0, err
+		return 0, 0, err
 	}
 
+	var trackCount, videoLayerCount int
 	mediaTypes := make(map[string]struct{})
 	for _, m := range parsed.MediaDescriptions {
 		if _, ok := mediaTypes[m.MediaName.Media]; ok {
 			// Duplicate track for a given type. Forbidden by the RFC
-			return 0, errors.ErrDuplicateTrack
+			return 0, 0, errors.ErrDuplicateTrack
 		}
 		mediaTypes[m.MediaName.Media] = struct{}{}
+
+		layerCount := 1
+		if simulcast && m.MediaName.Media == "video" {
+			layerCount = getSimulcastLayerCount(m)
+		}
+		if m.MediaName.Media == "video" {
+			videoLayerCount = layerCount
+		}
+
+		trackCount += layerCount
+	}
+
+	return trackCount, videoLayerCount, nil
+}
+
+// getSimulcastLayerCount returns the number of RIDs in the send direction of the a=simulcast attribute, or 1 if the media isn't simulcast
+func getSimulcastLayerCount(m *sdp.MediaDescription) int {
+	simulcast, ok := m.Attribute("simulcast")
+	if !ok {
+		return 1
+	}
+
+	// e.g. "send h;m;l" or "send 1,2;3 recv 4", https://www.rfc-editor.org/rfc/rfc8853
+	fields := strings.Fields(simulcast)
+	for i := 0; i+1 < len(fields); i += 2 {
+		if fields[i] != "send" {
+			continue
+		}
+
+		// Streams are separated by ';', alternatives of a given stream by ','
+		return len(strings.Split(fields[i+1], ";"))
 	}
 
-	return len(parsed.MediaDescriptions), nil
+	return 1
 }
 
-func newMediaEngine() (*webrtc.MediaEngine, error) {
+func getICECredentials(offer *webrtc.SessionDescription) (string, string, error) {
+	parsed, err := offer.Unmarshal()
+	if err != nil {
+		return "", "", err
+	}
+
+	ufrag, _ := parsed.Attribute("ice-ufrag")
+	pwd, _ := parsed.Attribute("ice-pwd")
+	for _, m := range parsed.MediaDescriptions {
+		// All media sections are bundled, and so share the same credentials
+		if v, ok := m.Attribute("ice-ufrag"); ok {
+			ufrag = v
+		}
+		if v, ok := m.Attribute("ice-pwd"); ok {
+			pwd = v
+		}
+	}
+
+	if ufrag == "" || pwd == "" {
+		return "", "", psrpc.NewErrorf(psrpc.InvalidArgument, "missing ICE credentials in SDP offer")
+	}
+
+	return ufrag, pwd, nil
+}
+
+// replaceICECredentials returns a copy of the session description with the given ICE credentials and all candidates removed
+func replaceICECredentials(desc *webrtc.SessionDescription, ufrag, pwd string) (*webrtc.SessionDescription, error) {
+	parsed, err := desc.Unmarshal()
+	if err != nil {
+		return nil, err
+	}
+
+	updateAttributes := func(attrs []sdp.Attribute) []sdp.Attribute {
+		var ret []sdp.Attribute
+		for _, a := range attrs {
+			switch a.Key {
+			case "ice-ufrag":
+				a.Value = ufrag
+			case "ice-pwd":
+				a.Value = pwd
+			case "candidate", "end-of-candidates":
+				continue
+			}
+			ret = append(ret, a)
+		}
+
+		return ret
+	}
+
+	parsed.Attributes = updateAttributes(parsed.Attributes)
+	for _, m := range parsed.MediaDescriptions {
+		m.Attributes = updateAttributes(m.Attributes)
+	}
+
+	b, err := parsed.Marshal()
+	if err != nil {
+		return nil, err
+	}
+
+	return &webrtc.SessionDescription{
+		Type: webrtc.SDPTypeOffer,
+		SDP:  string(b),
+	}, nil
+}
+
+func newETag() string {
+	return fmt.Sprintf("%q", utils.NewGuid(""))
+}
+
+func newMediaEngine(simulcast bool) (*webrtc.MediaEngine, error) {
 	m := &webrtc.MediaEngine{}
 
+	if simulcast {
+		// Needed to demux the RTP streams of the simulcast layers
+		for _, extension := range []string{
+			sdp.SDESMidURI,
+			sdp.SDESRTPStreamIDURI,
+			"urn:ietf:params:rtp-hdrext:sdes:repaired-rtp-stream-id",
+		} {
+			if err := m.RegisterHeaderExtension(webrtc.RTPHeaderExtensionCapability{URI: extension}, webrtc.RTPCodecTypeVideo); err != nil {
+				return nil, err
+			}
+		}
+	}
+
 	for _, codec := range []webrtc.RTPCodecParameters{
 		{
 			RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypePCMA, ClockRate: 8000},
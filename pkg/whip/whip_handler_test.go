@@ -0,0 +1,89 @@
+package whip
+
+import (
+	"testing"
+
+	"github.com/pion/sdp/v3"
+	"github.com/pion/webrtc/v3"
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/ingress/pkg/errors"
+)
+
+const (
+	testAudioSection = "m=audio 9 UDP/TLS/RTP/SAVPF 111\r\n" +
+		"c=IN IP4 0.0.0.0\r\n" +
+		"a=mid:0\r\n" +
+		"a=sendonly\r\n" +
+		"a=rtpmap:111 opus/48000/2\r\n"
+
+	testVideoSection = "m=video 9 UDP/TLS/RTP/SAVPF 96\r\n" +
+		"c=IN IP4 0.0.0.0\r\n" +
+		"a=mid:1\r\n" +
+		"a=sendonly\r\n" +
+		"a=rtpmap:96 VP8/90000\r\n"
+
+	testSimulcastAttributes = "a=rid:h send\r\n" +
+		"a=rid:m send\r\n" +
+		"a=rid:l send\r\n" +
+		"a=simulcast:send h;m;l\r\n"
+)
+
+func newTestOffer(sections ...string) *webrtc.SessionDescription {
+	s := "v=0\r\n" +
+		"o=- 4215775240449105457 2 IN IP4 127.0.0.1\r\n" +
+		"s=-\r\n" +
+		"t=0 0\r\n" +
+		"a=ice-ufrag:EsAw\r\n" +
+		"a=ice-pwd:P2uYro0UCOQ4zxjKXaWCBui1\r\n"
+	for _, section := range sections {
+		s += section
+	}
+
+	return &webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: s}
+}
+
+func TestGetSimulcastLayerCount(t *testing.T) {
+	for attribute, expected := range map[string]int{
+		"":                  1,
+		"send h;m;l":        3,
+		"send 1,2;3 recv 4": 2,
+		"recv 4 send h;l":   2,
+		"recv h;m;l":        1,
+		"send h;~m":         2,
+	} {
+		m := &sdp.MediaDescription{}
+		if attribute != "" {
+			m = m.WithValueAttribute("simulcast", attribute)
+		}
+		require.Equal(t, expected, getSimulcastLayerCount(m), attribute)
+	}
+}
+
+func TestValidateOffer(t *testing.T) {
+	trackCount, videoLayerCount, err := validateOfferAndGetExpectedTrackCount(newTestOffer(testAudioSection, testVideoSection), false)
+	require.NoError(t, err)
+	require.Equal(t, 2, trackCount)
+	require.Equal(t, 1, videoLayerCount)
+
+	// Each simulcast layer is a separate remote track
+	simulcastOffer := newTestOffer(testAudioSection, testVideoSection+testSimulcastAttributes)
+	trackCount, videoLayerCount, err = validateOfferAndGetExpectedTrackCount(simulcastOffer, true)
+	require.NoError(t, err)
+	require.Equal(t, 4, trackCount)
+	require.Equal(t, 3, videoLayerCount)
+
+	// Not negotiated when transcoding
+	trackCount, videoLayerCount, err = validateOfferAndGetExpectedTrackCount(simulcastOffer, false)
+	require.NoError(t, err)
+	require.Equal(t, 2, trackCount)
+	require.Equal(t, 1, videoLayerCount)
+
+	trackCount, videoLayerCount, err = validateOfferAndGetExpectedTrackCount(newTestOffer(testAudioSection), true)
+	require.NoError(t, err)
+	require.Equal(t, 1, trackCount)
+	require.Equal(t, 0, videoLayerCount)
+
+	_, _, err = validateOfferAndGetExpectedTrackCount(newTestOffer(testVideoSection, testVideoSection), false)
+	require.ErrorIs(t, err, errors.ErrDuplicateTrack)
+}
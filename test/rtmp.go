@@ -21,7 +21,7 @@ import (
 
 func RunRTMPTest(t *testing.T, conf *TestConfig, bus psrpc.MessageBus, svc *service.Service, commandPsrpcClient rpc.IngressHandlerClient) {
 	rtmpsrv := rtmp.NewRTMPServer()
-	relay := service.NewRelay(rtmpsrv, nil)
+	relay := service.NewRelay(rtmpsrv, nil, nil)
 
 	err := rtmpsrv.Start(conf.Config, svc.HandleRTMPPublishRequest)
 	require.NoError(t, err)
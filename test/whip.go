@@ -26,7 +26,7 @@ const (
 
 func RunWHIPTest(t *testing.T, conf *TestConfig, bus psrpc.MessageBus, svc *service.Service, commandPsrpcClient rpc.IngressHandlerClient) {
 	whipsrv := whip.NewWHIPServer(commandPsrpcClient)
-	relay := service.NewRelay(nil, whipsrv)
+	relay := service.NewRelay(nil, whipsrv, nil)
 
 	err := whipsrv.Start(conf.Config, svc.HandleWHIPPublishRequest, svc)
 	require.NoError(t, err)